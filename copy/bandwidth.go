@@ -0,0 +1,65 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter limits the aggregate rate of reads by all readers using it.
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+	mutex          sync.Mutex
+	next           time.Time // The time at which all previously reserved bytes have been "paid for"
+}
+
+// newBandwidthLimiter returns a bandwidthLimiter allowing bytesPerSecond, which must be positive.
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	return &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until reading n more bytes fits within the limit, or until ctx is done.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	delay := l.next.Sub(now)
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitedReader is an io.Reader which reads from source, at the rate allowed by limiter.
+type rateLimitedReader struct {
+	ctx     context.Context
+	source  io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Don't read more than a second's worth of data at once, so that the rate is reasonably smooth.
+	if int64(len(p)) > r.limiter.bytesPerSecond {
+		p = p[:r.limiter.bytesPerSecond]
+	}
+	n, err := r.source.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,48 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitedReader(t *testing.T) {
+	data := bytes.Repeat([]byte{'x'}, 1000)
+
+	// A single reader
+	limiter := newBandwidthLimiter(5000)
+	start := time.Now()
+	read, err := ioutil.ReadAll(&rateLimitedReader{ctx: context.Background(), source: bytes.NewReader(data), limiter: limiter})
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// The limit is shared by concurrent readers
+	limiter = newBandwidthLimiter(10000)
+	start = time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			read, err := ioutil.ReadAll(&rateLimitedReader{ctx: context.Background(), source: bytes.NewReader(data), limiter: limiter})
+			assert.NoError(t, err)
+			assert.Equal(t, data, read)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// Canceling the context
+	limiter = newBandwidthLimiter(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ioutil.ReadAll(&rateLimitedReader{ctx: ctx, source: bytes.NewReader(data), limiter: limiter})
+	assert.Equal(t, context.Canceled, err)
+}
@@ -13,13 +13,14 @@ import (
 	"time"
 
 	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
 	"github.com/containers/image/pkg/blobinfocache"
 	"github.com/containers/image/pkg/compression"
 	"github.com/containers/image/signature"
 	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
-	"github.com/klauspost/pgzip"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
@@ -35,7 +36,7 @@ type digestingReader struct {
 }
 
 // maxParallelDownloads is used to limit the maxmimum number of parallel
-// downloads.  Let's follow Firefox by limiting it to 6.
+// downloads, unless overridden in Options.MaxParallelDownloads.  Let's follow Firefox by limiting it to 6.
 var maxParallelDownloads = 6
 
 // newDigestingReader returns an io.Reader implementation with contents of source, which will eventually return a non-EOF error
@@ -86,8 +87,19 @@ type copier struct {
 	reportWriter     io.Writer
 	progressInterval time.Duration
 	progress         chan types.ProgressProperties
+	progressEvents   bool
 	blobInfoCache    types.BlobInfoCache
 	copyInParallel   bool
+	// compressionFormat and compressionLevel are used when compressing layers for c.dest.
+	compressionFormat compression.Algorithm
+	compressionLevel  *int
+	// recompressLayers is true if the user has explicitly chosen compressionFormat,
+	// so that layers compressed using other algorithms should be recompressed.
+	recompressLayers bool
+	// maxParallelDownloads is the maximum number of layers copied concurrently, if copyInParallel.
+	maxParallelDownloads uint
+	// bandwidthLimiter, if not nil, limits the aggregate rate of reading blobs from the source.
+	bandwidthLimiter *bandwidthLimiter
 }
 
 // imageCopier tracks state specific to a single image (possibly an item of a manifest list)
@@ -98,8 +110,41 @@ type imageCopier struct {
 	diffIDsAreNeeded   bool
 	canModifyManifest  bool
 	canSubstituteBlobs bool
+	// ociManifestRequired is set if layers may be compressed using an algorithm only OCI manifests can refer to.
+	ociManifestRequired bool
 }
 
+// ImageListSelection is one of CopySystemImage, CopyAllImages, or
+// CopySpecificImages, to control whether, when the source reference is a list,
+// copy.Image() copies only an image which matches the current runtime
+// environment, or all images in the list, or only specific images from the list.
+type ImageListSelection int
+
+const (
+	// CopySystemImage is the default value which, when set in
+	// Options.ImageListSelection, indicates that the caller expects only one
+	// image to be copied, so if the source reference refers to a list of
+	// images, one that matches the current system will be selected.
+	CopySystemImage ImageListSelection = iota
+	// CopyAllImages is a value which, when set in Options.ImageListSelection,
+	// indicates that the caller expects to copy multiple images, and if
+	// the source reference refers to a list, that the list and every image
+	// it references will be copied.
+	CopyAllImages
+	// CopySpecificImages is a value which, when set in
+	// Options.ImageListSelection, indicates that the caller expects to copy
+	// multiple images, and if the source reference refers to a list, that the
+	// list, and only the images in it which are matched by Options.Instances
+	// or Options.InstancePlatforms, will be copied; the other images are
+	// removed from the copied list.
+	//
+	// With either CopyAllImages or CopySpecificImages, the list is converted to
+	// a list type the destination supports, if necessary; if the destination
+	// can not store lists at all, only an image matching the current system is
+	// copied, as with CopySystemImage.
+	CopySpecificImages
+)
+
 // Options allows supplying non-default configuration modifying the behavior of CopyImage.
 type Options struct {
 	RemoveSignatures bool   // Remove any pre-existing signatures. SignBy will still add a new signature.
@@ -109,8 +154,42 @@ type Options struct {
 	DestinationCtx   *types.SystemContext
 	ProgressInterval time.Duration                 // time to wait between reports to signal the progress channel
 	Progress         chan types.ProgressProperties // Reported to when ProgressInterval has arrived for a single artifact+offset.
+	// ProgressEvents, if set, causes events other than types.ProgressEventRead (see types.ProgressEvent) to be reported
+	// to Progress as well; types.ProgressEventRead is still reported only if ProgressInterval > 0.
+	// Progress must be read from for as long as the copy is running if any events are reported to it.
+	ProgressEvents bool
 	// manifest MIME type of image set by user. "" is default and means use the autodetection to the the manifest MIME type
 	ForceManifestMIMEType string
+	// ImageListSelection determines which images are copied if the source is a manifest list.
+	// If the destination can not store manifest lists at all, only an image matching the current system (as specified by
+	// SourceCtx) is copied, as with CopySystemImage, and a warning is written to ReportWriter.
+	ImageListSelection ImageListSelection
+	// Instances lists manifest digests of the list instances to copy, if ImageListSelection is CopySpecificImages.
+	Instances []digest.Digest
+	// InstancePlatforms lists platforms of the list instances to copy, if ImageListSelection is CopySpecificImages.
+	// Architecture and OS must match exactly; Variant and OSVersion are compared only if they are set here.
+	InstancePlatforms []imgspecv1.Platform
+	// CompressionFormat, if not nil, is the algorithm used to compress layers if the destination requires compressed layers.
+	// It overrides DestinationCtx.CompressionFormat; if neither is set, gzip is used.
+	// If set (here or in DestinationCtx), layers compressed using a different algorithm are recompressed, as long as the image can be modified.
+	CompressionFormat *compression.Algorithm
+	// CompressionLevel, if not nil, is the algorithm-specific compression level, overriding DestinationCtx.CompressionLevel.
+	// As with DestinationCtx.CompressionLevel, xz ignores the level, and zstd only distinguishes levels below 3 from levels 3 and above.
+	CompressionLevel *int
+	// MaxParallelDownloads is the maximum number of layers copied concurrently, if both the source and the destination
+	// support it.  The default, 0, means 6.
+	MaxParallelDownloads uint
+	// BandwidthLimit, if positive, is the maximum aggregate number of bytes per second read from the source, across all
+	// concurrently copied blobs.
+	BandwidthLimit int64
+	// BlobInfoCache, if not nil, is used instead of blobinfocache.DefaultCache(DestinationCtx), e.g. to share a single
+	// cache across many copies.
+	BlobInfoCache types.BlobInfoCache
+	// Verification, if not VerifyNone, causes the destination image to be read back and verified after it is committed;
+	// this requires destRef to be readable using DestinationCtx.  Image fails if the verification fails.
+	Verification VerificationMode
+	// VerificationReport, if not nil, is set to the results of the verification, whether it succeeds or not.
+	VerificationReport *VerificationReport
 }
 
 // Image copies image from srcRef to destRef, using policyContext to validate
@@ -152,34 +231,29 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		}
 	}()
 
-	copyInParallel := dest.HasThreadSafePutBlob() && rawSource.HasThreadSafeGetBlob()
-	c := &copier{
-		dest:             dest,
-		rawSource:        rawSource,
-		reportWriter:     reportWriter,
-		progressInterval: options.ProgressInterval,
-		progress:         options.Progress,
-		copyInParallel:   copyInParallel,
-		// FIXME? The cache is used for sources and destinations equally, but we only have a SourceCtx and DestinationCtx.
-		// For now, use DestinationCtx (because blob reuse changes the behavior of the destination side more); eventually
-		// we might want to add a separate CommonCtx — or would that be too confusing?
-		blobInfoCache: blobinfocache.DefaultCache(options.DestinationCtx),
-	}
+	c := newCopier(dest, rawSource, reportWriter, options)
 
 	unparsedToplevel := image.UnparsedInstance(rawSource, nil)
 	multiImage, err := isMultiImage(ctx, unparsedToplevel)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error determining manifest MIME type for %s", transports.ImageName(srcRef))
 	}
+	_, toplevelMIMEType, err := unparsedToplevel.Manifest(ctx) // Already cached by isMultiImage
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading manifest for %s", transports.ImageName(srcRef))
+	}
 
 	if !multiImage {
 		// The simple case: Just copy a single image.
-		if manifest, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel); err != nil {
+		if manifest, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, nil); err != nil {
 			return nil, err
 		}
-	} else {
-		// This is a manifest list. Choose a single image and copy it.
-		// FIXME: Copy to destinations which support manifest lists, one image at a time.
+	} else if listMIMEType := destinationManifestListMIMEType(dest, toplevelMIMEType); options.ImageListSelection == CopySystemImage || listMIMEType == "" {
+		// This is a manifest list, and we either weren't asked to copy multiple images, or we can't.
+		// Choose a single image and copy it.
+		if options.ImageListSelection != CopySystemImage {
+			c.Printf("Destination does not support manifest lists, copying only the image matching the current system\n")
+		}
 		instanceDigest, err := image.ChooseManifestInstanceFromManifestList(ctx, options.SourceCtx, unparsedToplevel)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Error choosing an image from manifest list %s", transports.ImageName(srcRef))
@@ -187,7 +261,12 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		logrus.Debugf("Source is a manifest list; copying (only) instance %s", instanceDigest)
 		unparsedInstance := image.UnparsedInstance(rawSource, &instanceDigest)
 
-		if manifest, err = c.copyOneImage(ctx, policyContext, options, unparsedInstance); err != nil {
+		if manifest, _, err = c.copyOneImage(ctx, policyContext, options, unparsedInstance, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		// This is a manifest list, and the destination can store it (possibly converted): copy the selected images and the list itself.
+		if manifest, err = c.copyMultipleImages(ctx, policyContext, options, unparsedToplevel, listMIMEType); err != nil {
 			return nil, err
 		}
 	}
@@ -196,36 +275,96 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		return nil, errors.Wrap(err, "Error committing the finished image")
 	}
 
+	if options.Verification != VerifyNone {
+		c.Printf("Verifying the copied image\n")
+		report, err := verifyImage(ctx, options.DestinationCtx, destRef, c.dest, manifest, options.Verification)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error verifying the copied image %s", transports.ImageName(destRef))
+		}
+		if options.VerificationReport != nil {
+			*options.VerificationReport = *report
+		}
+		if err := report.Err(); err != nil {
+			return nil, errors.Wrapf(err, "Error verifying the copied image %s", transports.ImageName(destRef))
+		}
+	}
+
 	return manifest, nil
 }
 
-// Image copies a single (on-manifest-list) image unparsedImage, using policyContext to validate
-// source image admissibility.
-func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedImage *image.UnparsedImage) (manifest []byte, retErr error) {
+// newCopier returns a copier from rawSource to dest, configured per options.
+func newCopier(dest types.ImageDestination, rawSource types.ImageSource, reportWriter io.Writer, options *Options) *copier {
+	copyInParallel := dest.HasThreadSafePutBlob() && rawSource.HasThreadSafeGetBlob()
+	c := &copier{
+		dest:             dest,
+		rawSource:        rawSource,
+		reportWriter:     reportWriter,
+		progressInterval: options.ProgressInterval,
+		progress:         options.Progress,
+		progressEvents:   options.ProgressEvents,
+		copyInParallel:   copyInParallel,
+		// FIXME? The cache is used for sources and destinations equally, but we only have a SourceCtx and DestinationCtx.
+		// For now, use DestinationCtx (because blob reuse changes the behavior of the destination side more); eventually
+		// we might want to add a separate CommonCtx — or would that be too confusing?
+		blobInfoCache:     blobinfocache.DefaultCache(options.DestinationCtx),
+		compressionFormat: compression.Gzip,
+	}
+	if options.DestinationCtx != nil {
+		if options.DestinationCtx.CompressionFormat != nil {
+			c.compressionFormat = *options.DestinationCtx.CompressionFormat
+			c.recompressLayers = true
+		}
+		c.compressionLevel = options.DestinationCtx.CompressionLevel
+	}
+	if options.CompressionFormat != nil {
+		c.compressionFormat = *options.CompressionFormat
+		c.recompressLayers = true
+	}
+	if options.CompressionLevel != nil {
+		c.compressionLevel = options.CompressionLevel
+	}
+	c.maxParallelDownloads = uint(maxParallelDownloads)
+	if options.MaxParallelDownloads > 0 {
+		c.maxParallelDownloads = options.MaxParallelDownloads
+	}
+	if options.BandwidthLimit > 0 {
+		c.bandwidthLimiter = newBandwidthLimiter(options.BandwidthLimit)
+	}
+	if options.BlobInfoCache != nil {
+		c.blobInfoCache = options.BlobInfoCache
+	}
+	return c
+}
+
+// copyOneImage copies a single (non-manifest-list) image unparsedImage, using policyContext to validate
+// source image admissibility.  It returns the manifest which was written, and its MIME type.
+// If targetInstance is not nil, the image is stored as an instance of a manifest list which will be written later,
+// i.e. the manifest and signatures are stored using the digest of the written manifest.
+func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedImage *image.UnparsedImage, targetInstance *digest.Digest) (manifestBytes []byte, manifestMIMEType string, retErr error) {
 	// The caller is handling manifest lists; this could happen only if a manifest list contains a manifest list.
 	// Make sure we fail cleanly in such cases.
 	multiImage, err := isMultiImage(ctx, unparsedImage)
 	if err != nil {
 		// FIXME FIXME: How to name a reference for the sub-image?
-		return nil, errors.Wrapf(err, "Error determining manifest MIME type for %s", transports.ImageName(unparsedImage.Reference()))
+		return nil, "", errors.Wrapf(err, "Error determining manifest MIME type for %s", transports.ImageName(unparsedImage.Reference()))
 	}
 	if multiImage {
-		return nil, fmt.Errorf("Unexpectedly received a manifest list instead of a manifest for a single image")
+		return nil, "", fmt.Errorf("Unexpectedly received a manifest list instead of a manifest for a single image")
 	}
 
 	// Please keep this policy check BEFORE reading any other information about the image.
 	// (the multiImage check above only matches the MIME type, which we have received anyway.
 	// Actual parsing of anything should be deferred.)
 	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
-		return nil, errors.Wrap(err, "Source image rejected")
+		return nil, "", errors.Wrap(err, "Source image rejected")
 	}
 	src, err := image.FromUnparsedImage(ctx, options.SourceCtx, unparsedImage)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Error initializing image from source %s", transports.ImageName(c.rawSource.Reference()))
+		return nil, "", errors.Wrapf(err, "Error initializing image from source %s", transports.ImageName(c.rawSource.Reference()))
 	}
 
 	if err := checkImageDestinationForCurrentRuntimeOS(ctx, options.DestinationCtx, src, c.dest); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	var sigs [][]byte
@@ -235,14 +374,14 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 		c.Printf("Getting image source signatures\n")
 		s, err := src.Signatures(ctx)
 		if err != nil {
-			return nil, errors.Wrap(err, "Error reading signatures")
+			return nil, "", errors.Wrap(err, "Error reading signatures")
 		}
 		sigs = s
 	}
 	if len(sigs) != 0 {
 		c.Printf("Checking if image destination supports signatures\n")
 		if err := c.dest.SupportsSignatures(ctx); err != nil {
-			return nil, errors.Wrap(err, "Can not copy signatures")
+			return nil, "", errors.Wrap(err, "Can not copy signatures")
 		}
 	}
 
@@ -258,32 +397,33 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 		// We do intend the RecordDigestUncompressedPair calls to only work with reliable data, but at least there’s a risk
 		// that the compressed version coming from a third party may be designed to attack some other decompressor implementation,
 		// and we would reuse and sign it.
-		canSubstituteBlobs: len(sigs) == 0 && options.SignBy == "",
+		canSubstituteBlobs:  len(sigs) == 0 && options.SignBy == "",
+		ociManifestRequired: c.layerCompressionRequiresOCI(),
 	}
 
 	if err := ic.updateEmbeddedDockerReference(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// We compute preferredManifestMIMEType only to show it in error messages.
 	// Without having to add this context in an error message, we would be happy enough to know only that no conversion is needed.
 	preferredManifestMIMEType, otherManifestMIMETypeCandidates, err := ic.determineManifestConversion(ctx, c.dest.SupportedManifestMIMETypes(), options.ForceManifestMIMEType)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// If src.UpdatedImageNeedsLayerDiffIDs(ic.manifestUpdates) will be true, it needs to be true by the time we get here.
 	ic.diffIDsAreNeeded = src.UpdatedImageNeedsLayerDiffIDs(*ic.manifestUpdates)
 
 	if err := ic.copyLayers(ctx); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// With docker/distribution registries we do not know whether the registry accepts schema2 or schema1 only;
 	// and at least with the OpenShift registry "acceptschema2" option, there is no way to detect the support
 	// without actually trying to upload something and getting a types.ManifestTypeRejectedError.
 	// So, try the preferred manifest MIME type. If the process succeeds, fine…
-	manifest, err = ic.copyUpdatedConfigAndManifest(ctx)
+	manifestBytes, manifestMIMEType, err = ic.copyUpdatedConfigAndManifest(ctx, targetInstance)
 	if err != nil {
 		logrus.Debugf("Writing manifest using preferred type %s failed: %v", preferredManifestMIMEType, err)
 		// … if it fails, _and_ the failure is because the manifest is rejected, we may have other options.
@@ -291,52 +431,86 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 			// We don’t have other options.
 			// In principle the code below would handle this as well, but the resulting  error message is fairly ugly.
 			// Don’t bother the user with MIME types if we have no choice.
-			return nil, err
+			return nil, "", err
 		}
 		// If the original MIME type is acceptable, determineManifestConversion always uses it as preferredManifestMIMEType.
 		// So if we are here, we will definitely be trying to convert the manifest.
 		// With !ic.canModifyManifest, that would just be a string of repeated failures for the same reason,
 		// so let’s bail out early and with a better error message.
 		if !ic.canModifyManifest {
-			return nil, errors.Wrap(err, "Writing manifest failed (and converting it is not possible)")
+			return nil, "", errors.Wrap(err, "Writing manifest failed (and converting it is not possible)")
 		}
 
 		// errs is a list of errors when trying various manifest types. Also serves as an "upload succeeded" flag when set to nil.
 		errs := []string{fmt.Sprintf("%s(%v)", preferredManifestMIMEType, err)}
-		for _, manifestMIMEType := range otherManifestMIMETypeCandidates {
-			logrus.Debugf("Trying to use manifest type %s…", manifestMIMEType)
-			ic.manifestUpdates.ManifestMIMEType = manifestMIMEType
-			attemptedManifest, err := ic.copyUpdatedConfigAndManifest(ctx)
+		for _, candidateMIMEType := range otherManifestMIMETypeCandidates {
+			logrus.Debugf("Trying to use manifest type %s…", candidateMIMEType)
+			ic.manifestUpdates.ManifestMIMEType = candidateMIMEType
+			attemptedManifest, attemptedManifestMIMEType, err := ic.copyUpdatedConfigAndManifest(ctx, targetInstance)
 			if err != nil {
-				logrus.Debugf("Upload of manifest type %s failed: %v", manifestMIMEType, err)
-				errs = append(errs, fmt.Sprintf("%s(%v)", manifestMIMEType, err))
+				logrus.Debugf("Upload of manifest type %s failed: %v", candidateMIMEType, err)
+				errs = append(errs, fmt.Sprintf("%s(%v)", candidateMIMEType, err))
 				continue
 			}
 
 			// We have successfully uploaded a manifest.
-			manifest = attemptedManifest
+			manifestBytes = attemptedManifest
+			manifestMIMEType = attemptedManifestMIMEType
 			errs = nil // Mark this as a success so that we don't abort below.
 			break
 		}
 		if errs != nil {
-			return nil, fmt.Errorf("Uploading manifest failed, attempted the following formats: %s", strings.Join(errs, ", "))
+			return nil, "", fmt.Errorf("Uploading manifest failed, attempted the following formats: %s", strings.Join(errs, ", "))
 		}
 	}
 
 	if options.SignBy != "" {
-		newSig, err := c.createSignature(manifest, options.SignBy)
+		newSig, err := c.createSignature(manifestBytes, options.SignBy)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		sigs = append(sigs, newSig)
 	}
 
+	var instanceDigest *digest.Digest
+	if targetInstance != nil {
+		manifestDigest, err := manifest.Digest(manifestBytes)
+		if err != nil {
+			return nil, "", err
+		}
+		instanceDigest = &manifestDigest
+	}
 	c.Printf("Storing signatures\n")
-	if err := c.dest.PutSignatures(ctx, sigs); err != nil {
-		return nil, errors.Wrap(err, "Error writing signatures")
+	if err := c.dest.PutSignatures(ctx, sigs, instanceDigest); err != nil {
+		return nil, "", errors.Wrap(err, "Error writing signatures")
+	}
+	if len(sigs) != 0 {
+		c.sendManifestProgress(types.ProgressEventSignaturesWritten, manifestBytes, manifestMIMEType)
 	}
 
-	return manifest, nil
+	return manifestBytes, manifestMIMEType, nil
+}
+
+// sendProgress sends props to c.progress, if events other than types.ProgressEventRead were requested.
+func (c *copier) sendProgress(props types.ProgressProperties) {
+	if c.progress != nil && c.progressEvents {
+		c.progress <- props
+	}
+}
+
+// sendManifestProgress sends event for manifest of mimeType to c.progress, if events other than types.ProgressEventRead
+// were requested.
+func (c *copier) sendManifestProgress(event types.ProgressEvent, man []byte, mimeType string) {
+	if c.progress == nil || !c.progressEvents {
+		return
+	}
+	// An error could only happen for an unparseable schema1 manifest, which we would not have been able to copy;
+	// an empty digest is good enough for such an unexpected case.
+	manifestDigest, _ := manifest.Digest(man)
+	c.sendProgress(types.ProgressProperties{
+		Event:    event,
+		Artifact: types.BlobInfo{Digest: manifestDigest, Size: int64(len(man)), MediaType: mimeType},
+	})
 }
 
 // Printf writes a formatted string to c.reportWriter.
@@ -435,7 +609,7 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 	// avoid malicious images causing troubles and to be nice to servers.
 	var copySemaphore *semaphore.Weighted
 	if ic.c.copyInParallel {
-		copySemaphore = semaphore.NewWeighted(int64(maxParallelDownloads))
+		copySemaphore = semaphore.NewWeighted(int64(ic.c.maxParallelDownloads))
 	} else {
 		copySemaphore = semaphore.NewWeighted(int64(1))
 	}
@@ -457,6 +631,7 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 			} else {
 				cld.destInfo = srcLayer
 				logrus.Debugf("Skipping foreign layer %q copy to %s\n", cld.destInfo.Digest, ic.c.dest.Reference().Transport().Name())
+				ic.c.sendProgress(types.ProgressProperties{Event: types.ProgressEventSkipped, Artifact: srcLayer, DestInfo: srcLayer})
 				bar.Prefix(fmt.Sprintf("Skipping blob %s (foreign layer):", shortDigest(srcLayer.Digest)))
 				bar.Add64(bar.Total)
 				bar.Finish()
@@ -522,12 +697,13 @@ func layerDigestsDiffer(a, b []types.BlobInfo) bool {
 }
 
 // copyUpdatedConfigAndManifest updates the image per ic.manifestUpdates, if necessary,
-// stores the resulting config and manifest to the destination, and returns the stored manifest.
-func (ic *imageCopier) copyUpdatedConfigAndManifest(ctx context.Context) ([]byte, error) {
+// stores the resulting config and manifest to the destination, and returns the stored manifest and its MIME type.
+// If instanceDigest is not nil, the manifest is stored as an instance of a manifest list, using the digest of the stored manifest.
+func (ic *imageCopier) copyUpdatedConfigAndManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
 	pendingImage := ic.src
 	if !reflect.DeepEqual(*ic.manifestUpdates, types.ManifestUpdateOptions{InformationOnly: ic.manifestUpdates.InformationOnly}) {
 		if !ic.canModifyManifest {
-			return nil, errors.Errorf("Internal error: copy needs an updated manifest but that was known to be forbidden")
+			return nil, "", errors.Errorf("Internal error: copy needs an updated manifest but that was known to be forbidden")
 		}
 		if !ic.diffIDsAreNeeded && ic.src.UpdatedImageNeedsLayerDiffIDs(*ic.manifestUpdates) {
 			// We have set ic.diffIDsAreNeeded based on the preferred MIME type returned by determineManifestConversion.
@@ -536,28 +712,37 @@ func (ic *imageCopier) copyUpdatedConfigAndManifest(ctx context.Context) ([]byte
 			// when ic.c.dest.SupportedManifestMIMETypes() includes both s1 and s2, the upload using s1 failed, and we are now trying s2.
 			// Supposedly s2-only registries do not exist or are extremely rare, so failing with this error message is good enough for now.
 			// If handling such registries turns out to be necessary, we could compute ic.diffIDsAreNeeded based on the full list of manifest MIME type candidates.
-			return nil, errors.Errorf("Can not convert image to %s, preparing DiffIDs for this case is not supported", ic.manifestUpdates.ManifestMIMEType)
+			return nil, "", errors.Errorf("Can not convert image to %s, preparing DiffIDs for this case is not supported", ic.manifestUpdates.ManifestMIMEType)
 		}
 		pi, err := ic.src.UpdatedImage(ctx, *ic.manifestUpdates)
 		if err != nil {
-			return nil, errors.Wrap(err, "Error creating an updated image manifest")
+			return nil, "", errors.Wrap(err, "Error creating an updated image manifest")
 		}
 		pendingImage = pi
 	}
-	manifest, _, err := pendingImage.Manifest(ctx)
+	man, manifestMIMEType, err := pendingImage.Manifest(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error reading manifest")
+		return nil, "", errors.Wrap(err, "Error reading manifest")
 	}
 
 	if err := ic.c.copyConfig(ctx, pendingImage); err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	if instanceDigest != nil {
+		manifestDigest, err := manifest.Digest(man)
+		if err != nil {
+			return nil, "", err
+		}
+		instanceDigest = &manifestDigest
 	}
 
 	ic.c.Printf("Writing manifest to image destination\n")
-	if err := ic.c.dest.PutManifest(ctx, manifest); err != nil {
-		return nil, errors.Wrap(err, "Error writing manifest")
+	if err := ic.c.dest.PutManifest(ctx, man, instanceDigest); err != nil {
+		return nil, "", errors.Wrap(err, "Error writing manifest")
 	}
-	return manifest, nil
+	ic.c.sendManifestProgress(types.ProgressEventManifestWritten, man, manifestMIMEType)
+	return man, manifestMIMEType, nil
 }
 
 // copyConfig copies config.json, if any, from src to dest.
@@ -582,6 +767,26 @@ func (c *copier) copyConfig(ctx context.Context, src types.Image) error {
 	return nil
 }
 
+// layerCompressionOperation returns the operation to perform when copying a blob to c.dest, if the blob is compressed
+// using compressionFormat (only relevant if isCompressed).  The blob is never modified unless canModifyBlob.
+func (c *copier) layerCompressionOperation(canModifyBlob, isCompressed bool, compressionFormat compression.Algorithm) types.LayerCompression {
+	switch {
+	case canModifyBlob && c.dest.DesiredLayerCompression() == types.Compress &&
+		(!isCompressed || (c.recompressLayers && compressionFormat.Name() != c.compressionFormat.Name())):
+		return types.Compress
+	case canModifyBlob && c.dest.DesiredLayerCompression() == types.Decompress && isCompressed:
+		return types.Decompress
+	default:
+		return types.PreserveOriginal
+	}
+}
+
+// layerCompressionRequiresOCI returns true if layers compressed for c.dest would use an algorithm which can only be
+// used with OCI manifests.
+func (c *copier) layerCompressionRequiresOCI() bool {
+	return c.dest.DesiredLayerCompression() == types.Compress && c.compressionFormat.Name() != compression.Gzip.Name()
+}
+
 // diffIDResult contains both a digest value and an error from diffIDComputationGoroutine.
 // We could also send the error through the pipeReader, but this more cleanly separates the copying of the layer and the DiffID computation.
 type diffIDResult struct {
@@ -602,6 +807,7 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, ba
 			return types.BlobInfo{}, "", errors.Wrapf(err, "Error trying to reuse blob %s at destination", srcInfo.Digest)
 		}
 		if reused {
+			ic.c.sendProgress(types.ProgressProperties{Event: types.ProgressEventSkipped, Artifact: srcInfo, DestInfo: blobInfo})
 			bar.Prefix(fmt.Sprintf("Skipping blob %s (already present):", shortDigest(srcInfo.Digest)))
 			bar.Add64(bar.Total)
 			bar.Finish()
@@ -706,10 +912,22 @@ func computeDiffID(stream io.Reader, decompressor compression.DecompressorFunc)
 // and returns a complete blobInfo of the copied blob.
 func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, srcInfo types.BlobInfo,
 	getOriginalLayerCopyWriter func(decompressor compression.DecompressorFunc) io.Writer,
-	canModifyBlob bool, isConfig bool, bar *pb.ProgressBar) (types.BlobInfo, error) {
+	canModifyBlob bool, isConfig bool, bar *pb.ProgressBar) (_ types.BlobInfo, retErr error) {
+	c.sendProgress(types.ProgressProperties{Event: types.ProgressEventNewArtifact, Artifact: srcInfo})
+	defer func() {
+		if retErr != nil {
+			c.sendProgress(types.ProgressProperties{Event: types.ProgressEventFailed, Artifact: srcInfo, Err: retErr})
+		}
+	}()
+
 	// The copying happens through a pipeline of connected io.Readers.
 	// === Input: srcStream
 
+	// === Limit the bandwidth, if required.
+	if c.bandwidthLimiter != nil {
+		srcStream = &rateLimitedReader{ctx: ctx, source: srcStream, limiter: c.bandwidthLimiter}
+	}
+
 	// === Process input through digestingReader to validate against the expected digest.
 	// Be paranoid; in case PutBlob somehow managed to ignore an error from digestingReader,
 	// use a separate validation failure indicator.
@@ -724,7 +942,7 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 
 	// === Detect compression of the input stream.
 	// This requires us to “peek ahead” into the stream to read the initial part, which requires us to chain through another io.Reader returned by DetectCompression.
-	decompressor, destStream, err := compression.DetectCompression(destStream) // We could skip this in some cases, but let's keep the code path uniform
+	compressionFormat, decompressor, destStream, err := compression.DetectCompressionFormat(destStream) // We could skip this in some cases, but let's keep the code path uniform
 	if err != nil {
 		return types.BlobInfo{}, errors.Wrapf(err, "Error reading blob %s", srcInfo.Digest)
 	}
@@ -741,22 +959,36 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 	// === Deal with layer compression/decompression if necessary
 	var inputInfo types.BlobInfo
 	var compressionOperation types.LayerCompression
-	if canModifyBlob && c.dest.DesiredLayerCompression() == types.Compress && !isCompressed {
-		logrus.Debugf("Compressing blob on the fly")
+	var compressionAlgorithm *compression.Algorithm
+	switch c.layerCompressionOperation(canModifyBlob, isCompressed, compressionFormat) {
+	case types.Compress:
+		if isCompressed {
+			logrus.Debugf("Recompressing blob on the fly from %s to %s", compressionFormat.Name(), c.compressionFormat.Name())
+			s, err := decompressor(destStream)
+			if err != nil {
+				return types.BlobInfo{}, err
+			}
+			defer s.Close()
+			destStream = s
+		} else {
+			logrus.Debugf("Compressing blob on the fly using %s", c.compressionFormat.Name())
+		}
 		compressionOperation = types.Compress
+		compressionAlgorithm = &c.compressionFormat
 		pipeReader, pipeWriter := io.Pipe()
 		defer pipeReader.Close()
 
 		// If this fails while writing data, it will do pipeWriter.CloseWithError(); if it fails otherwise,
 		// e.g. because we have exited and due to pipeReader.Close() above further writing to the pipe has failed,
 		// we don’t care.
-		go compressGoroutine(pipeWriter, destStream) // Closes pipeWriter
+		go compressGoroutine(pipeWriter, destStream, c.compressionFormat, c.compressionLevel) // Closes pipeWriter
 		destStream = pipeReader
 		inputInfo.Digest = ""
 		inputInfo.Size = -1
-	} else if canModifyBlob && c.dest.DesiredLayerCompression() == types.Decompress && isCompressed {
+	case types.Decompress:
 		logrus.Debugf("Blob will be decompressed")
 		compressionOperation = types.Decompress
+		compressionAlgorithm = &compressionFormat
 		s, err := decompressor(destStream)
 		if err != nil {
 			return types.BlobInfo{}, err
@@ -765,22 +997,24 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		destStream = s
 		inputInfo.Digest = ""
 		inputInfo.Size = -1
-	} else {
+	default:
 		logrus.Debugf("Using original blob without modification")
 		compressionOperation = types.PreserveOriginal
 		inputInfo = srcInfo
 	}
 
 	// === Report progress using the c.progress channel, if required.
+	// This counts the bytes sent to the destination, for both types.ProgressEventRead and types.ProgressEventDone.
+	progress := &progressReader{
+		source:   destStream,
+		artifact: srcInfo,
+		lastTime: time.Now(),
+	}
 	if c.progress != nil && c.progressInterval > 0 {
-		destStream = &progressReader{
-			source:   destStream,
-			channel:  c.progress,
-			interval: c.progressInterval,
-			artifact: srcInfo,
-			lastTime: time.Now(),
-		}
+		progress.channel = c.progress
+		progress.interval = c.progressInterval
 	}
+	destStream = progress
 
 	// === Finally, send the layer stream to dest.
 	uploadedInfo, err := c.dest.PutBlob(ctx, destStream, inputInfo, c.blobInfoCache, isConfig)
@@ -815,24 +1049,34 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		case types.PreserveOriginal:
 			break // Do nothing, we have only one digest and we might not have even verified it.
 		case types.Compress:
-			c.blobInfoCache.RecordDigestUncompressedPair(uploadedInfo.Digest, srcInfo.Digest)
+			if !isCompressed { // If we have recompressed the blob, srcInfo.Digest is not the uncompressed digest.
+				c.blobInfoCache.RecordDigestUncompressedPair(uploadedInfo.Digest, srcInfo.Digest)
+			}
 		case types.Decompress:
 			c.blobInfoCache.RecordDigestUncompressedPair(srcInfo.Digest, uploadedInfo.Digest)
 		default:
 			return types.BlobInfo{}, errors.Errorf("Internal error: Unexpected compressionOperation value %#v", compressionOperation)
 		}
 	}
+	if !isConfig {
+		uploadedInfo.CompressionOperation = compressionOperation
+		uploadedInfo.CompressionAlgorithm = compressionAlgorithm
+	}
+	c.sendProgress(types.ProgressProperties{Event: types.ProgressEventDone, Artifact: srcInfo, Offset: progress.offset, DestInfo: uploadedInfo})
 	return uploadedInfo, nil
 }
 
-// compressGoroutine reads all input from src and writes its compressed equivalent to dest.
-func compressGoroutine(dest *io.PipeWriter, src io.Reader) {
+// compressGoroutine reads all input from src and writes its compressed equivalent to dest, using algorithm and level (if not nil).
+func compressGoroutine(dest *io.PipeWriter, src io.Reader, algorithm compression.Algorithm, level *int) {
 	err := errors.New("Internal error: unexpected panic in compressGoroutine")
 	defer func() { // Note that this is not the same as {defer dest.CloseWithError(err)}; we need err to be evaluated lazily.
 		dest.CloseWithError(err) // CloseWithError(nil) is equivalent to Close()
 	}()
 
-	zipper := pgzip.NewWriter(dest)
+	zipper, err := compression.CompressStream(dest, algorithm, level)
+	if err != nil {
+		return
+	}
 	defer zipper.Close()
 
 	_, err = io.Copy(zipper, src) // Sets err to nil, i.e. causes dest.Close()
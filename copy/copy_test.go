@@ -2,15 +2,24 @@ package copy
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/containers/image/directory"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/oci/layout"
 	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/types"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -139,3 +148,96 @@ func TestComputeDiffID(t *testing.T) {
 	_, err = computeDiffID(reader, nil)
 	assert.Error(t, err)
 }
+
+func TestCompressGoroutine(t *testing.T) {
+	level := 9
+	for _, c := range []struct {
+		algorithm compression.Algorithm
+		level     *int
+	}{
+		{compression.Gzip, nil},
+		{compression.Gzip, &level},
+		{compression.Zstd, nil},
+		{compression.Zstd, &level},
+	} {
+		pipeReader, pipeWriter := io.Pipe()
+		go compressGoroutine(pipeWriter, bytes.NewReader([]byte("Hello")), c.algorithm, c.level)
+
+		uncompressedStream, isCompressed, err := compression.AutoDecompress(pipeReader)
+		require.NoError(t, err, c.algorithm.Name())
+		assert.True(t, isCompressed, c.algorithm.Name())
+		contents, err := ioutil.ReadAll(uncompressedStream)
+		require.NoError(t, err, c.algorithm.Name())
+		assert.Equal(t, []byte("Hello"), contents, c.algorithm.Name())
+		uncompressedStream.Close()
+		pipeReader.Close()
+	}
+
+	// Compression using an unsupported algorithm fails.
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeReader.Close()
+	go compressGoroutine(pipeWriter, bytes.NewReader([]byte("Hello")), compression.Bzip2, nil)
+	_, err := ioutil.ReadAll(pipeReader)
+	assert.Error(t, err)
+}
+
+func TestImageProgressEvents(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-progress")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	_, layer := putVerificationTestImage(t, filepath.Join(tmpDir, "src"))
+	srcRef, err := directory.NewReference(filepath.Join(tmpDir, "src"))
+	require.NoError(t, err)
+	destRef, err := layout.NewReference(filepath.Join(tmpDir, "dest"), "latest")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+
+	copyWithEvents := func() ([]byte, []types.ProgressProperties) {
+		progress := make(chan types.ProgressProperties)
+		done := make(chan []types.ProgressProperties)
+		go func() {
+			events := []types.ProgressProperties{}
+			for props := range progress {
+				if props.Event != types.ProgressEventRead {
+					events = append(events, props)
+				}
+			}
+			done <- events
+		}()
+		m, err := Image(context.Background(), policyContext, destRef, srcRef, &Options{Progress: progress, ProgressInterval: time.Millisecond, ProgressEvents: true})
+		require.NoError(t, err)
+		close(progress)
+		return m, <-done
+	}
+
+	m, events := copyWithEvents()
+	manifestDigest, err := manifest.Digest(m)
+	require.NoError(t, err)
+	eventTypes := []types.ProgressEvent{}
+	for _, e := range events {
+		eventTypes = append(eventTypes, e.Event)
+	}
+	assert.Equal(t, []types.ProgressEvent{
+		types.ProgressEventNewArtifact, types.ProgressEventDone, // The layer
+		types.ProgressEventNewArtifact, types.ProgressEventDone, // The config
+		types.ProgressEventManifestWritten, // No signatures were written
+	}, eventTypes)
+	assert.Equal(t, layer.Digest, events[0].Artifact.Digest)
+	assert.Equal(t, uint64(layer.Size), events[1].Offset)
+	assert.Equal(t, types.PreserveOriginal, events[1].DestInfo.CompressionOperation)
+	assert.Equal(t, manifestDigest, events[4].Artifact.Digest)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, events[4].Artifact.MediaType)
+
+	// The layer already exists
+	_, events = copyWithEvents()
+	require.NotEmpty(t, events)
+	assert.Equal(t, types.ProgressEventSkipped, events[0].Event)
+	assert.Equal(t, layer.Digest, events[0].Artifact.Digest)
+
+	// Without ProgressEvents, only types.ProgressEventRead is reported, and only if ProgressInterval is set;
+	// an unread channel does not block the copy.
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{Progress: make(chan types.ProgressProperties)})
+	require.NoError(t, err)
+}
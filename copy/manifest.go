@@ -6,6 +6,7 @@ import (
 
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -57,9 +58,29 @@ func (ic *imageCopier) determineManifestConversion(ctx context.Context, destSupp
 		destSupportedManifestMIMETypes = []string{forceManifestMIMEType}
 	}
 
+	// Docker manifests can only refer to gzip-compressed layers; fail now instead of after copying all layers.
+	if ic.canModifyManifest && ic.ociManifestRequired {
+		if len(destSupportedManifestMIMETypes) != 0 && !stringSliceContains(destSupportedManifestMIMETypes, imgspecv1.MediaTypeImageManifest) {
+			return "", nil, errors.Errorf("Layers would be compressed using an algorithm which requires OCI manifests, but the destination only accepts [%s]",
+				strings.Join(destSupportedManifestMIMETypes, ", "))
+		}
+		destSupportedManifestMIMETypes = []string{imgspecv1.MediaTypeImageManifest}
+	}
+
 	if len(destSupportedManifestMIMETypes) == 0 {
 		return srcType, []string{}, nil // Anything goes; just use the original as is, do not try any conversions.
 	}
+	// We are copying a single image here; manifest list MIME types are not a possible conversion target.
+	singleImageMIMETypes := []string{}
+	for _, t := range destSupportedManifestMIMETypes {
+		if !manifest.MIMETypeIsMultiImage(t) {
+			singleImageMIMETypes = append(singleImageMIMETypes, t)
+		}
+	}
+	if len(singleImageMIMETypes) == 0 {
+		return "", nil, errors.Errorf("The destination only accepts manifest lists [%s], can not store a single image", strings.Join(destSupportedManifestMIMETypes, ", "))
+	}
+	destSupportedManifestMIMETypes = singleImageMIMETypes
 	supportedByDest := map[string]struct{}{}
 	for _, t := range destSupportedManifestMIMETypes {
 		supportedByDest[t] = struct{}{}
@@ -111,6 +132,16 @@ func (ic *imageCopier) determineManifestConversion(ctx context.Context, destSupp
 	return preferredType, prioritizedTypes.list[1:], nil
 }
 
+// stringSliceContains returns true if s contains value.
+func stringSliceContains(s []string, value string) bool {
+	for _, v := range s {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // isMultiImage returns true if img is a list of images
 func isMultiImage(ctx context.Context, img types.UnparsedImage) (bool, error) {
 	_, mt, err := img.Manifest(ctx)
@@ -114,6 +114,9 @@ func TestDetermineManifestConversion(t *testing.T) {
 		// text/plain is normalized to s1, and if the destination accepts s1, no conversion happens.
 		{"text→s1s2", "text/plain", supportS1S2, "", []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1MediaType}},
 		{"text→s1", "text/plain", supportOnlyS1, "", []string{manifest.DockerV2Schema1MediaType}},
+		// Manifest list MIME types supported by the destination are ignored for single images
+		{"s2→s2+list", manifest.DockerV2Schema2MediaType, []string{manifest.DockerV2ListMediaType, manifest.DockerV2Schema2MediaType}, "", []string{}},
+		{"s2→s1+list", manifest.DockerV2Schema2MediaType, []string{manifest.DockerV2ListMediaType, manifest.DockerV2Schema1SignedMediaType}, manifest.DockerV2Schema1SignedMediaType, []string{}},
 		// Conversion necessary, a preferred format is acceptable
 		{"s2→s1", manifest.DockerV2Schema2MediaType, supportOnlyS1, manifest.DockerV2Schema1SignedMediaType, []string{manifest.DockerV2Schema1MediaType}},
 		// Conversion necessary, a preferred format is not acceptable
@@ -193,6 +196,43 @@ func TestDetermineManifestConversion(t *testing.T) {
 	}
 	_, _, err := ic.determineManifestConversion(context.Background(), supportS1S2, "")
 	assert.Error(t, err)
+
+	// A destination which only accepts manifest lists can not store a single image.
+	ic = imageCopier{
+		manifestUpdates:   &types.ManifestUpdateOptions{},
+		src:               fakeImageSource(manifest.DockerV2Schema2MediaType),
+		canModifyManifest: true,
+	}
+	_, _, err = ic.determineManifestConversion(context.Background(), []string{manifest.DockerV2ListMediaType}, "")
+	assert.Error(t, err)
+
+	// If layers may be compressed using an algorithm Docker manifests don't support, only OCI can be used.
+	for _, c := range []struct {
+		destTypes      []string
+		force          string
+		expectedUpdate string // or "error"
+	}{
+		{supportS1S2OCI, "", v1.MediaTypeImageManifest},
+		{[]string{}, "", v1.MediaTypeImageManifest},
+		{supportS1S2, "", "error"},
+		{supportS1S2OCI, manifest.DockerV2Schema2MediaType, "error"},
+	} {
+		ic = imageCopier{
+			manifestUpdates:     &types.ManifestUpdateOptions{},
+			src:                 fakeImageSource(manifest.DockerV2Schema2MediaType),
+			canModifyManifest:   true,
+			ociManifestRequired: true,
+		}
+		preferredMIMEType, otherCandidates, err := ic.determineManifestConversion(context.Background(), c.destTypes, c.force)
+		if c.expectedUpdate == "error" {
+			assert.Error(t, err, "%#v", c)
+		} else {
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, c.expectedUpdate, ic.manifestUpdates.ManifestMIMEType, "%#v", c)
+			assert.Equal(t, c.expectedUpdate, preferredMIMEType, "%#v", c)
+			assert.Equal(t, []string{}, otherCandidates, "%#v", c)
+		}
+	}
 }
 
 func TestIsMultiImage(t *testing.T) {
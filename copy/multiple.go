@@ -0,0 +1,209 @@
+package copy
+
+import (
+	"context"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// destinationManifestListMIMEType returns the MIME type to use for storing a manifest list of listMIMEType in dest:
+// listMIMEType if dest supports it, otherwise another manifest list MIME type dest supports, or "" if dest can not
+// store manifest lists at all.
+func destinationManifestListMIMEType(dest types.ImageDestination, listMIMEType string) string {
+	supported := dest.SupportedManifestMIMETypes()
+	if len(supported) == 0 {
+		return listMIMEType // Anything goes
+	}
+	for _, t := range supported {
+		if t == manifest.NormalizedMIMEType(listMIMEType) {
+			return listMIMEType
+		}
+	}
+	for _, t := range supported {
+		if manifest.MIMETypeIsMultiImage(t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// instanceIsSelected returns true if the list instance should be copied, per options.
+func instanceIsSelected(options *Options, instance *manifest.ListInstance) bool {
+	if options.ImageListSelection != CopySpecificImages {
+		return true
+	}
+	for _, d := range options.Instances {
+		if d == instance.Digest {
+			return true
+		}
+	}
+	platform := imgspecv1.Platform{}
+	if instance.Platform != nil {
+		platform = *instance.Platform
+	}
+	for _, wanted := range options.InstancePlatforms {
+		if wanted.Architecture != platform.Architecture || wanted.OS != platform.OS {
+			continue
+		}
+		if wanted.Variant != "" && wanted.Variant != platform.Variant {
+			continue
+		}
+		if wanted.OSVersion != "" && wanted.OSVersion != platform.OSVersion {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// copyMultipleImages copies the manifest list unparsedToplevel, and the images it references which are selected per options,
+// to c.dest, converting the list to listMIMEType if necessary.  It returns the manifest list which was written.
+func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage, listMIMEType string) ([]byte, error) {
+	manifestList, manifestType, err := unparsedToplevel.Manifest(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading manifest list")
+	}
+	list, err := manifest.ListFromBlob(manifestList, manifestType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing manifest list")
+	}
+	instances := list.Instances()
+
+	var sigs [][]byte
+	if options.RemoveSignatures {
+		sigs = [][]byte{}
+	} else {
+		c.Printf("Getting image list signatures\n")
+		s, err := c.rawSource.GetSignatures(ctx, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading signatures")
+		}
+		sigs = s
+	}
+	if len(sigs) != 0 {
+		c.Printf("Checking if image list destination supports signatures\n")
+		if err := c.dest.SupportsSignatures(ctx); err != nil {
+			return nil, errors.Wrap(err, "Can not copy signatures")
+		}
+	}
+	// Any modification of the list would invalidate the existing signatures.
+	canModifyManifestList := len(sigs) == 0
+
+	listConverted := false
+	if manifest.NormalizedMIMEType(manifestType) != manifest.NormalizedMIMEType(listMIMEType) {
+		if !canModifyManifestList {
+			return nil, errors.Errorf("Converting the image list to %s would invalidate its signatures; consider removing the signatures", listMIMEType)
+		}
+		logrus.Debugf("Converting manifest list from %s to %s", manifestType, listMIMEType)
+		list, err = list.ConvertToMIMEType(listMIMEType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error converting manifest list to %s", listMIMEType)
+		}
+		manifestType = listMIMEType
+		listConverted = true
+	}
+
+	// updates contains the current values for all instances; instances which are not selected are not modified.
+	updates := make([]manifest.ListUpdate, len(instances))
+	selected := make([]bool, len(instances))
+	numSelected := 0
+	selectedDigests := map[digest.Digest]bool{}
+	for i, instance := range instances {
+		updates[i] = manifest.ListUpdate{Digest: instance.Digest, Size: instance.Size, MediaType: instance.MediaType}
+		if instanceIsSelected(options, &instances[i]) {
+			selected[i] = true
+			numSelected++
+			selectedDigests[instance.Digest] = true
+		} else if instance.Platform != nil {
+			logrus.Debugf("Skipping instance %s (%s/%s)", instance.Digest, instance.Platform.OS, instance.Platform.Architecture)
+		} else {
+			logrus.Debugf("Skipping instance %s", instance.Digest)
+		}
+	}
+	if numSelected == 0 {
+		return nil, errors.New("No image in the manifest list was selected to be copied")
+	}
+	// Registries validate the references in a list, so instances which are not copied must be dropped from the list.
+	// (RemoveInstance removes all instances with a digest, so keep unselected duplicates of selected instances.)
+	removedDigests := []digest.Digest{}
+	removed := map[digest.Digest]bool{}
+	for i, instance := range instances {
+		if !selected[i] && !selectedDigests[instance.Digest] && !removed[instance.Digest] {
+			removedDigests = append(removedDigests, instance.Digest)
+			removed[instance.Digest] = true
+		}
+	}
+	listUpdated := listConverted || len(removedDigests) != 0
+
+	copied := 0
+	for i, instance := range instances {
+		if !selected[i] {
+			continue
+		}
+		copied++
+		c.Printf("Copying image %s (%d/%d)\n", instance.Digest, copied, numSelected)
+		instanceDigest := instance.Digest
+		unparsedInstance := image.UnparsedInstance(c.rawSource, &instanceDigest)
+		updatedManifest, updatedManifestType, err := c.copyOneImage(ctx, policyContext, options, unparsedInstance, &instanceDigest)
+		if err != nil {
+			return nil, err
+		}
+		updatedDigest, err := manifest.Digest(updatedManifest)
+		if err != nil {
+			return nil, err
+		}
+		if updatedDigest != instance.Digest || int64(len(updatedManifest)) != instance.Size || updatedManifestType != instance.MediaType {
+			updates[i] = manifest.ListUpdate{Digest: updatedDigest, Size: int64(len(updatedManifest)), MediaType: updatedManifestType}
+			listUpdated = true
+		}
+	}
+
+	if listUpdated {
+		if !canModifyManifestList {
+			return nil, errors.New("Copying the image list requires modifying it, which would invalidate its signatures; consider removing the signatures")
+		}
+		if err := list.UpdateInstances(updates); err != nil {
+			return nil, errors.Wrapf(err, "Error updating manifest list")
+		}
+		for _, d := range removedDigests {
+			if err := list.RemoveInstance(d); err != nil {
+				return nil, errors.Wrapf(err, "Error updating manifest list")
+			}
+		}
+		manifestList, err = list.Serialize()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error encoding updated manifest list")
+		}
+	}
+
+	c.Printf("Writing manifest list to image destination\n")
+	if err := c.dest.PutManifest(ctx, manifestList, nil); err != nil {
+		return nil, errors.Wrap(err, "Error writing manifest list")
+	}
+	c.sendManifestProgress(types.ProgressEventManifestWritten, manifestList, manifestType)
+
+	if options.SignBy != "" {
+		newSig, err := c.createSignature(manifestList, options.SignBy)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, newSig)
+	}
+
+	c.Printf("Storing list signatures\n")
+	if err := c.dest.PutSignatures(ctx, sigs, nil); err != nil {
+		return nil, errors.Wrap(err, "Error writing signatures")
+	}
+	if len(sigs) != 0 {
+		c.sendManifestProgress(types.ProgressEventSignaturesWritten, manifestList, manifestType)
+	}
+
+	return manifestList, nil
+}
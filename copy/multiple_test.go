@@ -0,0 +1,223 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker/archive"
+	"github.com/containers/image/image"
+	"github.com/containers/image/internal/testing/testimage"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/oci/layout"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDestinationManifestListMIMEType(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-multiple")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// The dir: transport accepts anything.
+	dirRef, err := directory.NewReference(tmpDir)
+	require.NoError(t, err)
+	dirDest, err := dirRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dirDest.Close()
+	assert.Equal(t, manifest.DockerV2ListMediaType, destinationManifestListMIMEType(dirDest, manifest.DockerV2ListMediaType))
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, destinationManifestListMIMEType(dirDest, imgspecv1.MediaTypeImageIndex))
+
+	// The oci: transport only accepts OCI image indexes.
+	ociRef, err := layout.NewReference(filepath.Join(tmpDir, "oci"), "")
+	require.NoError(t, err)
+	ociDest, err := ociRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer ociDest.Close()
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, destinationManifestListMIMEType(ociDest, manifest.DockerV2ListMediaType))
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, destinationManifestListMIMEType(ociDest, imgspecv1.MediaTypeImageIndex))
+
+	// The docker-archive: transport can only store single images.
+	archiveRef, err := archive.ParseReference(filepath.Join(tmpDir, "archive.tar"))
+	require.NoError(t, err)
+	archiveDest, err := archiveRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer archiveDest.Close()
+	assert.Equal(t, "", destinationManifestListMIMEType(archiveDest, manifest.DockerV2ListMediaType))
+	assert.Equal(t, "", destinationManifestListMIMEType(archiveDest, imgspecv1.MediaTypeImageIndex))
+}
+
+// putTestManifestList writes a Docker manifest list with an amd64 and an arm64 image to a dir: image in dir,
+// and returns the digests of the instances.
+func putTestManifestList(t *testing.T, dir string) []digest.Digest {
+	ref, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	components := []manifest.Schema2ManifestDescriptor{}
+	instances := []digest.Digest{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		config := []byte(fmt.Sprintf(`{"architecture":%q,"os":"linux"}`, arch))
+		m := testimage.PutBlobs(t, dest, manifest.DockerV2Schema2MediaType, config, testimage.Gzip(t, []byte(arch))).Manifest
+		instance, err := manifest.Digest(m)
+		require.NoError(t, err)
+		err = dest.PutManifest(context.Background(), m, &instance)
+		require.NoError(t, err)
+		components = append(components, manifest.Schema2ManifestDescriptor{
+			Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Size: int64(len(m)), Digest: instance},
+			Platform:          manifest.Schema2PlatformSpec{Architecture: arch, OS: "linux"},
+		})
+		instances = append(instances, instance)
+	}
+	list, err := manifest.Schema2ListFromComponents(components).Serialize()
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), list, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	return instances
+}
+
+func TestCopyManifestList(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-multiple")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcDir := filepath.Join(tmpDir, "src")
+	instances := putTestManifestList(t, srcDir)
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+
+	// The oci: transport does not accept Docker manifest lists; the list is converted to an OCI index.
+	ociRef, err := layout.NewReference(filepath.Join(tmpDir, "oci"), "latest")
+	require.NoError(t, err)
+	copiedList, err := Image(context.Background(), policyContext, ociRef, srcRef, &Options{ImageListSelection: CopyAllImages})
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, manifest.GuessMIMEType(copiedList))
+	index, err := manifest.ListFromBlob(copiedList, imgspecv1.MediaTypeImageIndex)
+	require.NoError(t, err)
+	copiedInstances := index.Instances()
+	require.Len(t, copiedInstances, len(instances))
+	src, err := ociRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	topManifest, topMIMEType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, copiedList, topManifest)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, topMIMEType)
+	for i, instance := range copiedInstances {
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, instance.MediaType)
+		require.NotNil(t, instance.Platform)
+		assert.Equal(t, []string{"amd64", "arm64"}[i], instance.Platform.Architecture)
+		m, mimeType, err := src.GetManifest(context.Background(), &instance.Digest)
+		require.NoError(t, err)
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+		assert.Equal(t, instance.Digest, digest.FromBytes(m))
+		assert.Equal(t, instance.Size, int64(len(m)))
+		img, err := image.FromUnparsedImage(context.Background(), nil, image.UnparsedInstance(src, &instance.Digest))
+		require.NoError(t, err)
+		config, err := img.OCIConfig(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, instance.Platform.Architecture, config.Architecture)
+		for _, layer := range img.LayerInfos() {
+			stream, _, err := src.GetBlob(context.Background(), layer, blobinfocache.NoCache)
+			require.NoError(t, err)
+			stream.Close()
+		}
+	}
+
+	// The docker-archive: transport can not store manifest lists; only the image matching the system is copied.
+	archiveRef, err := archive.ParseReference(filepath.Join(tmpDir, "arm64.tar"))
+	require.NoError(t, err)
+	copied, err := Image(context.Background(), policyContext, archiveRef, srcRef, &Options{
+		ImageListSelection: CopyAllImages,
+		SourceCtx:          &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, manifest.GuessMIMEType(copied))
+	srcSource, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer srcSource.Close()
+	arm64Manifest, _, err := srcSource.GetManifest(context.Background(), &instances[1])
+	require.NoError(t, err)
+	arm64Parsed, err := manifest.Schema2FromManifest(arm64Manifest)
+	require.NoError(t, err)
+	copiedParsed, err := manifest.Schema2FromManifest(copied)
+	require.NoError(t, err)
+	assert.Equal(t, arm64Parsed.ConfigInfo().Digest, copiedParsed.ConfigInfo().Digest)
+}
+
+func TestInstanceIsSelected(t *testing.T) {
+	amd64 := manifest.ListInstance{
+		Digest:   digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111"),
+		Platform: &imgspecv1.Platform{Architecture: "amd64", OS: "linux"},
+	}
+	armV7 := manifest.ListInstance{
+		Digest:   digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222"),
+		Platform: &imgspecv1.Platform{Architecture: "arm", OS: "linux", Variant: "v7"},
+	}
+	windows := manifest.ListInstance{
+		Digest:   digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333"),
+		Platform: &imgspecv1.Platform{Architecture: "amd64", OS: "windows", OSVersion: "10.0.14393.1066"},
+	}
+
+	for _, c := range []struct {
+		options  Options
+		expected []bool // amd64, armV7, windows
+	}{
+		{Options{}, []bool{true, true, true}},
+		{Options{ImageListSelection: CopySystemImage, Instances: []digest.Digest{amd64.Digest}}, []bool{true, true, true}},
+		{Options{ImageListSelection: CopyAllImages, Instances: []digest.Digest{amd64.Digest}}, []bool{true, true, true}},
+		{Options{ImageListSelection: CopySpecificImages}, []bool{false, false, false}},
+		{Options{ImageListSelection: CopySpecificImages, Instances: []digest.Digest{armV7.Digest}}, []bool{false, true, false}},
+		{
+			Options{ImageListSelection: CopySpecificImages, Instances: []digest.Digest{armV7.Digest, windows.Digest}},
+			[]bool{false, true, true},
+		},
+		{
+			Options{ImageListSelection: CopySpecificImages, InstancePlatforms: []imgspecv1.Platform{{Architecture: "amd64", OS: "linux"}}},
+			[]bool{true, false, false},
+		},
+		{
+			Options{ImageListSelection: CopySpecificImages, InstancePlatforms: []imgspecv1.Platform{{Architecture: "arm", OS: "linux"}}},
+			[]bool{false, true, false},
+		},
+		{
+			Options{ImageListSelection: CopySpecificImages, InstancePlatforms: []imgspecv1.Platform{{Architecture: "arm", OS: "linux", Variant: "v6"}}},
+			[]bool{false, false, false},
+		},
+		{
+			Options{ImageListSelection: CopySpecificImages, InstancePlatforms: []imgspecv1.Platform{{Architecture: "amd64", OS: "windows", OSVersion: "10.0.14393.1066"}}},
+			[]bool{false, false, true},
+		},
+		{
+			Options{ImageListSelection: CopySpecificImages, InstancePlatforms: []imgspecv1.Platform{{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17134.1"}}},
+			[]bool{false, false, false},
+		},
+		{
+			Options{
+				ImageListSelection: CopySpecificImages,
+				Instances:          []digest.Digest{windows.Digest},
+				InstancePlatforms:  []imgspecv1.Platform{{Architecture: "amd64", OS: "linux"}},
+			},
+			[]bool{true, false, true},
+		},
+	} {
+		for i, instance := range []manifest.ListInstance{amd64, armV7, windows} {
+			assert.Equal(t, c.expected[i], instanceIsSelected(&c.options, &instance), "%#v, %s", c.options, instance.Digest)
+		}
+	}
+}
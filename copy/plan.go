@@ -0,0 +1,290 @@
+package copy
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// BlobPlan describes what copy.Image would do with a single blob.
+type BlobPlan struct {
+	SrcInfo types.BlobInfo // The blob as referenced by the source
+	// Reused is true if the blob already exists at the destination, and would not be copied.
+	Reused bool
+	// Foreign is true if the blob is a foreign layer, which would not be copied because the destination accepts foreign layer URLs.
+	Foreign bool
+	// CompressionOperation and CompressionAlgorithm describe how the blob would be modified, if it is copied.
+	CompressionOperation types.LayerCompression
+	CompressionAlgorithm *compression.Algorithm
+	// TransferSize is the number of bytes which would be read from the source, or -1 if unknown.
+	TransferSize int64
+}
+
+// ImagePlan describes what copy.Image would do with a single image (possibly an instance of a manifest list).
+type ImagePlan struct {
+	// Instance is the digest of the source manifest, if the image is an instance of a manifest list, or "".
+	Instance digest.Digest
+	// ManifestMIMEType is the MIME type which would be used for the manifest first.  OtherManifestMIMETypeCandidates
+	// would be tried if the destination rejects it.
+	ManifestMIMEType                string
+	OtherManifestMIMETypeCandidates []string
+	// Config describes the source config blob; it is not set for images without one (i.e. Docker schema1).
+	// If the manifest is converted, the config is always reported as copied.
+	Config *BlobPlan
+	Layers []BlobPlan
+}
+
+// Plan describes what copy.Image would do, as computed by PlanImage.
+type Plan struct {
+	// ManifestList is true if a manifest list would be copied, and Images contains its selected instances.
+	ManifestList bool
+	Images       []ImagePlan
+	// TransferSize is the total number of bytes which would be read from the source, not including blobs counted in
+	// UnknownSizeBlobs.
+	TransferSize     int64
+	UnknownSizeBlobs int
+}
+
+// destinationIsInspectable returns true if ref declares that creating an ImageDestination, and checking for existing blobs,
+// does not modify the destination; see types.ImageReferenceWithInspectableDestination.
+func destinationIsInspectable(ref types.ImageReference) bool {
+	inspectable, ok := ref.(types.ImageReferenceWithInspectableDestination)
+	return ok && inspectable.DestinationIsInspectable()
+}
+
+// PlanImage computes what Image would do when copying srcRef to destRef using the same parameters, without copying any
+// blobs or writing anything to the destination.  Whether blobs already exist at the destination is determined using
+// the same checks PutBlob does (e.g. HEAD requests for registries); cross-repository mounts are not attempted, so
+// blobs which Image could mount are reported as copied.
+// Only destinations which can be opened without modifying them (see types.ImageReferenceWithInspectableDestination),
+// i.e. registries, are supported.
+// The compression of layers is determined from their MIME types; layers without a known MIME type (i.e. in Docker schema1
+// images) are assumed to be gzip-compressed.
+func PlanImage(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (*Plan, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	if !destinationIsInspectable(destRef) {
+		return nil, errors.Errorf("Planning copies to %s is not supported, opening a %s: destination may modify it", transports.ImageName(destRef), destRef.Transport().Name())
+	}
+	dest, err := destRef.NewImageDestination(ctx, options.DestinationCtx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error initializing destination %s", transports.ImageName(destRef))
+	}
+	defer dest.Close()
+	rawSource, err := srcRef.NewImageSource(ctx, options.SourceCtx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error initializing source %s", transports.ImageName(srcRef))
+	}
+	defer rawSource.Close()
+
+	c := newCopier(dest, rawSource, ioutil.Discard, options)
+	plan := &Plan{}
+	unparsedToplevel := image.UnparsedInstance(rawSource, nil)
+	multiImage, err := isMultiImage(ctx, unparsedToplevel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error determining manifest MIME type for %s", transports.ImageName(srcRef))
+	}
+	toplevelManifest, toplevelMIMEType, err := unparsedToplevel.Manifest(ctx) // Already cached by isMultiImage
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading manifest for %s", transports.ImageName(srcRef))
+	}
+
+	if !multiImage {
+		imagePlan, err := c.planOneImage(ctx, policyContext, options, unparsedToplevel)
+		if err != nil {
+			return nil, err
+		}
+		plan.Images = append(plan.Images, *imagePlan)
+	} else if options.ImageListSelection == CopySystemImage || destinationManifestListMIMEType(dest, toplevelMIMEType) == "" {
+		instanceDigest, err := image.ChooseManifestInstanceFromManifestList(ctx, options.SourceCtx, unparsedToplevel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error choosing an image from manifest list %s", transports.ImageName(srcRef))
+		}
+		imagePlan, err := c.planOneImage(ctx, policyContext, options, image.UnparsedInstance(rawSource, &instanceDigest))
+		if err != nil {
+			return nil, err
+		}
+		imagePlan.Instance = instanceDigest
+		plan.Images = append(plan.Images, *imagePlan)
+	} else {
+		list, err := manifest.ListFromBlob(toplevelManifest, toplevelMIMEType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing manifest list")
+		}
+		plan.ManifestList = true
+		instances := list.Instances()
+		for i := range instances {
+			if !instanceIsSelected(options, &instances[i]) {
+				continue
+			}
+			instanceDigest := instances[i].Digest
+			imagePlan, err := c.planOneImage(ctx, policyContext, options, image.UnparsedInstance(rawSource, &instanceDigest))
+			if err != nil {
+				return nil, err
+			}
+			imagePlan.Instance = instanceDigest
+			plan.Images = append(plan.Images, *imagePlan)
+		}
+		if len(plan.Images) == 0 {
+			return nil, errors.New("No image in the manifest list was selected to be copied")
+		}
+	}
+
+	for _, imagePlan := range plan.Images {
+		blobs := imagePlan.Layers
+		if imagePlan.Config != nil {
+			blobs = append([]BlobPlan{*imagePlan.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			if blob.TransferSize == -1 {
+				plan.UnknownSizeBlobs++
+			} else {
+				plan.TransferSize += blob.TransferSize
+			}
+		}
+	}
+	return plan, nil
+}
+
+// planOneImage computes what copyOneImage would do with unparsedImage.
+func (c *copier) planOneImage(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedImage *image.UnparsedImage) (*ImagePlan, error) {
+	multiImage, err := isMultiImage(ctx, unparsedImage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error determining manifest MIME type for %s", transports.ImageName(unparsedImage.Reference()))
+	}
+	if multiImage {
+		return nil, errors.New("Unexpectedly received a manifest list instead of a manifest for a single image")
+	}
+	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
+		return nil, errors.Wrap(err, "Source image rejected")
+	}
+	src, err := image.FromUnparsedImage(ctx, options.SourceCtx, unparsedImage)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error initializing image from source %s", transports.ImageName(c.rawSource.Reference()))
+	}
+	if err := checkImageDestinationForCurrentRuntimeOS(ctx, options.DestinationCtx, src, c.dest); err != nil {
+		return nil, err
+	}
+
+	hasSignatures := false
+	if !options.RemoveSignatures {
+		sigs, err := src.Signatures(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading signatures")
+		}
+		if len(sigs) != 0 {
+			if err := c.dest.SupportsSignatures(ctx); err != nil {
+				return nil, errors.Wrap(err, "Can not copy signatures")
+			}
+			hasSignatures = true
+		}
+	}
+
+	ic := imageCopier{
+		c:                   c,
+		manifestUpdates:     &types.ManifestUpdateOptions{InformationOnly: types.ManifestUpdateInformation{Destination: c.dest}},
+		src:                 src,
+		canModifyManifest:   !hasSignatures,
+		canSubstituteBlobs:  !hasSignatures && options.SignBy == "",
+		ociManifestRequired: c.layerCompressionRequiresOCI(),
+	}
+	if err := ic.updateEmbeddedDockerReference(); err != nil {
+		return nil, err
+	}
+	preferredManifestMIMEType, otherManifestMIMETypeCandidates, err := ic.determineManifestConversion(ctx, c.dest.SupportedManifestMIMETypes(), options.ForceManifestMIMEType)
+	if err != nil {
+		return nil, err
+	}
+	ic.diffIDsAreNeeded = src.UpdatedImageNeedsLayerDiffIDs(*ic.manifestUpdates)
+
+	imagePlan := &ImagePlan{
+		ManifestMIMEType:                preferredManifestMIMEType,
+		OtherManifestMIMETypeCandidates: otherManifestMIMETypeCandidates,
+		Layers:                          []BlobPlan{},
+	}
+	if configInfo := src.ConfigInfo(); configInfo.Digest != "" {
+		// If the manifest is converted, the config may be converted as well, so we can't check whether it already exists.
+		configPlan, err := ic.planBlob(ctx, configInfo, ic.manifestUpdates.ManifestMIMEType == "", false)
+		if err != nil {
+			return nil, err
+		}
+		imagePlan.Config = &configPlan
+	}
+
+	srcInfos := src.LayerInfos()
+	updatedSrcInfos, err := src.LayerInfosForCopy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if updatedSrcInfos != nil {
+		srcInfos = updatedSrcInfos
+	}
+	for _, srcInfo := range srcInfos {
+		if c.dest.AcceptsForeignLayerURLs() && len(srcInfo.URLs) != 0 {
+			if ic.diffIDsAreNeeded {
+				return nil, errors.New("getting DiffID for foreign layers is unimplemented")
+			}
+			imagePlan.Layers = append(imagePlan.Layers, BlobPlan{SrcInfo: srcInfo, Foreign: true})
+			continue
+		}
+		diffIDIsNeeded := ic.diffIDsAreNeeded && c.blobInfoCache.UncompressedDigest(srcInfo.Digest) == ""
+		layerPlan, err := ic.planBlob(ctx, srcInfo, !diffIDIsNeeded, ic.canModifyManifest)
+		if err != nil {
+			return nil, err
+		}
+		imagePlan.Layers = append(imagePlan.Layers, layerPlan)
+	}
+	return imagePlan, nil
+}
+
+// planBlob computes what would happen to srcInfo when copying it; it is reused if canReuse and it exists at the destination,
+// and it can be compressed or decompressed if canModifyBlob.
+func (ic *imageCopier) planBlob(ctx context.Context, srcInfo types.BlobInfo, canReuse, canModifyBlob bool) (BlobPlan, error) {
+	if canReuse {
+		// Use NoCache, so that the destination only checks for blobs it already has, and does not attempt to
+		// create any (e.g. by mounting them from other repositories).
+		reused, _, err := ic.c.dest.TryReusingBlob(ctx, srcInfo, blobinfocache.NoCache, ic.canSubstituteBlobs)
+		if err != nil {
+			return BlobPlan{}, errors.Wrapf(err, "Error trying to reuse blob %s at destination", srcInfo.Digest)
+		}
+		if reused {
+			return BlobPlan{SrcInfo: srcInfo, Reused: true}, nil
+		}
+	}
+
+	plan := BlobPlan{SrcInfo: srcInfo, TransferSize: srcInfo.Size}
+	isCompressed, compressionFormat := compressionFromMIMEType(srcInfo.MediaType)
+	plan.CompressionOperation = ic.c.layerCompressionOperation(canModifyBlob, isCompressed, compressionFormat)
+	switch plan.CompressionOperation {
+	case types.Compress:
+		plan.CompressionAlgorithm = &ic.c.compressionFormat
+	case types.Decompress:
+		plan.CompressionAlgorithm = &compressionFormat
+	}
+	return plan, nil
+}
+
+// compressionFromMIMEType returns whether a blob with mimeType is compressed, and the compression algorithm.
+// Unknown MIME types are assumed to be gzip-compressed, as Docker schema1 layers are.
+func compressionFromMIMEType(mimeType string) (bool, compression.Algorithm) {
+	switch {
+	case strings.HasSuffix(mimeType, "zstd"):
+		return true, compression.Zstd
+	case strings.HasSuffix(mimeType, ".tar") || mimeType == manifest.DockerV2Schema2ConfigMediaType || strings.HasSuffix(mimeType, "+json"):
+		return false, compression.Algorithm{}
+	default:
+		return true, compression.Gzip
+	}
+}
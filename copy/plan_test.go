@@ -0,0 +1,152 @@
+package copy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/oci/layout"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planTestRegistry is a minimal registry implementation which only answers blob existence checks and manifest reads,
+// and fails on any other request (in particular, any attempt to write to it, or to read a blob).
+type planTestRegistry struct {
+	mutex        sync.Mutex
+	blobs        map[string]bool   // Paths of existing blobs
+	manifests    map[string][]byte // Paths and contents of existing schema2 manifests
+	otherRequest bool              // Set if any unexpected request has been received
+}
+
+func (r *planTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.Method == "HEAD" && r.blobs[req.URL.Path]:
+		w.WriteHeader(http.StatusOK)
+	case req.Method == "HEAD" && strings.HasPrefix(req.URL.Path, "/v2/ns/image/blobs/"):
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == "GET" && r.manifests[req.URL.Path] != nil:
+		w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(r.manifests[req.URL.Path])
+	default:
+		r.otherRequest = true
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestPlanImage(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-plan")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcDir := filepath.Join(tmpDir, "src")
+	m, layer := putVerificationTestImage(t, srcDir)
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	parsed, err := manifest.Schema2FromManifest(m)
+	require.NoError(t, err)
+	config := parsed.ConfigInfo()
+
+	registry := &planTestRegistry{blobs: map[string]bool{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	destRef, err := docker.ParseReference("//" + strings.TrimPrefix(server.URL, "http://") + "/ns/image:tag")
+	require.NoError(t, err)
+	options := &Options{DestinationCtx: &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+		RegistriesDirPath:           tmpDir,
+		DockerCertPath:              tmpDir,
+		SystemRegistriesConfPath:    filepath.Join(tmpDir, "registries.conf"),
+	}}
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+
+	// Nothing exists at the destination yet
+	plan, err := PlanImage(context.Background(), policyContext, destRef, srcRef, options)
+	require.NoError(t, err)
+	assert.False(t, plan.ManifestList)
+	require.Len(t, plan.Images, 1)
+	imagePlan := plan.Images[0]
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, imagePlan.ManifestMIMEType)
+	require.NotNil(t, imagePlan.Config)
+	assert.Equal(t, config.Digest, imagePlan.Config.SrcInfo.Digest)
+	assert.False(t, imagePlan.Config.Reused)
+	require.Len(t, imagePlan.Layers, 1)
+	assert.Equal(t, layer.Digest, imagePlan.Layers[0].SrcInfo.Digest)
+	assert.False(t, imagePlan.Layers[0].Reused)
+	assert.Equal(t, types.PreserveOriginal, imagePlan.Layers[0].CompressionOperation)
+	assert.Equal(t, layer.Size, imagePlan.Layers[0].TransferSize)
+	assert.Equal(t, config.Size+layer.Size, plan.TransferSize)
+	assert.Equal(t, 0, plan.UnknownSizeBlobs)
+
+	// Existing blobs are reused
+	registry.mutex.Lock()
+	registry.blobs["/v2/ns/image/blobs/"+layer.Digest.String()] = true
+	registry.mutex.Unlock()
+	plan, err = PlanImage(context.Background(), policyContext, destRef, srcRef, options)
+	require.NoError(t, err)
+	require.Len(t, plan.Images, 1)
+	assert.False(t, plan.Images[0].Config.Reused)
+	require.Len(t, plan.Images[0].Layers, 1)
+	assert.True(t, plan.Images[0].Layers[0].Reused)
+	assert.Equal(t, config.Size, plan.TransferSize)
+	// Nothing but existence checks was requested
+	assert.False(t, registry.otherRequest)
+
+	// Destinations which would be modified by opening them are refused, and left intact.
+	destDir := filepath.Join(tmpDir, "dest")
+	_, _ = putVerificationTestImage(t, destDir)
+	dirRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	ociRef, err := layout.NewReference(filepath.Join(tmpDir, "oci"), "latest")
+	require.NoError(t, err)
+	for _, ref := range []types.ImageReference{dirRef, ociRef} {
+		_, err = PlanImage(context.Background(), policyContext, ref, srcRef, nil)
+		assert.Error(t, err, transports.ImageName(ref))
+	}
+	_, err = os.Stat(filepath.Join(destDir, "manifest.json"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(tmpDir, "oci"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCompressionFromMIMEType(t *testing.T) {
+	for _, c := range []struct {
+		mimeType     string
+		isCompressed bool
+		algorithm    string
+	}{
+		{manifest.DockerV2Schema2LayerMediaType, true, compression.Gzip.Name()},
+		{imgspecv1.MediaTypeImageLayerGzip, true, compression.Gzip.Name()},
+		{manifest.OCI1LayerZstdMediaType, true, compression.Zstd.Name()},
+		{imgspecv1.MediaTypeImageLayer, false, ""},
+		{imgspecv1.MediaTypeImageLayerNonDistributable, false, ""},
+		{imgspecv1.MediaTypeImageConfig, false, ""},
+		{manifest.DockerV2Schema2ConfigMediaType, false, ""},
+		{"", true, compression.Gzip.Name()},
+	} {
+		isCompressed, algorithm := compressionFromMIMEType(c.mimeType)
+		assert.Equal(t, c.isCompressed, isCompressed, c.mimeType)
+		assert.Equal(t, c.algorithm, algorithm.Name(), c.mimeType)
+	}
+}
@@ -7,22 +7,30 @@ import (
 	"github.com/containers/image/types"
 )
 
-// progressReader is a reader that reports its progress on an interval.
+// progressReader is a reader that counts the bytes read, and reports its progress on an interval if channel is not nil.
 type progressReader struct {
 	source   io.Reader
-	channel  chan types.ProgressProperties
+	channel  chan types.ProgressProperties // nil if progress should not be reported
 	interval time.Duration
 	artifact types.BlobInfo
 	lastTime time.Time
 	offset   uint64
+	// lastOffset is the offset reported in the previous ProgressEventRead.
+	lastOffset uint64
 }
 
 func (r *progressReader) Read(p []byte) (int, error) {
 	n, err := r.source.Read(p)
 	r.offset += uint64(n)
-	if time.Since(r.lastTime) > r.interval {
-		r.channel <- types.ProgressProperties{Artifact: r.artifact, Offset: r.offset}
+	if r.channel != nil && time.Since(r.lastTime) > r.interval {
+		r.channel <- types.ProgressProperties{
+			Event:        types.ProgressEventRead,
+			Artifact:     r.artifact,
+			Offset:       r.offset,
+			OffsetUpdate: r.offset - r.lastOffset,
+		}
 		r.lastTime = time.Now()
+		r.lastOffset = r.offset
 	}
 	return n, err
 }
@@ -0,0 +1,38 @@
+package copy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReader(t *testing.T) {
+	channel := make(chan types.ProgressProperties, 10)
+	artifact := types.BlobInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: 100}
+	r := &progressReader{
+		source:   bytes.NewReader(make([]byte, 100)),
+		channel:  channel,
+		interval: time.Nanosecond,
+		artifact: artifact,
+		lastTime: time.Now(),
+	}
+	buf := make([]byte, 40)
+	for _, expected := range []struct{ offset, update uint64 }{{40, 40}, {80, 40}, {100, 20}} {
+		_, err := r.Read(buf)
+		require.NoError(t, err)
+		props := <-channel
+		assert.Equal(t, types.ProgressProperties{
+			Event:        types.ProgressEventRead,
+			Artifact:     artifact,
+			Offset:       expected.offset,
+			OffsetUpdate: expected.update,
+		}, props)
+	}
+	_, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+}
@@ -0,0 +1,222 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// VerificationMode is one of VerifyNone, VerifyExistence or VerifyDigests, to control whether, and how thoroughly,
+// copy.Image() verifies the destination image after it is committed.
+type VerificationMode int
+
+const (
+	// VerifyNone is the default value, which disables verification.
+	VerifyNone VerificationMode = iota
+	// VerifyExistence verifies the digests of all manifests, and that all blobs exist (and have the expected size, if the
+	// transport reports it), without reading their contents.  For registries, blobs are only checked using HEAD requests.
+	VerifyExistence
+	// VerifyDigests verifies the digests of all manifests and all blobs, by reading all of the blobs.
+	VerifyDigests
+)
+
+// ManifestVerificationResult is the result of verifying one manifest.
+type ManifestVerificationResult struct {
+	Digest digest.Digest // The expected digest of the manifest
+	Err    error         // nil if the manifest was successfully verified
+}
+
+// BlobVerificationResult is the result of verifying one blob.
+type BlobVerificationResult struct {
+	Instance digest.Digest  // The digest of the manifest referencing the blob
+	BlobInfo types.BlobInfo // The blob as referenced by the manifest
+	Err      error          // nil if the blob was successfully verified
+}
+
+// VerificationReport contains the results of verifying an image.
+type VerificationReport struct {
+	// Manifests contains the top-level manifest first, followed by the instances of a manifest list, if any.
+	Manifests []ManifestVerificationResult
+	Blobs     []BlobVerificationResult
+}
+
+// Err returns an error summarizing the failures in r, or nil if everything was successfully verified.
+func (r *VerificationReport) Err() error {
+	failedManifests, failedBlobs := 0, 0
+	var firstErr error
+	for _, m := range r.Manifests {
+		if m.Err != nil {
+			if firstErr == nil {
+				firstErr = m.Err
+			}
+			failedManifests++
+		}
+	}
+	for _, b := range r.Blobs {
+		if b.Err != nil {
+			if firstErr == nil {
+				firstErr = b.Err
+			}
+			failedBlobs++
+		}
+	}
+	if firstErr == nil {
+		return nil
+	}
+	return errors.Wrapf(firstErr, "Verification failed for %d of %d manifests and %d of %d blobs", failedManifests, len(r.Manifests), failedBlobs, len(r.Blobs))
+}
+
+// VerifyImage reads the image at ref, and verifies that its manifest matches expectedManifest, and that the manifests
+// and blobs it references are intact, as specified by mode.
+// It returns a report of the results; use VerificationReport.Err to determine whether the verification succeeded.
+// The returned error is non-nil only if the verification could not be performed at all.
+func VerifyImage(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, expectedManifest []byte, mode VerificationMode) (*VerificationReport, error) {
+	return verifyImage(ctx, sys, ref, nil, expectedManifest, mode)
+}
+
+// verifyImage is VerifyImage, optionally using an already open dest for ref.
+// With VerifyExistence, blobs in references which can be opened as a destination without modifying them (see
+// destinationIsInspectable) are checked using dest.TryReusingBlob, which avoids starting to download them; dest is opened
+// if it is nil.  Blobs in other transports are opened using GetBlob, but not read.
+func verifyImage(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, dest types.ImageDestination, expectedManifest []byte, mode VerificationMode) (*VerificationReport, error) {
+	if mode != VerifyExistence && mode != VerifyDigests {
+		return nil, errors.Errorf("Invalid verification mode %d", mode)
+	}
+	expectedDigest, err := manifest.Digest(expectedManifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error computing the expected manifest digest")
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening %s for verification", transports.ImageName(ref))
+	}
+	defer src.Close()
+	if mode != VerifyExistence || !destinationIsInspectable(ref) {
+		dest = nil
+	} else if dest == nil {
+		dest, err = ref.NewImageDestination(ctx, sys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error opening %s for verification", transports.ImageName(ref))
+		}
+		defer dest.Close()
+	}
+
+	report := &VerificationReport{}
+	toplevel, toplevelMIMEType, ok := verifyManifest(ctx, report, src, nil, expectedDigest)
+	if !ok {
+		return report, nil
+	}
+	if !manifest.MIMETypeIsMultiImage(toplevelMIMEType) {
+		verifyImageBlobs(ctx, report, src, dest, expectedDigest, toplevel, toplevelMIMEType, mode)
+		return report, nil
+	}
+
+	list, err := manifest.ListFromBlob(toplevel, toplevelMIMEType)
+	if err != nil {
+		report.Manifests[0].Err = errors.Wrapf(err, "Error parsing manifest list %s", expectedDigest)
+		return report, nil
+	}
+	for _, instance := range list.Instances() {
+		instanceDigest := instance.Digest
+		m, mimeType, ok := verifyManifest(ctx, report, src, &instanceDigest, instanceDigest)
+		if ok {
+			verifyImageBlobs(ctx, report, src, dest, instanceDigest, m, mimeType, mode)
+		}
+	}
+	return report, nil
+}
+
+// verifyManifest reads the manifest for instanceDigest from src, records whether it matches expectedDigest in report,
+// and returns the manifest, its MIME type, and true if it does match.
+func verifyManifest(ctx context.Context, report *VerificationReport, src types.ImageSource, instanceDigest *digest.Digest, expectedDigest digest.Digest) ([]byte, string, bool) {
+	result := ManifestVerificationResult{Digest: expectedDigest}
+	m, mimeType, err := src.GetManifest(ctx, instanceDigest)
+	if err != nil {
+		result.Err = errors.Wrapf(err, "Error reading manifest %s", expectedDigest)
+	} else if matches, err := manifest.MatchesDigest(m, expectedDigest); err != nil {
+		result.Err = errors.Wrapf(err, "Error computing digest of manifest %s", expectedDigest)
+	} else if !matches {
+		result.Err = errors.Errorf("Manifest does not match the expected digest %s", expectedDigest)
+	}
+	report.Manifests = append(report.Manifests, result)
+	return m, mimeType, result.Err == nil
+}
+
+// verifyImageBlobs verifies the config and layer blobs referenced by the single-image manifest m, which has manifestDigest,
+// and records the results in report.
+func verifyImageBlobs(ctx context.Context, report *VerificationReport, src types.ImageSource, dest types.ImageDestination, manifestDigest digest.Digest, m []byte, mimeType string, mode VerificationMode) {
+	parsed, err := manifest.FromBlob(m, mimeType)
+	if err != nil {
+		report.Manifests[len(report.Manifests)-1].Err = errors.Wrapf(err, "Error parsing manifest %s", manifestDigest)
+		return
+	}
+	blobs := []types.BlobInfo{}
+	if config := parsed.ConfigInfo(); config.Digest != "" { // Schema1 manifests have no config blob
+		blobs = append(blobs, config)
+	}
+	seen := map[digest.Digest]bool{}
+	for _, layer := range parsed.LayerInfos() {
+		if seen[layer.Digest] || len(layer.URLs) != 0 { // Foreign layers are not stored in the destination
+			continue
+		}
+		seen[layer.Digest] = true
+		blobs = append(blobs, layer.BlobInfo)
+	}
+	for _, blob := range blobs {
+		report.Blobs = append(report.Blobs, BlobVerificationResult{
+			Instance: manifestDigest,
+			BlobInfo: blob,
+			Err:      verifyBlob(ctx, src, dest, blob, mode),
+		})
+	}
+}
+
+// verifyBlob verifies blob in src, as specified by mode.  With VerifyExistence, dest, if not nil, is used to check
+// for the blob instead of src.
+func verifyBlob(ctx context.Context, src types.ImageSource, dest types.ImageDestination, blob types.BlobInfo, mode VerificationMode) error {
+	if mode == VerifyExistence && dest != nil {
+		// blobinfocache.NoCache and canSubstitute == false restrict this to checking for the blob itself, in dest.
+		exists, info, err := dest.TryReusingBlob(ctx, blob, blobinfocache.NoCache, false)
+		if err != nil {
+			return errors.Wrapf(err, "Error checking for blob %s", blob.Digest)
+		}
+		if !exists {
+			return errors.Errorf("Blob %s does not exist", blob.Digest)
+		}
+		return verifyBlobSize(blob, info.Size)
+	}
+
+	if mode == VerifyDigests {
+		src = image.NewVerifyingSource(src)
+	}
+	stream, size, err := src.GetBlob(ctx, blob, blobinfocache.NoCache)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading blob %s", blob.Digest)
+	}
+	defer stream.Close()
+	if mode == VerifyExistence {
+		return verifyBlobSize(blob, size)
+	}
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return errors.Wrapf(err, "Error reading blob %s", blob.Digest)
+	}
+	return nil
+}
+
+// verifyBlobSize returns an error if size, as reported by a transport, does not match blob.
+// Unknown sizes (-1) are not compared.
+func verifyBlobSize(blob types.BlobInfo, size int64) error {
+	if blob.Size != -1 && size != -1 && size != blob.Size {
+		return fmt.Errorf("Size of blob %s did not match, expected %d, got %d", blob.Digest, blob.Size, size)
+	}
+	return nil
+}
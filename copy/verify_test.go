@@ -0,0 +1,148 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/internal/testing/testimage"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putVerificationTestImage writes an image with a config and a gzip-compressed layer to dir, and returns the manifest and the layer.
+func putVerificationTestImage(t *testing.T, dir string) ([]byte, types.BlobInfo) {
+	ref, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	img := testimage.Put(t, nil, ref, manifest.DockerV2Schema2MediaType, []byte(`{"architecture":"amd64","os":"linux"}`),
+		testimage.Gzip(t, bytes.Repeat([]byte{'x'}, 1000)))
+	return img.Manifest, img.Layer
+}
+
+func TestVerifyImage(t *testing.T) {
+	corrupt := func(p string, size int64) error {
+		return ioutil.WriteFile(p, bytes.Repeat([]byte{'y'}, int(size)), 0644)
+	}
+	truncate := func(p string, _ int64) error { return ioutil.WriteFile(p, []byte{'x'}, 0644) }
+	remove := func(p string, _ int64) error { return os.Remove(p) }
+	for _, c := range []struct {
+		name        string
+		modify      func(layerPath string, size int64) error
+		mode        VerificationMode
+		expectError bool
+	}{
+		{"intact, digests", nil, VerifyDigests, false},
+		{"intact, existence", nil, VerifyExistence, false},
+		{"corrupted, digests", corrupt, VerifyDigests, true},
+		// A corrupted blob with the expected size is not detected without reading it
+		{"corrupted, existence", corrupt, VerifyExistence, false},
+		{"truncated, existence", truncate, VerifyExistence, true},
+		{"missing, digests", remove, VerifyDigests, true},
+		{"missing, existence", remove, VerifyExistence, true},
+	} {
+		tmpDir, err := ioutil.TempDir("", "copy-verify")
+		require.NoError(t, err)
+		defer os.RemoveAll(tmpDir)
+		m, layer := putVerificationTestImage(t, tmpDir)
+		if c.modify != nil {
+			err := c.modify(filepath.Join(tmpDir, layer.Digest.Hex()), layer.Size)
+			require.NoError(t, err, c.name)
+		}
+
+		ref, err := directory.NewReference(tmpDir)
+		require.NoError(t, err)
+		report, err := VerifyImage(context.Background(), nil, ref, m, c.mode)
+		require.NoError(t, err, c.name)
+		require.Len(t, report.Manifests, 1, c.name)
+		assert.Equal(t, digest.FromBytes(m), report.Manifests[0].Digest, c.name)
+		assert.NoError(t, report.Manifests[0].Err, c.name)
+		require.Len(t, report.Blobs, 2, c.name)
+		assert.NoError(t, report.Blobs[0].Err, c.name) // The config
+		assert.Equal(t, layer.Digest, report.Blobs[1].BlobInfo.Digest, c.name)
+		if c.expectError {
+			assert.Error(t, report.Blobs[1].Err, c.name)
+			assert.Error(t, report.Err(), c.name)
+		} else {
+			assert.NoError(t, report.Blobs[1].Err, c.name)
+			assert.NoError(t, report.Err(), c.name)
+		}
+	}
+
+	// A different manifest
+	tmpDir, err := ioutil.TempDir("", "copy-verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	m, _ := putVerificationTestImage(t, tmpDir)
+	ref, err := directory.NewReference(tmpDir)
+	require.NoError(t, err)
+	report, err := VerifyImage(context.Background(), nil, ref, append([]byte(" "), m...), VerifyDigests)
+	require.NoError(t, err)
+	require.Len(t, report.Manifests, 1)
+	assert.Error(t, report.Manifests[0].Err)
+	assert.Len(t, report.Blobs, 0)
+	assert.Error(t, report.Err())
+
+	// Invalid mode
+	_, err = VerifyImage(context.Background(), nil, ref, m, VerifyNone)
+	assert.Error(t, err)
+}
+
+func TestVerifyImageRegistry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	m, layer := putVerificationTestImage(t, tmpDir)
+	parsed, err := manifest.Schema2FromManifest(m)
+	require.NoError(t, err)
+	config := parsed.ConfigInfo()
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "registries.conf"), []byte{}, 0600)
+	require.NoError(t, err)
+
+	registry := &planTestRegistry{
+		blobs: map[string]bool{
+			"/v2/ns/image/blobs/" + config.Digest.String(): true,
+			"/v2/ns/image/blobs/" + layer.Digest.String():  true,
+		},
+		manifests: map[string][]byte{"/v2/ns/image/manifests/tag": m},
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	ref, err := docker.ParseReference("//" + strings.TrimPrefix(server.URL, "http://") + "/ns/image:tag")
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+		RegistriesDirPath:           tmpDir,
+		DockerCertPath:              tmpDir,
+		SystemRegistriesConfPath:    filepath.Join(tmpDir, "registries.conf"),
+	}
+
+	// VerifyExistence only checks for existence of the blobs, without reading them
+	report, err := VerifyImage(context.Background(), sys, ref, m, VerifyExistence)
+	require.NoError(t, err)
+	require.Len(t, report.Blobs, 2)
+	assert.NoError(t, report.Err())
+	assert.False(t, registry.otherRequest)
+
+	// Missing blobs are detected
+	registry.mutex.Lock()
+	delete(registry.blobs, "/v2/ns/image/blobs/"+layer.Digest.String())
+	registry.mutex.Unlock()
+	report, err = VerifyImage(context.Background(), sys, ref, m, VerifyExistence)
+	require.NoError(t, err)
+	require.Len(t, report.Blobs, 2)
+	assert.NoError(t, report.Blobs[0].Err)
+	assert.Equal(t, layer.Digest, report.Blobs[1].BlobInfo.Digest)
+	assert.Error(t, report.Blobs[1].Err)
+	assert.False(t, registry.otherRequest)
+}
@@ -35,7 +35,12 @@ func TestGetPutManifest(t *testing.T) {
 	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
-	err = dest.PutManifest(context.Background(), man)
+	list := []byte("test-manifest-list")
+	md, err := manifest.Digest(man)
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), man, &md)
+	assert.NoError(t, err)
+	err = dest.PutManifest(context.Background(), list, nil)
 	assert.NoError(t, err)
 	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
@@ -45,13 +50,17 @@ func TestGetPutManifest(t *testing.T) {
 	defer src.Close()
 	m, mt, err := src.GetManifest(context.Background(), nil)
 	assert.NoError(t, err)
+	assert.Equal(t, list, m)
+	assert.Equal(t, "", mt)
+
+	m, mt, err = src.GetManifest(context.Background(), &md)
+	assert.NoError(t, err)
 	assert.Equal(t, man, m)
 	assert.Equal(t, "", mt)
 
-	// Non-default instances are not supported
-	md, err := manifest.Digest(man)
-	require.NoError(t, err)
-	_, _, err = src.GetManifest(context.Background(), &md)
+	// Instances which were not written are reported as errors
+	missing := digest.FromBytes([]byte("missing"))
+	_, _, err = src.GetManifest(context.Background(), &missing)
 	assert.Error(t, err)
 }
 
@@ -148,10 +157,18 @@ func TestGetPutSignatures(t *testing.T) {
 	}
 	err = dest.SupportsSignatures(context.Background())
 	assert.NoError(t, err)
-	err = dest.PutManifest(context.Background(), man)
+	instanceSignatures := [][]byte{
+		[]byte("instance-sig1"),
+	}
+	md, err := manifest.Digest(man)
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), man, &md)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), instanceSignatures, &md)
+	assert.NoError(t, err)
+	err = dest.PutManifest(context.Background(), []byte("test-manifest-list"), nil)
 	require.NoError(t, err)
-
-	err = dest.PutSignatures(context.Background(), signatures)
+	err = dest.PutSignatures(context.Background(), signatures, nil)
 	assert.NoError(t, err)
 	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
@@ -163,11 +180,9 @@ func TestGetPutSignatures(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, signatures, sigs)
 
-	// Non-default instances are not supported
-	md, err := manifest.Digest(man)
-	require.NoError(t, err)
-	_, err = src.GetSignatures(context.Background(), &md)
-	assert.Error(t, err)
+	sigs, err = src.GetSignatures(context.Background(), &md)
+	assert.NoError(t, err)
+	assert.Equal(t, instanceSignatures, sigs)
 }
 
 func TestSourceReference(t *testing.T) {
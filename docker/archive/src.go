@@ -2,6 +2,7 @@ package archive
 
 import (
 	"context"
+
 	"github.com/containers/image/docker/tarfile"
 	"github.com/containers/image/types"
 	"github.com/sirupsen/logrus"
@@ -14,20 +15,61 @@ type archiveImageSource struct {
 
 // newImageSource returns a types.ImageSource for the specified image reference.
 // The caller must call .Close() on the returned ImageSource.
-func newImageSource(ctx context.Context, ref archiveReference) (types.ImageSource, error) {
-	if ref.destinationRef != nil {
-		logrus.Warnf("docker-archive: references are not supported for sources (ignoring)")
+func newImageSource(ctx context.Context, sys *types.SystemContext, ref archiveReference) (types.ImageSource, error) {
+	if ref.archiveReader == nil && ref.destinationRef == nil && ref.sourceIndex == -1 {
+		// The common single-image case; NewSourceFromFile also handles the legacy path[index] syntax.
+		src, err := tarfile.NewSourceFromFile(ref.path)
+		if err != nil {
+			return nil, err
+		}
+		return &archiveImageSource{
+			Source: src,
+			ref:    ref,
+		}, nil
+	}
+
+	archive, closeArchive := ref.archiveReader, false
+	if archive == nil {
+		a, err := tarfile.NewReaderFromFile(ref.path)
+		if err != nil {
+			return nil, err
+		}
+		archive, closeArchive = a, true
 	}
-	src, err := tarfile.NewSourceFromFile(ref.path)
+	manifestIndex, err := chooseManifestIndex(archive, ref)
 	if err != nil {
+		if closeArchive {
+			archive.Close()
+		}
 		return nil, err
 	}
 	return &archiveImageSource{
-		Source: src,
+		Source: tarfile.NewSource(archive, closeArchive, manifestIndex),
 		ref:    ref,
 	}, nil
 }
 
+// chooseManifestIndex returns the index of the image in archive selected by ref.
+func chooseManifestIndex(archive *tarfile.Reader, ref archiveReference) (int, error) {
+	if ref.sourceIndex != -1 {
+		return ref.sourceIndex, nil
+	}
+	if ref.destinationRef == nil {
+		return 0, nil
+	}
+	items, err := archive.Manifest()
+	if err != nil {
+		return -1, err
+	}
+	i, err := archive.ManifestItemIndexForRepoTag(ref.destinationRef)
+	if err != nil && len(items) == 1 {
+		// References used to be ignored for sources; keep accepting them for single-image archives.
+		logrus.Warnf("docker-archive: %s not found in %s, using the only image in the archive", ref.destinationRef.String(), ref.path)
+		return 0, nil
+	}
+	return i, err
+}
+
 // Reference returns the reference used to set up this source, _as specified by the user_
 // (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
 func (s *archiveImageSource) Reference() types.ImageReference {
@@ -3,9 +3,11 @@ package archive
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/docker/tarfile"
 	ctrImage "github.com/containers/image/image"
 	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
@@ -41,46 +43,93 @@ func (t archiveTransport) ValidatePolicyConfigurationScope(scope string) error {
 
 // archiveReference is an ImageReference for Docker images.
 type archiveReference struct {
-	// only used for destinations
-	// archiveReference.destinationRef is optional and can be nil for destinations as well.
+	path string
+	// For destinations, the tag to use for the image; optional, can be nil.
+	// For sources, if set, selects the image with this RepoTag; if nil, and sourceIndex is not set, the first image is used.
 	destinationRef reference.NamedTagged
-	path           string
+	// If not -1, a zero-based index of the image in the archive manifest. Valid only for sources.
+	sourceIndex int
+	// If not nil, must have been created from path (but archiveReader.path may point at a temporary
+	// file, not necessarily path precisely).
+	archiveReader *tarfile.Reader
+	// If not nil, must have been created for path.
+	archiveWriter *tarfile.Writer
 }
 
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an Docker ImageReference.
 func ParseReference(refString string) (types.ImageReference, error) {
 	if refString == "" {
-		return nil, errors.Errorf("docker-archive reference %s isn't of the form <path>[:<reference>]", refString)
+		return nil, errors.Errorf("docker-archive reference %s isn't of the form <path>[:<reference>|:@<index>]", refString)
 	}
 
 	parts := strings.SplitN(refString, ":", 2)
 	path := parts[0]
 	var destinationRef reference.NamedTagged
+	sourceIndex := -1
 
-	// A :tag was specified, which is only necessary for destinations.
 	if len(parts) == 2 {
-		ref, err := reference.ParseNormalizedNamed(parts[1])
-		if err != nil {
-			return nil, errors.Wrapf(err, "docker-archive parsing reference")
+		if strings.HasPrefix(parts[1], "@") {
+			// An @index was specified, which is only valid for sources.
+			i, err := strconv.Atoi(parts[1][1:])
+			if err != nil || i < 0 {
+				return nil, errors.Errorf("Invalid source index %s", parts[1])
+			}
+			sourceIndex = i
+		} else {
+			// A :tag was specified.
+			ref, err := reference.ParseNormalizedNamed(parts[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "docker-archive parsing reference")
+			}
+			ref = reference.TagNameOnly(ref)
+
+			if _, isDigest := ref.(reference.Canonical); isDigest {
+				return nil, errors.Errorf("docker-archive doesn't support digest references: %s", refString)
+			}
+
+			refTagged, isTagged := ref.(reference.NamedTagged)
+			if !isTagged {
+				// Really shouldn't be hit...
+				return nil, errors.Errorf("internal error: reference is not tagged even after reference.TagNameOnly: %s", refString)
+			}
+			destinationRef = refTagged
 		}
-		ref = reference.TagNameOnly(ref)
+	}
 
-		if _, isDigest := ref.(reference.Canonical); isDigest {
-			return nil, errors.Errorf("docker-archive doesn't support digest references: %s", refString)
-		}
+	return newReference(path, destinationRef, sourceIndex, nil, nil), nil
+}
 
-		refTagged, isTagged := ref.(reference.NamedTagged)
-		if !isTagged {
-			// Really shouldn't be hit...
-			return nil, errors.Errorf("internal error: reference is not tagged even after reference.TagNameOnly: %s", refString)
-		}
-		destinationRef = refTagged
+// NewReference returns a docker-archive reference for a path and an optional reference.
+// As a source, the reference selects the image with that RepoTag.
+func NewReference(path string, ref reference.NamedTagged) (types.ImageReference, error) {
+	if strings.Contains(path, ":") {
+		return nil, errors.Errorf("Invalid docker-archive: reference: colon in path %q is not supported", path)
 	}
+	return newReference(path, ref, -1, nil, nil), nil
+}
 
+// NewIndexReference returns a docker-archive reference for a path and a zero-based source manifest index.
+func NewIndexReference(path string, sourceIndex int) (types.ImageReference, error) {
+	if strings.Contains(path, ":") {
+		return nil, errors.Errorf("Invalid docker-archive: reference: colon in path %q is not supported", path)
+	}
+	if sourceIndex < 0 {
+		return nil, errors.Errorf("Invalid docker-archive: reference: index @%d must not be negative", sourceIndex)
+	}
+	return newReference(path, nil, sourceIndex, nil, nil), nil
+}
+
+// newReference returns a docker archive reference for a path, an optional reference or sourceIndex,
+// and optionally a tarfile.Reader and/or a tarfile.Writer matching path.
+func newReference(path string, ref reference.NamedTagged, sourceIndex int,
+	archiveReader *tarfile.Reader, archiveWriter *tarfile.Writer) archiveReference {
 	return archiveReference{
-		destinationRef: destinationRef,
 		path:           path,
-	}, nil
+		destinationRef: ref,
+		sourceIndex:    sourceIndex,
+		archiveReader:  archiveReader,
+		archiveWriter:  archiveWriter,
+	}
 }
 
 func (ref archiveReference) Transport() types.ImageTransport {
@@ -93,10 +142,13 @@ func (ref archiveReference) Transport() types.ImageTransport {
 // e.g. default attribute values omitted by the user may be filled in in the return value, or vice versa.
 // WARNING: Do not use the return value in the UI to describe an image, it does not contain the Transport().Name() prefix.
 func (ref archiveReference) StringWithinTransport() string {
-	if ref.destinationRef == nil {
-		return ref.path
+	if ref.destinationRef != nil {
+		return fmt.Sprintf("%s:%s", ref.path, ref.destinationRef.String())
+	}
+	if ref.sourceIndex != -1 {
+		return fmt.Sprintf("%s:@%d", ref.path, ref.sourceIndex)
 	}
-	return fmt.Sprintf("%s:%s", ref.path, ref.destinationRef.String())
+	return ref.path
 }
 
 // DockerReference returns a Docker reference associated with this reference
@@ -134,7 +186,7 @@ func (ref archiveReference) PolicyConfigurationNamespaces() []string {
 // verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
 // WARNING: This may not do the right thing for a manifest list, see image.FromSource for details.
 func (ref archiveReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
-	src, err := newImageSource(ctx, ref)
+	src, err := newImageSource(ctx, sys, ref)
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +196,7 @@ func (ref archiveReference) NewImage(ctx context.Context, sys *types.SystemConte
 // NewImageSource returns a types.ImageSource for this reference.
 // The caller must call .Close() on the returned ImageSource.
 func (ref archiveReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
-	return newImageSource(ctx, ref)
+	return newImageSource(ctx, sys, ref)
 }
 
 // NewImageDestination returns a types.ImageDestination for this reference.
@@ -57,6 +57,9 @@ func testParseReference(t *testing.T, fn func(string) (types.ImageReference, err
 		{"/path:busybox:latest" + sha256digest, "", ""},                                         // Both tag and digest is rejected
 		{"/path:docker.io/library/busybox:latest", "/path", "docker.io/library/busybox:latest"}, // All implied values explicitly specified
 		{"/path:UPPERCASEISINVALID", "", ""},                                                    // Invalid input
+		{"/path:@0", "/path", ""},                                                               // Source index
+		{"/path:@-1", "", ""},                                                                   // Negative source index
+		{"/path:@notanumber", "", ""},                                                           // Invalid source index
 	} {
 		ref, err := fn(c.input)
 		if c.expectedPath == "" {
@@ -89,6 +92,7 @@ var validReferenceTestCases = []struct{ input, dockerRef, stringWithinTransport
 	{"/path:busybox:notlatest", "docker.io/library/busybox:notlatest", "/path:docker.io/library/busybox:notlatest"},          // Explicit tag
 	{"/path:docker.io/library/busybox:latest", "docker.io/library/busybox:latest", "/path:docker.io/library/busybox:latest"}, // All implied values explicitly specified
 	{"/path:example.com/ns/foo:bar", "example.com/ns/foo:bar", "/path:example.com/ns/foo:bar"},                               // All values explicitly specified
+	{"/path:@1", "", "/path:@1"}, // Source index
 }
 
 func TestReferenceTransport(t *testing.T) {
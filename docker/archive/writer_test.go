@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/internal/testing/testimage"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// configDigestOfSource returns the config digest of the image in ref.
+func configDigestOfSource(t *testing.T, ref types.ImageReference) digest.Digest {
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	parsed, err := manifest.FromBlob(m, mimeType)
+	require.NoError(t, err)
+	return parsed.ConfigInfo().Digest
+}
+
+func TestMultipleImages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-archive-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "archive.tar")
+
+	layer := bytes.Repeat([]byte{'x'}, 1000)
+	tags := []string{"example.com/first:latest", "example.com/second:v1"}
+	configDigests := []digest.Digest{}
+	writer, err := NewWriter(nil, path)
+	require.NoError(t, err)
+	for i, tag := range tags {
+		named, err := reference.ParseNormalizedNamed(tag)
+		require.NoError(t, err)
+		ref, err := writer.NewReference(named.(reference.NamedTagged))
+		require.NoError(t, err)
+		config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","config":{"Cmd":["%d"]},"rootfs":{"type":"layers","diff_ids":["%s"]}}`,
+			i, digest.FromBytes(layer)))
+		configDigests = append(configDigests, testimage.Put(t, nil, ref, manifest.DockerV2Schema2MediaType, config, layer).Config.Digest)
+	}
+	err = writer.Close()
+	require.NoError(t, err)
+
+	// The shared layer is stored only once
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	layerFiles := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if h.Name == digest.FromBytes(layer).Hex()+".tar" {
+			layerFiles++
+		}
+	}
+	assert.Equal(t, 1, layerFiles)
+
+	// Listing the images
+	reader, err := NewReader(nil, path)
+	require.NoError(t, err)
+	defer reader.Close()
+	list, err := reader.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	for i, refs := range list {
+		require.Len(t, refs, 1)
+		assert.Equal(t, tags[i], refs[0].DockerReference().String())
+		assert.Equal(t, configDigests[i], configDigestOfSource(t, refs[0]))
+	}
+
+	// Choosing images using references
+	for _, c := range []struct {
+		suffix   string
+		expected digest.Digest
+	}{
+		{"", configDigests[0]},
+		{":@0", configDigests[0]},
+		{":@1", configDigests[1]},
+		{":" + tags[0], configDigests[0]},
+		{":" + tags[1], configDigests[1]},
+	} {
+		ref, err := ParseReference(path + c.suffix)
+		require.NoError(t, err, c.suffix)
+		assert.Equal(t, c.expected, configDigestOfSource(t, ref), c.suffix)
+	}
+	for _, suffix := range []string{":@2", ":example.com/unknown:latest"} {
+		ref, err := ParseReference(path + suffix)
+		require.NoError(t, err, suffix)
+		src, err := ref.NewImageSource(context.Background(), nil)
+		if err == nil {
+			_, _, err = src.GetManifest(context.Background(), nil)
+			src.Close()
+		}
+		assert.Error(t, err, suffix)
+	}
+
+	// An index can not be used for destinations
+	ref, err := ParseReference(filepath.Join(tmpDir, "other.tar") + ":@0")
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(context.Background(), nil)
+	assert.Error(t, err)
+}
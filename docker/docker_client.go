@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/containers/image/docker/reference"
@@ -36,12 +38,16 @@ const (
 	resolvedPingV2URL       = "%s://%s/v2/"
 	resolvedPingV1URL       = "%s://%s/v1/_ping"
 	tagsPath                = "/v2/%s/tags/list"
+	catalogPath             = "/v2/_catalog"
 	manifestPath            = "/v2/%s/manifests/%s"
 	blobsPath               = "/v2/%s/blobs/%s"
 	blobUploadPath          = "/v2/%s/blobs/uploads/"
 	extensionsSignaturePath = "/extensions/v2/%s/signatures/%s"
 
 	minimumTokenLifetimeSeconds = 60
+	oauth2ClientID              = "containers/image" // The client_id used in OAuth2 token requests
+
+	defaultRetryDelay = 1 * time.Second // The default for types.SystemContext.DockerRegistryRetryDelay
 
 	extensionSignatureSchemaVersion = 2        // extensionSignature.Version
 	extensionSignatureTypeAtomic    = "atomic" // extensionSignature.Type
@@ -73,6 +79,7 @@ type extensionSignatureList struct {
 type bearerToken struct {
 	Token          string    `json:"token"`
 	AccessToken    string    `json:"access_token"`
+	RefreshToken   string    `json:"refresh_token"`
 	ExpiresIn      int       `json:"expires_in"`
 	IssuedAt       time.Time `json:"issued_at"`
 	expirationTime time.Time
@@ -84,22 +91,26 @@ type dockerClient struct {
 	sys                   *types.SystemContext
 	registry              string
 	client                *http.Client
+	tlsClientConfig       *tls.Config // The TLS configuration used by client
 	insecureSkipTLSVerify bool
 
 	// The following members are not set by newDockerClient and must be set by callers if needed.
-	username      string
-	password      string
-	signatureBase signatureStorageBase
-	scope         authScope
-	extraScope    *authScope // If non-nil, a temporary extra token scope (necessary for mounting from another repo)
+	username            string
+	password            string
+	credentialsRegistry string // The registry the credentials were loaded from by loadCredentials, or "" if they were not loaded from the auth.json file
+	signatureBase       signatureStorageBase
+	scope               authScope
+	extraScope          *authScope // If non-nil, a temporary extra token scope (necessary for mounting from another repo)
+	// identityToken, if not empty, is an OAuth2 refresh token used to obtain bearer tokens instead of username/password.
+	// It may be replaced by the token server; access it only while holding identityTokenLock.
+	identityToken     string
+	identityTokenLock sync.Mutex
 	// The following members are detected registry properties:
 	// They are set after a successful detectProperties(), and never change afterwards.
 	scheme             string // Empty value also used to indicate detectProperties() has not yet succeeded.
 	challenges         []challenge
 	supportsSignatures bool
 
-	// Private state for setupRequestAuth (key: string, value: bearerToken)
-	tokenCache sync.Map
 	// detectPropertiesError caches the initial error.
 	detectPropertiesError error
 	// detectPropertiesOnce is used to execuute detectProperties() at most once in in makeRequest().
@@ -107,8 +118,18 @@ type dockerClient struct {
 }
 
 type authScope struct {
-	remoteName string
-	actions    string
+	resourceType string // "repository" if empty
+	remoteName   string
+	actions      string
+}
+
+// String returns the representation of scope used in token requests.
+func (scope authScope) String() string {
+	resourceType := scope.resourceType
+	if resourceType == "" {
+		resourceType = "repository"
+	}
+	return fmt.Sprintf("%s:%s:%s", resourceType, scope.remoteName, scope.actions)
 }
 
 // sendAuth determines whether we need authentication for v2 or v1 endpoint.
@@ -198,10 +219,6 @@ func dockerCertDir(sys *types.SystemContext, hostPort string) (string, error) {
 // “write” specifies whether the client will be used for "write" access (in particular passed to lookaside.go:toplevelFromSection)
 func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, write bool, actions string) (*dockerClient, error) {
 	registry := reference.Domain(ref.ref)
-	username, password, err := config.GetAuthentication(sys, reference.Domain(ref.ref))
-	if err != nil {
-		return nil, errors.Wrapf(err, "error getting username and password")
-	}
 	sigBase, err := configuredSignatureStorageBase(sys, ref, write)
 	if err != nil {
 		return nil, err
@@ -211,8 +228,9 @@ func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, write
 	if err != nil {
 		return nil, err
 	}
-	client.username = username
-	client.password = password
+	if err := client.loadCredentials(registry); err != nil {
+		return nil, err
+	}
 	client.signatureBase = sigBase
 	client.scope.actions = actions
 	client.scope.remoteName = reference.Path(ref.ref)
@@ -268,10 +286,26 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 		sys:                   sys,
 		registry:              registry,
 		client:                &http.Client{Transport: tr},
+		tlsClientConfig:       tr.TLSClientConfig,
 		insecureSkipTLSVerify: skipVerify,
 	}, nil
 }
 
+// loadCredentials sets the credentials of c to those configured for registry.
+func (c *dockerClient) loadCredentials(registry string) error {
+	creds, fromAuthFile, err := config.GetCredentialsAndOrigin(c.sys, registry)
+	if err != nil {
+		return errors.Wrapf(err, "error getting username and password")
+	}
+	c.username = creds.Username
+	c.password = creds.Password
+	c.identityToken = creds.IdentityToken
+	if fromAuthFile {
+		c.credentialsRegistry = registry
+	}
+	return nil
+}
+
 // CheckAuth validates the credentials by attempting to log into the registry
 // returns an error if an error occcured while making the http request or the status code received was 401
 func CheckAuth(ctx context.Context, sys *types.SystemContext, username, password, registry string) error {
@@ -328,12 +362,6 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 	v2Res := &V2Results{}
 	v1Res := &V1Results{}
 
-	// Get credentials from authfile for the underlying hostname
-	username, password, err := config.GetAuthentication(sys, registry)
-	if err != nil {
-		return nil, errors.Wrapf(err, "error getting username and password")
-	}
-
 	// The /v2/_catalog endpoint has been disabled for docker.io therefore
 	// the call made to that endpoint will fail.  So using the v1 hostname
 	// for docker.io for simplicity of implementation and the fact that it
@@ -347,8 +375,10 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating new docker client")
 	}
-	client.username = username
-	client.password = password
+	// Get credentials from authfile for the underlying hostname
+	if err := client.loadCredentials(registry); err != nil {
+		return nil, err
+	}
 
 	// Only try the v1 search endpoint if the search query is not empty. If it is
 	// empty skip to the v2 endpoint.
@@ -407,6 +437,79 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 	return nil, errors.Wrapf(err, "couldn't search registry %q", registry)
 }
 
+// ListRepositories returns the names of all repositories in registry, as listed by the /v2/_catalog endpoint.
+// Note that some registries (notably docker.io) do not support listing repositories.
+func ListRepositories(ctx context.Context, sys *types.SystemContext, registry string) ([]string, error) {
+	c, err := newDockerClient(sys, registry, registry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating new docker client")
+	}
+	if err := c.loadCredentials(registry); err != nil {
+		return nil, err
+	}
+	c.scope = authScope{resourceType: "registry", remoteName: "catalog", actions: "*"}
+
+	repos := []string{}
+	path := catalogPath
+	for path != "" {
+		var page []string
+		page, path, err = c.listRepositoriesPage(ctx, registry, path)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+	}
+	return repos, nil
+}
+
+// listRepositoriesPage returns the repository names listed at path (a page of the /v2/_catalog listing in registry),
+// and the path of the next page, or "" if this is the last page.
+func (c *dockerClient) listRepositoriesPage(ctx context.Context, registry, path string) ([]string, string, error) {
+	res, err := c.makeRequest(ctx, "GET", path, nil, nil, v2Auth)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", errors.Wrapf(client.HandleErrorResponse(res), "Error listing repositories in %s", registry)
+	}
+
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&catalog); err != nil {
+		return nil, "", err
+	}
+	nextPath, err := nextPagePath(res)
+	if err != nil {
+		return nil, "", err
+	}
+	return catalog.Repositories, nextPath, nil
+}
+
+// nextPagePath returns the path of the next page of a paginated listing, based on the Link header of res, or "" if res is the last page.
+func nextPagePath(res *http.Response) (string, error) {
+	link := res.Header.Get("Link")
+	if link == "" {
+		return "", nil
+	}
+
+	linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
+	linkURL, err := url.Parse(linkURLStr)
+	if err != nil {
+		return "", err
+	}
+
+	// can be relative or absolute, but we only want the path (and I
+	// guess we're in trouble if it forwards to a new place...)
+	path := linkURL.Path
+	if linkURL.RawQuery != "" {
+		path += "?"
+		path += linkURL.RawQuery
+	}
+	return path, nil
+}
+
 // makeRequest creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
 // The host name and schema is taken from the client or autodetected, and the path is relative to it, i.e. the path usually starts with /v2/.
 func (c *dockerClient) makeRequest(ctx context.Context, method, path string, headers map[string][]string, stream io.Reader, auth sendAuth) (*http.Response, error) {
@@ -446,6 +549,9 @@ func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method, url
 		}
 	}
 	logrus.Debugf("%s %s", method, url)
+	if stream == nil && (method == "GET" || method == "HEAD") {
+		return c.doWithRetry(ctx, c.client, req)
+	}
 	res, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -453,6 +559,98 @@ func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method, url
 	return res, nil
 }
 
+// doWithRetry executes req, which must be idempotent and have no body, using client,
+// and retries it as configured in c.sys if it fails with a transient error.
+func (c *dockerClient) doWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	maxRetries := 0
+	delay := defaultRetryDelay
+	if c.sys != nil {
+		maxRetries = c.sys.DockerRegistryRetryAttempts
+		if c.sys.DockerRegistryRetryDelay > 0 {
+			delay = c.sys.DockerRegistryRetryDelay
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := client.Do(req)
+		if attempt >= maxRetries || ctx.Err() != nil {
+			return res, err
+		}
+		if err != nil {
+			if !isTransientError(err) {
+				return nil, err
+			}
+			logrus.Debugf("%s %s failed, retrying in %s: %v", req.Method, req.URL, delay, err)
+		} else {
+			if !isTransientStatus(res.StatusCode) {
+				return res, nil
+			}
+			if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok && retryAfter > delay {
+				delay = retryAfter
+			}
+			res.Body.Close()
+			logrus.Debugf("%s %s failed with status %d (%s), retrying in %s", req.Method, req.URL, res.StatusCode, http.StatusText(res.StatusCode), delay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isTransientStatus returns true if a response with statusCode may succeed when retried.
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTransientError returns true if err, returned by http.Client.Do, may not happen when retried.
+func isTransientError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true // The server closed the connection
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if syscallErr, ok := err.(*os.SyscallError); ok {
+		err = syscallErr.Err
+	}
+	return err == syscall.ECONNRESET || err == syscall.EPIPE
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either a number of seconds or a HTTP date relative to now,
+// and returns the requested delay, and true if the value is valid.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
 // we're using the challenges from the /v2/ ping response and not the one from the destination
 // URL in this request because:
 //
@@ -472,25 +670,20 @@ func (c *dockerClient) setupRequestAuth(req *http.Request) error {
 			req.SetBasicAuth(c.username, c.password)
 			return nil
 		case "bearer":
-			cacheKey := ""
 			scopes := []authScope{c.scope}
 			if c.extraScope != nil {
-				// Using ':' as a separator here is unambiguous because getBearerToken below uses the same separator when formatting a remote request (and because repository names can't contain colons).
-				cacheKey = fmt.Sprintf("%s:%s", c.extraScope.remoteName, c.extraScope.actions)
 				scopes = append(scopes, *c.extraScope)
 			}
-			var token bearerToken
-			t, inCache := c.tokenCache.Load(cacheKey)
-			if inCache {
-				token = t.(bearerToken)
-			}
-			if !inCache || time.Now().After(token.expirationTime) {
+			// Tokens are shared with other dockerClient instances using the same registry, credentials and scopes.
+			cacheKey := c.tokenCacheKey(scopes)
+			token, inCache := loadCachedToken(cacheKey)
+			if !inCache {
 				t, err := c.getBearerToken(req.Context(), challenge, scopes)
 				if err != nil {
 					return err
 				}
 				token = *t
-				c.tokenCache.Store(cacheKey, token)
+				storeCachedToken(cacheKey, token)
 			}
 			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
 			return nil
@@ -508,6 +701,13 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 		return nil, errors.Errorf("missing realm in bearer auth challenge")
 	}
 
+	c.identityTokenLock.Lock()
+	identityToken := c.identityToken
+	c.identityTokenLock.Unlock()
+	if identityToken != "" {
+		return c.getBearerTokenOAuth2(ctx, challenge, realm, scopes, identityToken)
+	}
+
 	authReq, err := http.NewRequest("GET", realm, nil)
 	if err != nil {
 		return nil, err
@@ -522,7 +722,7 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 	}
 	for _, scope := range scopes {
 		if scope.remoteName != "" && scope.actions != "" {
-			getParams.Add("scope", fmt.Sprintf("repository:%s:%s", scope.remoteName, scope.actions))
+			getParams.Add("scope", scope.String())
 		}
 	}
 	authReq.URL.RawQuery = getParams.Encode()
@@ -530,11 +730,9 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 		authReq.SetBasicAuth(c.username, c.password)
 	}
 	logrus.Debugf("%s %s", authReq.Method, authReq.URL.String())
-	tr := tlsclientconfig.NewTransport()
 	// TODO(runcom): insecure for now to contact the external token service
-	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	client := &http.Client{Transport: tr}
-	res, err := client.Do(authReq)
+	client := c.tokenServerClient(false)
+	res, err := c.doWithRetry(ctx, client, authReq)
 	if err != nil {
 		return nil, err
 	}
@@ -555,12 +753,97 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 	return newBearerTokenFromJSONBlob(tokenBlob)
 }
 
+// tokenServerClient returns an HTTP client for contacting a token server of the registry.
+// If verifyTLS, it uses the TLS configuration of c (so, certificates are verified unless the registry is configured
+// as insecure); otherwise TLS certificates are not verified at all.
+func (c *dockerClient) tokenServerClient(verifyTLS bool) *http.Client {
+	tr := tlsclientconfig.NewTransport()
+	if !verifyTLS {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if c.tlsClientConfig != nil {
+		tr.TLSClientConfig = c.tlsClientConfig.Clone()
+	} else {
+		tr.TLSClientConfig = serverDefault()
+	}
+	return &http.Client{Transport: tr}
+}
+
+// getBearerTokenOAuth2 obtains a bearer token from realm using the OAuth2 refresh_token grant with identityToken.
+// If the token server issues a new refresh token, it replaces c.identityToken; it is also stored in the auth.json file
+// if the original one was read from there (tokens from other files, or from c.sys.DockerAuthConfig, are not persisted).
+func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge challenge, realm string, scopes []authScope, identityToken string) (*bearerToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", identityToken)
+	form.Set("client_id", oauth2ClientID)
+	if service, ok := challenge.Parameters["service"]; ok && service != "" {
+		form.Set("service", service)
+	}
+	scopeStrings := []string{}
+	for _, scope := range scopes {
+		if scope.remoteName != "" && scope.actions != "" {
+			scopeStrings = append(scopeStrings, scope.String())
+		}
+	}
+	if len(scopeStrings) != 0 {
+		form.Set("scope", strings.Join(scopeStrings, " "))
+	}
+
+	authReq, err := http.NewRequest("POST", realm, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	authReq = authReq.WithContext(ctx)
+	authReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	logrus.Debugf("%s %s", authReq.Method, authReq.URL.String())
+	// Unlike the GET flow, never send the long-lived refresh token without verifying the token server.
+	res, err := c.tokenServerClient(true).Do(authReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized:
+		// An invalid refresh token is reported as 400 invalid_grant by OAuth2 servers.
+		return nil, ErrUnauthorizedForCredentials
+	case http.StatusOK:
+		break
+	default:
+		return nil, errors.Errorf("unexpected http code: %d (%s), URL: %s", res.StatusCode, http.StatusText(res.StatusCode), authReq.URL)
+	}
+	tokenBlob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newBearerTokenFromJSONBlob(tokenBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken != "" && token.RefreshToken != identityToken {
+		c.identityTokenLock.Lock()
+		defer c.identityTokenLock.Unlock()
+		c.identityToken = token.RefreshToken
+		if c.credentialsRegistry != "" {
+			creds := types.DockerAuthConfig{Username: c.username, IdentityToken: token.RefreshToken}
+			if err := config.SetCredentials(c.sys, c.credentialsRegistry, creds); err != nil {
+				logrus.Warnf("Error storing a new identity token for %s: %v", c.credentialsRegistry, err)
+			}
+		}
+	}
+	return token, nil
+}
+
 // detectPropertiesHelper performs the work of detectProperties which executes
 // it at most once.
 func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 	if c.scheme != "" {
 		return nil
 	}
+	if c.loadCachedPing() {
+		logrus.Debugf("Using cached ping results for %s", c.registry)
+		return nil
+	}
 
 	ping := func(scheme string) error {
 		url := fmt.Sprintf(resolvedPingV2URL, scheme, c.registry)
@@ -583,6 +866,9 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 	if err != nil && c.insecureSkipTLSVerify {
 		err = ping("http")
 	}
+	if err == nil {
+		c.storeCachedPing()
+	}
 	if err != nil {
 		err = errors.Wrap(err, "pinging docker registry returned")
 		if c.sys != nil && c.sys.DockerDisableV1Ping {
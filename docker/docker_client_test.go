@@ -1,13 +1,17 @@
 package docker
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -616,3 +620,228 @@ func assertBearerTokensEqual(t *testing.T, expected, subject *bearerToken) {
 		t.Fatalf("expected [%s] to equal [%s], it did not", subject.IssuedAt, expected.IssuedAt)
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 11, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		value    string
+		ok       bool
+		expected time.Duration
+	}{
+		{"", false, 0},
+		{"0", true, 0},
+		{"120", true, 2 * time.Minute},
+		{"-1", false, 0},
+		{"1.5", false, 0},
+		{"Thu, 01 Nov 2018 12:00:30 GMT", true, 30 * time.Second},
+		{"Thu, 01 Nov 2018 11:59:00 GMT", true, 0},
+		{"tomorrow", false, 0},
+	} {
+		delay, ok := parseRetryAfter(c.value, now)
+		assert.Equal(t, c.ok, ok, c.value)
+		assert.Equal(t, c.expected, delay, c.value)
+	}
+}
+
+func TestMakeRequestRetries(t *testing.T) {
+	for _, c := range []struct {
+		method         string
+		retryAttempts  int
+		failures       int
+		failureStatus  int
+		expectedStatus int
+		expectedCalls  int
+	}{
+		{"GET", 0, 1, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 1},
+		{"GET", 3, 2, http.StatusServiceUnavailable, http.StatusOK, 3},
+		{"HEAD", 3, 2, http.StatusTooManyRequests, http.StatusOK, 3},
+		{"GET", 2, 5, http.StatusBadGateway, http.StatusBadGateway, 3},
+		// Non-transient errors are not retried
+		{"GET", 3, 1, http.StatusNotFound, http.StatusNotFound, 1},
+		{"GET", 3, 1, http.StatusNotImplemented, http.StatusNotImplemented, 1},
+		// Non-idempotent requests are not retried
+		{"POST", 3, 1, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 1},
+	} {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls <= c.failures {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(c.failureStatus)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		client := &dockerClient{
+			sys: &types.SystemContext{
+				DockerRegistryRetryAttempts: c.retryAttempts,
+				DockerRegistryRetryDelay:    time.Millisecond,
+			},
+			client: &http.Client{},
+		}
+		res, err := client.makeRequestToResolvedURL(context.Background(), c.method, server.URL, nil, nil, -1, noAuth)
+		require.NoError(t, err, "%#v", c)
+		res.Body.Close()
+		assert.Equal(t, c.expectedStatus, res.StatusCode, "%#v", c)
+		assert.Equal(t, c.expectedCalls, calls, "%#v", c)
+		server.Close()
+	}
+
+	// Dropped connections are retried
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := &dockerClient{
+		sys:    &types.SystemContext{DockerRegistryRetryAttempts: 1, DockerRegistryRetryDelay: time.Millisecond},
+		client: &http.Client{},
+	}
+	res, err := client.makeRequestToResolvedURL(context.Background(), "GET", server.URL, nil, nil, -1, noAuth)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 2, calls)
+}
+
+func TestListRepositories(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-list-repositories")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			if r.URL.Query().Get("scope") != "registry:catalog:*" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token":"catalog-token"}`)
+		case "/v2/_catalog":
+			if r.Header.Get("Authorization") != "Bearer catalog-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Query().Get("last") {
+			case "":
+				w.Header().Set("Link", `</v2/_catalog?last=b&n=2>; rel="next"`)
+				fmt.Fprint(w, `{"repositories":["a","b"]}`)
+			case "b":
+				fmt.Fprint(w, `{"repositories":["ns/c"]}`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+		DockerCertPath:              tmpDir,
+	}
+	repos, err := ListRepositories(context.Background(), sys, strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "ns/c"}, repos)
+}
+
+func TestGetBearerTokenOAuth2(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-oauth2")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		require.NoError(t, r.ParseForm())
+		if r.Method != "POST" || r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("service") != "test" ||
+			r.PostForm.Get("scope") != "repository:ns/image:pull registry:catalog:*" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.PostForm.Get("refresh_token") {
+		case "old-refresh-token":
+			fmt.Fprint(w, `{"access_token":"access-token-1","refresh_token":"new-refresh-token","expires_in":300}`)
+		case "new-refresh-token":
+			fmt.Fprint(w, `{"access_token":"access-token-2","expires_in":300}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	sys := &types.SystemContext{AuthFilePath: filepath.Join(tmpDir, "auth.json")}
+	err = config.SetCredentials(sys, "example.com", types.DockerAuthConfig{Username: "user", IdentityToken: "old-refresh-token"})
+	require.NoError(t, err)
+	client := &dockerClient{sys: sys}
+	err = client.loadCredentials("example.com")
+	require.NoError(t, err)
+	ch := challenge{Scheme: "bearer", Parameters: map[string]string{"realm": server.URL, "service": "test"}}
+	scopes := []authScope{{remoteName: "ns/image", actions: "pull"}, {resourceType: "registry", remoteName: "catalog", actions: "*"}}
+
+	// The first request rotates the refresh token, which is stored
+	token, err := client.getBearerToken(context.Background(), ch, scopes)
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-1", token.Token)
+	assert.True(t, token.expirationTime.After(time.Now().Add(4*time.Minute)))
+	creds, err := config.GetCredentials(sys, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", IdentityToken: "new-refresh-token"}, creds)
+
+	// Later requests use the new refresh token
+	token, err = client.getBearerToken(context.Background(), ch, scopes)
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-2", token.Token)
+	assert.Equal(t, 2, tokenRequests)
+
+	// An invalid refresh token is reported as invalid credentials
+	client = &dockerClient{sys: &types.SystemContext{DockerAuthConfig: &types.DockerAuthConfig{IdentityToken: "invalid"}}}
+	err = client.loadCredentials("example.com")
+	require.NoError(t, err)
+	_, err = client.getBearerToken(context.Background(), ch, scopes)
+	assert.Equal(t, ErrUnauthorizedForCredentials, err)
+
+	// Refresh tokens read from other files are not written to auth.json
+	homeDir := filepath.Join(tmpDir, "home")
+	err = os.MkdirAll(filepath.Join(homeDir, ".docker"), 0700)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(homeDir, ".docker", "config.json"), []byte(`{"auths":{"example.org":{"auth":"dXNlcjo=","identitytoken":"old-refresh-token"}}}`), 0600)
+	require.NoError(t, err)
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	os.Setenv("HOME", homeDir)
+	client = &dockerClient{sys: sys}
+	err = client.loadCredentials("example.org")
+	require.NoError(t, err)
+	token, err = client.getBearerToken(context.Background(), ch, scopes)
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-1", token.Token)
+	_, fromAuthFile, err := config.GetCredentialsAndOrigin(sys, "example.org")
+	require.NoError(t, err)
+	assert.False(t, fromAuthFile)
+
+	// The token server's TLS certificate is verified
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+	requestsBefore := tokenRequests
+	client = &dockerClient{sys: &types.SystemContext{DockerAuthConfig: &types.DockerAuthConfig{IdentityToken: "old-refresh-token"}}}
+	err = client.loadCredentials("example.com")
+	require.NoError(t, err)
+	tlsCh := challenge{Scheme: "bearer", Parameters: map[string]string{"realm": tlsServer.URL, "service": "test"}}
+	_, err = client.getBearerToken(context.Background(), tlsCh, scopes)
+	assert.Error(t, err)
+	assert.Equal(t, requestsBefore, tokenRequests)
+}
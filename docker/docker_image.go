@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strings"
 
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/image"
+	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
+	"github.com/docker/distribution/registry/client"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 // Image is a Docker-specific implementation of types.ImageCloser with a few extra methods
@@ -25,7 +26,7 @@ type Image struct {
 // a client to the registry hosting the given image.
 // The caller must call .Close() on the returned Image.
 func newImage(ctx context.Context, sys *types.SystemContext, ref dockerReference) (types.ImageCloser, error) {
-	s, err := newImageSource(sys, ref)
+	s, err := newImageSource(ctx, sys, ref)
 	if err != nil {
 		return nil, err
 	}
@@ -38,7 +39,7 @@ func newImage(ctx context.Context, sys *types.SystemContext, ref dockerReference
 
 // SourceRefFullName returns a fully expanded name for the repository this image is in.
 func (i *Image) SourceRefFullName() string {
-	return i.src.ref.ref.Name()
+	return i.src.physicalRef.ref.Name()
 }
 
 // GetRepositoryTags list all tags available in the repository. The tag
@@ -46,7 +47,7 @@ func (i *Image) SourceRefFullName() string {
 // backward-compatible shim method which calls the module-level
 // GetRepositoryTags)
 func (i *Image) GetRepositoryTags(ctx context.Context) ([]string, error) {
-	return GetRepositoryTags(ctx, i.src.c.sys, i.src.ref)
+	return GetRepositoryTags(ctx, i.src.c.sys, i.src.physicalRef)
 }
 
 // GetRepositoryTags list all tags available in the repository. The tag
@@ -84,24 +85,81 @@ func GetRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.
 		}
 		tags = append(tags, tagsHolder.Tags...)
 
-		link := res.Header.Get("Link")
-		if link == "" {
-			break
-		}
-
-		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
-		linkURL, err := url.Parse(linkURLStr)
+		path, err = nextPagePath(res)
 		if err != nil {
 			return tags, err
 		}
-
-		// can be relative or absolute, but we only want the path (and I
-		// guess we're in trouble if it forwards to a new place...)
-		path = linkURL.Path
-		if linkURL.RawQuery != "" {
-			path += "?"
-			path += linkURL.RawQuery
+		if path == "" {
+			break
 		}
 	}
 	return tags, nil
 }
+
+// ErrTagDeletionNotSupported is returned by DeleteTag if the registry does not support deleting a tag without deleting the manifest it refers to.
+var ErrTagDeletionNotSupported = errors.New("registry does not support deleting tags")
+
+// DeleteTag removes the tag of ref, which must be a tagged docker reference, from the registry,
+// without deleting the manifest it refers to (and any other tags referring to the same manifest).
+// Note that many registries (including docker/distribution) do not support this; ErrTagDeletionNotSupported is returned in that case.
+func DeleteTag(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	tagged, ok := dr.ref.(reference.NamedTagged)
+	if !ok {
+		return errors.Errorf("%s does not contain a tag", dr.StringWithinTransport())
+	}
+
+	// See deleteImage for the choice of actions.
+	c, err := newDockerClientFromRef(sys, dr, true, "*")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf(manifestPath, reference.Path(dr.ref), tagged.Tag())
+	res, err := c.makeRequest(ctx, "DELETE", path, nil, nil, v2Auth)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		// docker/distribution rejects references which are not digests with 400 (UNSUPPORTED or DIGEST_INVALID);
+		// other registries return 405 if deletion by tag is not implemented.
+		return errors.Wrapf(ErrTagDeletionNotSupported, "Error deleting %s: %s", dr.StringWithinTransport(), res.Status)
+	default:
+		return errors.Wrapf(client.HandleErrorResponse(res), "Error deleting %s", dr.StringWithinTransport())
+	}
+}
+
+// DeleteResult is the result of deleting one reference in DeleteImages.
+type DeleteResult struct {
+	Reference types.ImageReference
+	Err       error // nil if the deletion succeeded
+}
+
+// DeleteImages deletes each of refs, using DeleteTag if tagOnly, or types.ImageReference.DeleteImage otherwise,
+// and returns the results in the same order as refs.
+// A failure to delete one reference does not prevent deleting the rest; if ctx is canceled, the remaining deletions fail with ctx.Err().
+func DeleteImages(ctx context.Context, sys *types.SystemContext, refs []types.ImageReference, tagOnly bool) []DeleteResult {
+	results := make([]DeleteResult, len(refs))
+	for i, ref := range refs {
+		results[i].Reference = ref
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		if tagOnly {
+			results[i].Err = DeleteTag(ctx, sys, ref)
+		} else {
+			results[i].Err = ref.DeleteImage(ctx, sys)
+		}
+		if results[i].Err != nil {
+			logrus.Debugf("Error deleting %s: %v", transports.ImageName(ref), results[i].Err)
+		}
+	}
+	return results
+}
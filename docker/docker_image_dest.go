@@ -12,10 +12,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/sysregistriesv2"
 	"github.com/containers/image/types"
 	"github.com/docker/distribution/registry/api/errcode"
 	"github.com/docker/distribution/registry/api/v2"
@@ -26,9 +28,17 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// maxChunkUploadAttempts is the number of times uploading a single chunk of a blob is attempted, see types.SystemContext.DockerRegistryPushChunkSize.
+const maxChunkUploadAttempts = 5
+
+// chunkUploadRetryDelay is the delay before the first attempt to resume uploading a chunk; it increases with every attempt.
+// It is a variable only to allow tests to avoid waiting.
+var chunkUploadRetryDelay = 1 * time.Second
+
 type dockerImageDestination struct {
-	ref dockerReference
-	c   *dockerClient
+	ref       dockerReference
+	c         *dockerClient
+	chunkSize int64 // If > 0, upload blobs in chunks of this size, see types.SystemContext.DockerRegistryPushChunkSize
 	// State
 	manifestDigest digest.Digest // or "" if not yet known.
 }
@@ -39,10 +49,14 @@ func newImageDestination(sys *types.SystemContext, ref dockerReference) (types.I
 	if err != nil {
 		return nil, err
 	}
-	return &dockerImageDestination{
+	d := &dockerImageDestination{
 		ref: ref,
 		c:   c,
-	}, nil
+	}
+	if sys != nil {
+		d.chunkSize = sys.DockerRegistryPushChunkSize
+	}
+	return d, nil
 }
 
 // Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
@@ -62,6 +76,7 @@ func (d *dockerImageDestination) SupportedManifestMIMETypes() []string {
 		manifest.DockerV2Schema2MediaType,
 		manifest.DockerV2Schema1SignedMediaType,
 		manifest.DockerV2Schema1MediaType,
+		manifest.DockerV2ListMediaType,
 	}
 }
 
@@ -137,7 +152,7 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 		}
 	}
 
-	// FIXME? Chunked upload, progress reporting, etc.
+	// FIXME? Progress reporting, etc.
 	uploadPath := fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref))
 	logrus.Debugf("Uploading %s", uploadPath)
 	res, err := d.c.makeRequest(ctx, "POST", uploadPath, nil, nil, v2Auth)
@@ -153,25 +168,41 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 	if err != nil {
 		return types.BlobInfo{}, errors.Wrap(err, "Error determining upload URL")
 	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			d.cancelUpload(uploadLocation)
+		}
+	}()
 
 	digester := digest.Canonical.Digester()
 	sizeCounter := &sizeCounter{}
 	tee := io.TeeReader(stream, io.MultiWriter(digester.Hash(), sizeCounter))
-	res, err = d.c.makeRequestToResolvedURL(ctx, "PATCH", uploadLocation.String(), map[string][]string{"Content-Type": {"application/octet-stream"}}, tee, inputInfo.Size, v2Auth)
-	if err != nil {
-		logrus.Debugf("Error uploading layer chunked, response %#v", res)
-		return types.BlobInfo{}, err
+	if d.chunkSize > 0 {
+		location, err := d.uploadChunks(ctx, uploadLocation, tee)
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+		uploadLocation = location
+	} else {
+		res, err = d.c.makeRequestToResolvedURL(ctx, "PATCH", uploadLocation.String(), map[string][]string{"Content-Type": {"application/octet-stream"}}, tee, inputInfo.Size, v2Auth)
+		if err != nil {
+			logrus.Debugf("Error uploading layer, response %#v", res)
+			return types.BlobInfo{}, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusAccepted {
+			logrus.Debugf("Error uploading layer, response %#v", *res)
+			return types.BlobInfo{}, errors.Wrapf(client.HandleErrorResponse(res), "Error uploading layer to %s", uploadLocation)
+		}
+		location, err := res.Location()
+		if err != nil {
+			return types.BlobInfo{}, errors.Wrap(err, "Error determining upload URL")
+		}
+		uploadLocation = location
 	}
-	defer res.Body.Close()
 	computedDigest := digester.Digest()
 
-	uploadLocation, err = res.Location()
-	if err != nil {
-		return types.BlobInfo{}, errors.Wrap(err, "Error determining upload URL")
-	}
-
-	// FIXME: DELETE uploadLocation on failure (does not really work in docker/distribution servers, which incorrectly require the "delete" action in the token's scope)
-
 	locationQuery := uploadLocation.Query()
 	// TODO: check inputInfo.Digest == computedDigest https://github.com/containers/image/pull/70#discussion_r77646717
 	locationQuery.Set("digest", computedDigest.String())
@@ -187,6 +218,7 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 	}
 
 	logrus.Debugf("Upload of layer %s complete", computedDigest)
+	succeeded = true
 	cache.RecordKnownLocation(d.ref.Transport(), bicTransportScope(d.ref), computedDigest, newBICLocationReference(d.ref))
 	return types.BlobInfo{Digest: computedDigest, Size: sizeCounter.size}, nil
 }
@@ -263,6 +295,151 @@ func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo referenc
 	}
 }
 
+// uploadChunks uploads the contents of stream to the upload session at uploadLocation in chunks of d.chunkSize,
+// and returns the location to use for completing the upload.
+func (d *dockerImageDestination) uploadChunks(ctx context.Context, uploadLocation *url.URL, stream io.Reader) (*url.URL, error) {
+	chunk := make([]byte, d.chunkSize)
+	offset := int64(0)
+	for {
+		n, err := io.ReadFull(stream, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		uploadLocation, err = d.uploadChunk(ctx, uploadLocation, chunk[:n], offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(n)
+		if int64(n) < d.chunkSize {
+			break
+		}
+	}
+	return uploadLocation, nil
+}
+
+// uploadChunk uploads chunk, starting at offset in the blob, to the upload session at uploadLocation,
+// resuming from the position reported by the registry if a request fails.
+// It returns the location to use for the next request.
+func (d *dockerImageDestination) uploadChunk(ctx context.Context, uploadLocation *url.URL, chunk []byte, offset int64) (*url.URL, error) {
+	sent := int64(0)         // The part of chunk already accepted by the registry
+	ambiguousStatus := false // Set if the registry has already reported an ambiguous upload status
+	for attempt := 1; ; attempt++ {
+		location, err := d.patchChunk(ctx, uploadLocation, chunk[sent:], offset+sent)
+		if err == nil {
+			return location, nil
+		}
+		if attempt >= maxChunkUploadAttempts {
+			return nil, err
+		}
+		logrus.Debugf("Error uploading %d bytes at offset %d (attempt %d): %v", len(chunk)-int(sent), offset+sent, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * chunkUploadRetryDelay):
+		}
+		location, uploaded, statusErr := d.uploadStatus(ctx, uploadLocation)
+		if statusErr != nil {
+			logrus.Debugf("Error determining upload status: %v", statusErr)
+			return nil, err
+		}
+		if uploaded == -1 {
+			// The registry has stored either 0 or 1 bytes.  Resume from the last position acknowledged by the registry;
+			// if the registry was already ambiguous before, resuming from there may have been rejected, so try the other possibility.
+			if offset+sent > 1 {
+				return nil, errors.Wrapf(err, "Error uploading blob, and the upload can not be resumed: registry has at most 1 byte, expected %d to %d", offset, offset+int64(len(chunk)))
+			}
+			uploaded = offset + sent
+			if ambiguousStatus && offset == 0 {
+				uploaded = 1 - sent
+			}
+			ambiguousStatus = true
+		}
+		if uploaded < offset || uploaded > offset+int64(len(chunk)) {
+			return nil, errors.Wrapf(err, "Error uploading blob, and the upload can not be resumed: registry has %d bytes, expected %d to %d", uploaded, offset, offset+int64(len(chunk)))
+		}
+		logrus.Debugf("Resuming upload at offset %d", uploaded)
+		uploadLocation = location
+		sent = uploaded - offset
+		if sent == int64(len(chunk)) {
+			return uploadLocation, nil
+		}
+	}
+}
+
+// patchChunk sends data, starting at offset in the blob, to the upload session at uploadLocation,
+// and returns the location to use for the next request.
+func (d *dockerImageDestination) patchChunk(ctx context.Context, uploadLocation *url.URL, data []byte, offset int64) (*url.URL, error) {
+	headers := map[string][]string{
+		"Content-Type":  {"application/octet-stream"},
+		"Content-Range": {fmt.Sprintf("%d-%d", offset, offset+int64(len(data))-1)},
+	}
+	res, err := d.c.makeRequestToResolvedURL(ctx, "PATCH", uploadLocation.String(), headers, bytes.NewReader(data), int64(len(data)), v2Auth)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		logrus.Debugf("Error uploading layer chunk, response %#v", *res)
+		return nil, errors.Wrapf(client.HandleErrorResponse(res), "Error uploading layer chunk to %s", uploadLocation)
+	}
+	location, err := res.Location()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error determining upload URL")
+	}
+	return location, nil
+}
+
+// uploadStatus returns the location to use for the next request and the number of bytes
+// stored by the registry for the upload session at uploadLocation, or -1 if the registry has stored either 0 or 1 bytes;
+// docker/distribution reports "0-0" both for an empty upload and for an upload containing a single byte.
+func (d *dockerImageDestination) uploadStatus(ctx context.Context, uploadLocation *url.URL) (*url.URL, int64, error) {
+	res, err := d.c.makeRequestToResolvedURL(ctx, "GET", uploadLocation.String(), nil, nil, -1, v2Auth)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return nil, -1, errors.Wrapf(client.HandleErrorResponse(res), "Error reading upload status from %s", uploadLocation)
+	}
+	location, err := res.Location()
+	if err == http.ErrNoLocation {
+		location = uploadLocation
+	} else if err != nil {
+		return nil, -1, errors.Wrap(err, "Error determining upload URL")
+	}
+	// The registry reports the inclusive range of stored bytes, "0-(size - 1)", except that "0-0" is also used for an empty upload.
+	var start, end int64
+	if _, err := fmt.Sscanf(res.Header.Get("Range"), "%d-%d", &start, &end); err != nil || start != 0 {
+		return nil, -1, errors.Errorf("Invalid upload status Range %#v", res.Header.Get("Range"))
+	}
+	if end == 0 {
+		return location, -1, nil
+	}
+	return location, end + 1, nil
+}
+
+// cancelUpload tries to remove the upload session at uploadLocation, ignoring any failures.
+func (d *dockerImageDestination) cancelUpload(uploadLocation *url.URL) {
+	// This does not really work in docker/distribution servers, which incorrectly require the "delete" action in the token's scope;
+	// still, try, so that other registries can release the resources immediately.
+	// Use a new context, to clean up even if the upload failed because ctx was cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := d.c.makeRequestToResolvedURL(ctx, "DELETE", uploadLocation.String(), nil, nil, -1, v2Auth)
+	if err != nil {
+		logrus.Debugf("Error canceling upload %s: %v", uploadLocation, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		logrus.Debugf("Error canceling upload %s: status %d", uploadLocation, res.StatusCode)
+	}
+}
+
 // TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
 // (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
 // info.Digest must not be empty.
@@ -295,7 +472,17 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 	defer func() {
 		d.c.extraScope = nil
 	}()
-	for _, candidate := range cache.CandidateLocations(d.ref.Transport(), bicTransportScope(d.ref), info.Digest, canSubstitute) {
+	// Blobs in registries sharing the storage backend with the destination can be mounted using the same repository path.
+	mountableDomains := map[string]bool{reference.Domain(d.ref.ref): true}
+	candidates := cache.CandidateLocations(d.ref.Transport(), bicTransportScope(d.ref), info.Digest, canSubstitute)
+	for _, host := range d.mountEquivalentRegistries() {
+		if mountableDomains[host] {
+			continue
+		}
+		mountableDomains[host] = true
+		candidates = append(candidates, cache.CandidateLocations(d.ref.Transport(), types.BICTransportScope{Opaque: host}, info.Digest, canSubstitute)...)
+	}
+	for _, candidate := range candidates {
 		candidateRepo, err := parseBICLocationReference(candidate.Location)
 		if err != nil {
 			logrus.Debugf("Error parsing BlobInfoCache location reference: %s", err)
@@ -304,11 +491,11 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 		logrus.Debugf("Trying to reuse cached location %s in %s", candidate.Digest.String(), candidateRepo.Name())
 
 		// Sanity checks:
-		if reference.Domain(candidateRepo) != reference.Domain(d.ref.ref) {
+		if !mountableDomains[reference.Domain(candidateRepo)] {
 			logrus.Debugf("... Internal error: domain %s does not match destination %s", reference.Domain(candidateRepo), reference.Domain(d.ref.ref))
 			continue
 		}
-		if candidateRepo.Name() == d.ref.ref.Name() && candidate.Digest == info.Digest {
+		if reference.Path(candidateRepo) == reference.Path(d.ref.ref) && candidate.Digest == info.Digest {
 			logrus.Debug("... Already tried the primary destination")
 			continue
 		}
@@ -334,7 +521,7 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 			// FIXME? Should we drop the blob from cache here (and elsewhere?)?
 			continue // logrus.Debug() already happened in blobExists
 		}
-		if candidateRepo.Name() != d.ref.ref.Name() {
+		if reference.Path(candidateRepo) != reference.Path(d.ref.ref) {
 			if err := d.mountBlob(ctx, candidateRepo, candidate.Digest); err != nil {
 				logrus.Debugf("... Mount failed: %v", err)
 				continue
@@ -347,20 +534,43 @@ func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.
 	return false, types.BlobInfo{}, nil
 }
 
+// mountEquivalentRegistries returns the hosts of registries which are configured to share the storage backend with the destination.
+func (d *dockerImageDestination) mountEquivalentRegistries() []string {
+	registry, err := sysregistriesv2.FindRegistry(d.c.sys, d.ref.ref.Name())
+	if err != nil {
+		logrus.Debugf("Error loading registries configuration: %v", err)
+		return nil
+	}
+	if registry == nil {
+		return nil
+	}
+	return registry.MountEquivalent
+}
+
 // PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
 // FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
 // If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 // but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
-func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte) error {
-	digest, err := manifest.Digest(m)
-	if err != nil {
-		return err
-	}
-	d.manifestDigest = digest
+func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	var refTail string
+	if instanceDigest != nil {
+		// If the instanceDigest is provided, then use it as the refTail, because the reference,
+		// whether it includes a tag or a digest, refers to the list as a whole, and not this
+		// particular instance.
+		refTail = instanceDigest.String()
+	} else {
+		digest, err := manifest.Digest(m)
+		if err != nil {
+			return err
+		}
+		d.manifestDigest = digest
 
-	refTail, err := d.ref.tagOrDigest()
-	if err != nil {
-		return err
+		refTail, err = d.ref.tagOrDigest()
+		if err != nil {
+			return err
+		}
 	}
 	path := fmt.Sprintf(manifestPath, reference.Path(d.ref.ref), refTail)
 
@@ -406,27 +616,37 @@ func isManifestInvalidError(err error) bool {
 	return ec.ErrorCode() == v2.ErrorCodeManifestInvalid || ec.ErrorCode() == v2.ErrorCodeTagInvalid
 }
 
-func (d *dockerImageDestination) PutSignatures(ctx context.Context, signatures [][]byte) error {
+// PutSignatures writes a set of signatures to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write or overwrite the signatures for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+func (d *dockerImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
 	// Do not fail if we don’t really need to support signatures.
 	if len(signatures) == 0 {
 		return nil
 	}
+	if instanceDigest == nil {
+		if d.manifestDigest.String() == "" {
+			// This shouldn’t happen, ImageDestination users are required to call PutManifest before PutSignatures
+			return errors.Errorf("Unknown manifest digest, can't add signatures")
+		}
+		instanceDigest = &d.manifestDigest
+	}
 	if err := d.c.detectProperties(ctx); err != nil {
 		return err
 	}
 	switch {
 	case d.c.signatureBase != nil:
-		return d.putSignaturesToLookaside(signatures)
+		return d.putSignaturesToLookaside(signatures, *instanceDigest)
 	case d.c.supportsSignatures:
-		return d.putSignaturesToAPIExtension(ctx, signatures)
+		return d.putSignaturesToAPIExtension(ctx, signatures, *instanceDigest)
 	default:
 		return errors.Errorf("X-Registry-Supports-Signatures extension not supported, and lookaside is not configured")
 	}
 }
 
 // putSignaturesToLookaside implements PutSignatures() from the lookaside location configured in s.c.signatureBase,
-// which is not nil.
-func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte) error {
+// which is not nil, for a manifest with manifestDigest.
+func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte, manifestDigest digest.Digest) error {
 	// FIXME? This overwrites files one at a time, definitely not atomic.
 	// A failure when updating signatures with a reordered copy could lose some of them.
 
@@ -435,14 +655,9 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte) e
 		return nil
 	}
 
-	if d.manifestDigest.String() == "" {
-		// This shouldn’t happen, ImageDestination users are required to call PutManifest before PutSignatures
-		return errors.Errorf("Unknown manifest digest, can't add signatures")
-	}
-
 	// NOTE: Keep this in sync with docs/signature-protocols.md!
 	for i, signature := range signatures {
-		url := signatureStorageURL(d.c.signatureBase, d.manifestDigest, i)
+		url := signatureStorageURL(d.c.signatureBase, manifestDigest, i)
 		if url == nil {
 			return errors.Errorf("Internal error: signatureStorageURL with non-nil base returned nil")
 		}
@@ -457,7 +672,7 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte) e
 	// is enough for dockerImageSource to stop looking for other signatures, so that
 	// is sufficient.
 	for i := len(signatures); ; i++ {
-		url := signatureStorageURL(d.c.signatureBase, d.manifestDigest, i)
+		url := signatureStorageURL(d.c.signatureBase, manifestDigest, i)
 		if url == nil {
 			return errors.Errorf("Internal error: signatureStorageURL with non-nil base returned nil")
 		}
@@ -516,23 +731,19 @@ func (c *dockerClient) deleteOneSignature(url *url.URL) (missing bool, err error
 	}
 }
 
-// putSignaturesToAPIExtension implements PutSignatures() using the X-Registry-Supports-Signatures API extension.
-func (d *dockerImageDestination) putSignaturesToAPIExtension(ctx context.Context, signatures [][]byte) error {
+// putSignaturesToAPIExtension implements PutSignatures() using the X-Registry-Supports-Signatures API extension,
+// for a manifest with manifestDigest.
+func (d *dockerImageDestination) putSignaturesToAPIExtension(ctx context.Context, signatures [][]byte, manifestDigest digest.Digest) error {
 	// Skip dealing with the manifest digest, or reading the old state, if not necessary.
 	if len(signatures) == 0 {
 		return nil
 	}
 
-	if d.manifestDigest.String() == "" {
-		// This shouldn’t happen, ImageDestination users are required to call PutManifest before PutSignatures
-		return errors.Errorf("Unknown manifest digest, can't add signatures")
-	}
-
 	// Because image signatures are a shared resource in Atomic Registry, the default upload
 	// always adds signatures.  Eventually we should also allow removing signatures,
 	// but the X-Registry-Supports-Signatures API extension does not support that yet.
 
-	existingSignatures, err := d.c.getExtensionsSignatures(ctx, d.ref, d.manifestDigest)
+	existingSignatures, err := d.c.getExtensionsSignatures(ctx, d.ref, manifestDigest)
 	if err != nil {
 		return err
 	}
@@ -557,7 +768,7 @@ sigExists:
 			if err != nil || n != 16 {
 				return errors.Wrapf(err, "Error generating random signature len %d", n)
 			}
-			signatureName = fmt.Sprintf("%s@%032x", d.manifestDigest.String(), randBytes)
+			signatureName = fmt.Sprintf("%s@%032x", manifestDigest.String(), randBytes)
 			if _, ok := existingSigNames[signatureName]; !ok {
 				break
 			}
@@ -573,7 +784,7 @@ sigExists:
 			return err
 		}
 
-		path := fmt.Sprintf(extensionsSignaturePath, reference.Path(d.ref.ref), d.manifestDigest.String())
+		path := fmt.Sprintf(extensionsSignaturePath, reference.Path(d.ref.ref), manifestDigest.String())
 		res, err := d.c.makeRequest(ctx, "PUT", path, nil, bytes.NewReader(body), v2Auth)
 		if err != nil {
 			return err
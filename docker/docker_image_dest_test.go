@@ -0,0 +1,256 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/sysregistriesv2"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadTestRegistry is a minimal registry implementation supporting blob uploads, which can simulate failures.
+type uploadTestRegistry struct {
+	mutex          sync.Mutex
+	data           []byte   // Contents of the only upload session
+	contentRanges  []string // Content-Range values of received PATCH requests
+	failPatches    int      // If > 0, the number of PATCH requests which fail after storing half of the data
+	failPut        bool     // If true, completing the upload fails
+	deleted        bool     // Set if the upload session has been canceled
+	completedBlobs map[digest.Digest][]byte
+}
+
+func (r *uploadTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.Method == "HEAD" && strings.HasPrefix(req.URL.Path, "/v2/ns/image/blobs/"):
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == "POST" && req.URL.Path == "/v2/ns/image/blobs/uploads/":
+		w.Header().Set("Location", "/upload/1")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == "PATCH" && req.URL.Path == "/upload/1":
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if contentRange := req.Header.Get("Content-Range"); contentRange != "" {
+			r.contentRanges = append(r.contentRanges, contentRange)
+			var start, end int
+			if _, err := fmt.Sscanf(contentRange, "%d-%d", &start, &end); err != nil || start != len(r.data) || end != start+len(body)-1 {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		if r.failPatches > 0 {
+			r.failPatches--
+			r.data = append(r.data, body[:len(body)/2]...)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.data = append(r.data, body...)
+		w.Header().Set("Location", "/upload/1")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == "GET" && req.URL.Path == "/upload/1":
+		end := len(r.data) - 1
+		if end < 0 {
+			end = 0
+		}
+		w.Header().Set("Location", "/upload/1")
+		w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == "PUT" && req.URL.Path == "/upload/1":
+		d := digest.Digest(req.URL.Query().Get("digest"))
+		if r.failPut || d != digest.FromBytes(r.data) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.completedBlobs[d] = r.data
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == "DELETE" && req.URL.Path == "/upload/1":
+		r.deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPutBlobChunked(t *testing.T) {
+	defer func(delay time.Duration) { chunkUploadRetryDelay = delay }(chunkUploadRetryDelay)
+	chunkUploadRetryDelay = 0
+
+	tmpDir, err := ioutil.TempDir("", "docker-put-blob")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	blob := bytes.Repeat([]byte("0123456789"), 10)
+	blob = append(blob, 'x')
+	blobDigest := digest.FromBytes(blob)
+
+	for _, c := range []struct {
+		chunkSize           int64
+		failPatches         int
+		failPut             bool
+		expectedRanges      []string
+		expectedDeleteAfter bool
+	}{
+		// A single request, without Content-Range
+		{0, 0, false, nil, false},
+		// Chunks, the last one shorter
+		{50, 0, false, []string{"0-49", "50-99", "100-100"}, false},
+		// Resuming failed chunks
+		{50, 2, false, []string{"0-49", "25-49", "37-49", "50-99", "100-100"}, false},
+		// Too many failures
+		{50, maxChunkUploadAttempts, false, []string{"0-49", "25-49", "37-49", "43-49", "46-49"}, true},
+		// Failures are not resumed without chunks, and the upload session is canceled
+		{0, 1, false, nil, true},
+		// Failure to complete the upload cancels the upload session
+		{50, 0, true, []string{"0-49", "50-99", "100-100"}, true},
+	} {
+		registry := &uploadTestRegistry{failPatches: c.failPatches, failPut: c.failPut, completedBlobs: map[digest.Digest][]byte{}}
+		server := httptest.NewServer(registry)
+		serverLocation := strings.TrimPrefix(server.URL, "http://")
+		ref, err := ParseReference("//" + serverLocation + "/ns/image:tag")
+		require.NoError(t, err)
+		sys := &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+			RegistriesDirPath:           tmpDir,
+			DockerCertPath:              tmpDir,
+			SystemRegistriesConfPath:    filepath.Join(tmpDir, "registries.conf"),
+			DockerRegistryPushChunkSize: c.chunkSize,
+		}
+		dest, err := ref.NewImageDestination(context.Background(), sys)
+		require.NoError(t, err)
+
+		info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Size: -1}, blobinfocache.NewMemoryCache(), false)
+		failed := c.failPut || c.failPatches >= maxChunkUploadAttempts || (c.chunkSize == 0 && c.failPatches > 0)
+		if failed {
+			assert.Error(t, err, "%#v", c)
+		} else {
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, info, "%#v", c)
+			assert.Equal(t, blob, registry.completedBlobs[blobDigest], "%#v", c)
+		}
+		assert.Equal(t, c.expectedRanges, registry.contentRanges, "%#v", c)
+		assert.Equal(t, c.expectedDeleteAfter, registry.deleted, "%#v", c)
+
+		dest.Close()
+		server.Close()
+	}
+}
+
+func TestPutBlobChunkedAmbiguousStatus(t *testing.T) {
+	defer func(delay time.Duration) { chunkUploadRetryDelay = delay }(chunkUploadRetryDelay)
+	chunkUploadRetryDelay = 0
+
+	tmpDir, err := ioutil.TempDir("", "docker-put-blob")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	blob := []byte("abc")
+	blobDigest := digest.FromBytes(blob)
+
+	// The first PATCH stores a single byte, which the registry reports as "0-0", the same as an empty upload.
+	registry := &uploadTestRegistry{failPatches: 1, completedBlobs: map[digest.Digest][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(server.URL, "http://") + "/ns/image:tag")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+		RegistriesDirPath:           tmpDir,
+		DockerCertPath:              tmpDir,
+		SystemRegistriesConfPath:    filepath.Join(tmpDir, "registries.conf"),
+		DockerRegistryPushChunkSize: 2,
+	})
+	require.NoError(t, err)
+	defer dest.Close()
+
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Size: -1}, blobinfocache.NewMemoryCache(), false)
+	require.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, info)
+	assert.Equal(t, blob, registry.completedBlobs[blobDigest])
+	// Resuming from the last acknowledged offset is rejected, so the upload continues after the single stored byte.
+	assert.Equal(t, []string{"0-1", "0-1", "1-1", "2-2"}, registry.contentRanges)
+	assert.False(t, registry.deleted)
+}
+
+func TestTryReusingBlobMountEquivalent(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-mount-equivalent")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	blobDigest := digest.FromString("blob")
+
+	mounts := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "HEAD" && r.URL.Path == "/v2/ns/source/blobs/"+blobDigest.String():
+			w.Header().Set("Content-Length", "4")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "POST" && r.URL.Path == "/v2/ns/image/blobs/uploads/" && r.URL.Query().Get("mount") == blobDigest.String():
+			mounts = append(mounts, r.URL.Query().Get("from"))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverLocation := strings.TrimPrefix(server.URL, "http://")
+	ref, err := ParseReference("//" + serverLocation + "/ns/image:tag")
+	require.NoError(t, err)
+	sourceRef, err := ParseReference("//other.example.com/ns/source:tag")
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		config         string
+		expectedMounts []string
+	}{
+		// Locations in other registries are ignored by default
+		{"", []string{}},
+		{fmt.Sprintf("[[registry]]\nurl = %q\nmount-equivalent = [\"other.example.com\"]\n", serverLocation), []string{"ns/source"}},
+	} {
+		mounts = []string{}
+		confPath := filepath.Join(tmpDir, "registries.conf")
+		err := ioutil.WriteFile(confPath, []byte(c.config), 0600)
+		require.NoError(t, err)
+		sysregistriesv2.InvalidateCache()
+		sys := &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+			RegistriesDirPath:           tmpDir,
+			DockerCertPath:              tmpDir,
+			SystemRegistriesConfPath:    confPath,
+		}
+		cache := blobinfocache.NewMemoryCache()
+		cache.RecordKnownLocation(sourceRef.Transport(), bicTransportScope(sourceRef.(dockerReference)), blobDigest, newBICLocationReference(sourceRef.(dockerReference)))
+
+		dest, err := ref.NewImageDestination(context.Background(), sys)
+		require.NoError(t, err)
+		reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, cache, false)
+		require.NoError(t, err)
+		assert.Equal(t, len(c.expectedMounts) != 0, reused)
+		if reused {
+			assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: 4}, info)
+		}
+		assert.Equal(t, c.expectedMounts, mounts)
+		dest.Close()
+	}
+}
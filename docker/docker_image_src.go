@@ -10,9 +10,12 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/sysregistriesv2"
 	"github.com/containers/image/types"
 	"github.com/docker/distribution/registry/client"
 	"github.com/opencontainers/go-digest"
@@ -20,31 +23,111 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// maxBlobDownloadResumes is the number of times GetBlob tries to resume reading a blob after a failure.
+const maxBlobDownloadResumes = 5
+
+// blobDownloadRetryDelay is the delay before the first attempt to resume reading a blob; it increases with every attempt.
+// It is a variable only to allow tests to avoid waiting.
+var blobDownloadRetryDelay = 1 * time.Second
+
 type dockerImageSource struct {
-	ref dockerReference
-	c   *dockerClient
+	logicalRef  dockerReference // The reference the user requested.
+	physicalRef dockerReference // The actual reference we are accessing (possibly a mirror)
+	c           *dockerClient
 	// State
 	cachedManifest         []byte // nil if not loaded yet
 	cachedManifestMIMEType string // Only valid if cachedManifest != nil
 }
 
 // newImageSource creates a new ImageSource for the specified image reference.
+// If registries.conf configures mirrors for the reference, they are tried in order before the primary location;
+// the first location which serves the manifest is used.
 // The caller must call .Close() on the returned ImageSource.
-func newImageSource(sys *types.SystemContext, ref dockerReference) (*dockerImageSource, error) {
-	c, err := newDockerClientFromRef(sys, ref, false, "pull")
+func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerReference) (*dockerImageSource, error) {
+	registry, err := sysregistriesv2.FindRegistry(sys, ref.ref.Name())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading registries configuration")
+	}
+	if registry == nil {
+		// No configuration was found for the provided reference, so use the
+		// equivalent of a default configuration.
+		registry = &sysregistriesv2.Registry{
+			URL:    reference.Domain(ref.ref),
+			Prefix: reference.Domain(ref.ref),
+		}
+	}
+	pullSources, err := registry.PullSourcesFromReference(ref.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the mirrors need to be probed: if all of them fail, we use the primary location
+	// even if it does not work either, and report the error when the image is actually accessed.
+	attempts := []string{}
+	var lastErr error
+	for i, pullSource := range pullSources {
+		logrus.Debugf("Trying to access %q", pullSource.Reference)
+		s, err := newImageSourceAttempt(ctx, sys, ref, pullSource, i != len(pullSources)-1)
+		if err == nil {
+			if pullSource.Mirror {
+				logrus.Debugf("Using mirror %q for %q", pullSource.Reference, ref.ref)
+			}
+			return s, nil
+		}
+		logrus.Debugf("Accessing %q failed: %v", pullSource.Reference, err)
+		attempts = append(attempts, fmt.Sprintf("[%s: %v]", pullSource.Reference, err))
+		lastErr = err
+	}
+	switch len(attempts) {
+	case 0:
+		return nil, errors.New("Internal error: newImageSource returned without trying any endpoint")
+	case 1:
+		return nil, lastErr
+	default:
+		return nil, errors.Errorf("Error reading image %q, attempted the following sources: %s", ref.ref, strings.Join(attempts, ", "))
+	}
+}
+
+// newImageSourceAttempt is an internal helper for newImageSource. Everyone else must call newImageSource.
+// Given a logicalReference and a pullSource, return a dockerImageSource if it is reachable, or only
+// initialize it without contacting the registry, if !probe.
+func newImageSourceAttempt(ctx context.Context, sys *types.SystemContext, logicalRef dockerReference, pullSource sysregistriesv2.PullSource, probe bool) (*dockerImageSource, error) {
+	physicalRef, err := newReference(pullSource.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if pullSource.Mirror && (sys == nil || sys.DockerInsecureSkipTLSVerify == types.OptionalBoolUndefined) {
+		// The TLS verification setting of the mirror applies, instead of the one for the mirror’s own registries.conf entry, if any.
+		var sysCopy types.SystemContext
+		if sys != nil {
+			sysCopy = *sys
+		}
+		sysCopy.DockerInsecureSkipTLSVerify = types.NewOptionalBool(pullSource.Insecure)
+		sys = &sysCopy
+	}
+
+	c, err := newDockerClientFromRef(sys, physicalRef, false, "pull")
 	if err != nil {
 		return nil, err
 	}
-	return &dockerImageSource{
-		ref: ref,
-		c:   c,
-	}, nil
+	s := &dockerImageSource{
+		logicalRef:  logicalRef,
+		physicalRef: physicalRef,
+		c:           c,
+	}
+	if probe {
+		if err := s.ensureManifestIsLoaded(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 // Reference returns the reference used to set up this source, _as specified by the user_
 // (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
 func (s *dockerImageSource) Reference() types.ImageReference {
-	return s.ref
+	return s.logicalRef
 }
 
 // Close removes resources associated with an initialized ImageSource, if any.
@@ -86,7 +169,7 @@ func (s *dockerImageSource) GetManifest(ctx context.Context, instanceDigest *dig
 }
 
 func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest string) ([]byte, string, error) {
-	path := fmt.Sprintf(manifestPath, reference.Path(s.ref.ref), tagOrDigest)
+	path := fmt.Sprintf(manifestPath, reference.Path(s.physicalRef.ref), tagOrDigest)
 	headers := make(map[string][]string)
 	headers["Accept"] = manifest.DefaultRequestedManifestMIMETypes
 	res, err := s.c.makeRequest(ctx, "GET", path, headers, nil, v2Auth)
@@ -95,7 +178,7 @@ func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest strin
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, "", errors.Wrapf(client.HandleErrorResponse(res), "Error reading manifest %s in %s", tagOrDigest, s.ref.ref.Name())
+		return nil, "", errors.Wrapf(client.HandleErrorResponse(res), "Error reading manifest %s in %s", tagOrDigest, s.physicalRef.ref.Name())
 	}
 	manblob, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -116,7 +199,7 @@ func (s *dockerImageSource) ensureManifestIsLoaded(ctx context.Context) error {
 		return nil
 	}
 
-	reference, err := s.ref.tagOrDigest()
+	reference, err := s.physicalRef.tagOrDigest()
 	if err != nil {
 		return err
 	}
@@ -174,7 +257,7 @@ func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, ca
 		return s.getExternalBlob(ctx, info.URLs)
 	}
 
-	path := fmt.Sprintf(blobsPath, reference.Path(s.ref.ref), info.Digest.String())
+	path := fmt.Sprintf(blobsPath, reference.Path(s.physicalRef.ref), info.Digest.String())
 	logrus.Debugf("Downloading %s", path)
 	res, err := s.c.makeRequest(ctx, "GET", path, nil, nil, v2Auth)
 	if err != nil {
@@ -184,8 +267,126 @@ func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, ca
 		// print url also
 		return nil, 0, errors.Errorf("Invalid status code returned when fetching blob %d (%s)", res.StatusCode, http.StatusText(res.StatusCode))
 	}
-	cache.RecordKnownLocation(s.ref.Transport(), bicTransportScope(s.ref), info.Digest, newBICLocationReference(s.ref))
-	return res.Body, getBlobSize(res), nil
+	cache.RecordKnownLocation(s.physicalRef.Transport(), bicTransportScope(s.physicalRef), info.Digest, newBICLocationReference(s.physicalRef))
+	size := getBlobSize(res)
+	return newResumableBlobReader(ctx, s.c, path, res, size, info.Digest), size, nil
+}
+
+// resumableBlobReader is an io.ReadCloser for a blob, which transparently reconnects using a HTTP Range request
+// if reading the response body fails.
+type resumableBlobReader struct {
+	ctx            context.Context
+	c              *dockerClient
+	path           string        // The registry path of the blob
+	body           io.ReadCloser // The body of the current response
+	etag           string        // The ETag of the original response, if any
+	size           int64         // The size of the blob, or -1 if unknown
+	expectedDigest digest.Digest // The digest of the blob, or "" if it can not be verified
+	digester       digest.Digester
+	offset         int64 // The number of bytes received so far
+	resumes        int   // The number of resume attempts made so far
+}
+
+// newResumableBlobReader returns a resumableBlobReader reading res, a response to a GET request for path.
+func newResumableBlobReader(ctx context.Context, c *dockerClient, path string, res *http.Response, size int64, expectedDigest digest.Digest) *resumableBlobReader {
+	r := &resumableBlobReader{
+		ctx:  ctx,
+		c:    c,
+		path: path,
+		body: res.Body,
+		etag: res.Header.Get("ETag"),
+		size: size,
+	}
+	if expectedDigest.Validate() == nil {
+		r.expectedDigest = expectedDigest
+		r.digester = expectedDigest.Algorithm().Digester()
+	}
+	return r
+}
+
+// Read implements io.Reader.
+func (r *resumableBlobReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		if n > 0 {
+			if r.digester != nil {
+				r.digester.Hash().Write(p[:n])
+			}
+			r.offset += int64(n)
+		}
+		switch {
+		case err == nil:
+			return n, nil
+		case err == io.EOF && (r.size == -1 || r.offset >= r.size):
+			if r.digester != nil && r.digester.Digest() != r.expectedDigest {
+				return n, errors.Errorf("Digest did not match, expected %s, got %s", r.expectedDigest, r.digester.Digest())
+			}
+			return n, io.EOF
+		case err == io.EOF:
+			err = io.ErrUnexpectedEOF
+		}
+		if resumeErr := r.resume(err); resumeErr != nil {
+			return n, resumeErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume replaces r.body by a response continuing at r.offset, after reading r.body failed with cause.
+func (r *resumableBlobReader) resume(cause error) error {
+	r.body.Close()
+	for r.resumes < maxBlobDownloadResumes {
+		if r.ctx.Err() != nil {
+			return cause
+		}
+		r.resumes++
+		logrus.Debugf("Error reading blob %s at offset %d, resuming (attempt %d): %v", r.path, r.offset, r.resumes, cause)
+		select {
+		case <-r.ctx.Done():
+			return cause
+		case <-time.After(time.Duration(r.resumes) * blobDownloadRetryDelay):
+		}
+
+		headers := map[string][]string{"Range": {fmt.Sprintf("bytes=%d-", r.offset)}}
+		if r.etag != "" && !strings.HasPrefix(r.etag, "W/") { // If-Range only allows strong validators
+			headers["If-Range"] = []string{r.etag}
+		}
+		res, err := r.c.makeRequest(r.ctx, "GET", r.path, headers, nil, v2Auth)
+		if err != nil {
+			logrus.Debugf("Error resuming blob download: %v", err)
+			continue
+		}
+		if err := r.checkResumedResponse(res); err != nil {
+			res.Body.Close()
+			return errors.Wrapf(cause, "Error reading blob, and the download can not be resumed: %v", err)
+		}
+		r.body = res.Body
+		return nil
+	}
+	return errors.Wrapf(cause, "Error reading blob, giving up after %d resume attempts", r.resumes)
+}
+
+// checkResumedResponse returns an error if res does not continue the blob at r.offset.
+func (r *resumableBlobReader) checkResumedResponse(res *http.Response) error {
+	if res.StatusCode != http.StatusPartialContent {
+		return errors.Errorf("Invalid status code returned when resuming: %d (%s)", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	if etag := res.Header.Get("ETag"); r.etag != "" && etag != "" && etag != r.etag {
+		return errors.Errorf("Blob ETag changed from %s to %s", r.etag, etag)
+	}
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err != nil || start != r.offset {
+		return errors.Errorf("Unexpected Content-Range %#v, expected offset %d", res.Header.Get("Content-Range"), r.offset)
+	}
+	return nil
+}
+
+// Close implements io.Closer.
+func (r *resumableBlobReader) Close() error {
+	return r.body.Close()
 }
 
 // GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
@@ -212,7 +413,7 @@ func (s *dockerImageSource) manifestDigest(ctx context.Context, instanceDigest *
 	if instanceDigest != nil {
 		return *instanceDigest, nil
 	}
-	if digested, ok := s.ref.ref.(reference.Digested); ok {
+	if digested, ok := s.physicalRef.ref.(reference.Digested); ok {
 		d := digested.Digest()
 		if d.Algorithm() == digest.Canonical {
 			return d, nil
@@ -302,7 +503,7 @@ func (s *dockerImageSource) getSignaturesFromAPIExtension(ctx context.Context, i
 		return nil, err
 	}
 
-	parsedBody, err := s.c.getExtensionsSignatures(ctx, s.ref, manifestDigest)
+	parsedBody, err := s.c.getExtensionsSignatures(ctx, s.physicalRef, manifestDigest)
 	if err != nil {
 		return nil, err
 	}
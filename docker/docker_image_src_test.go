@@ -1,9 +1,26 @@
 package docker
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/sysregistriesv2"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSimplifyContentType(t *testing.T) {
@@ -22,3 +39,176 @@ func TestSimplifyContentType(t *testing.T) {
 		assert.Equal(t, c.expected, out, c.input)
 	}
 }
+
+func TestNewImageSourceMirrors(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/mirror-ns/ns/image/manifests/tag":
+			w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			w.Write(manifestBlob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverLocation := strings.TrimPrefix(server.URL, "http://")
+
+	tmpDir, err := ioutil.TempDir("", "docker-mirrors")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, c := range []struct {
+		mirror           string
+		expectedPhysical string
+	}{
+		{serverLocation + "/mirror-ns", serverLocation + "/mirror-ns/ns/image:tag"}, // A working mirror is used
+		{serverLocation + "/missing-ns", "primary.invalid/ns/image:tag"},            // If the mirror fails, the primary location is used
+	} {
+		registriesConf := filepath.Join(tmpDir, "registries.conf")
+		err := ioutil.WriteFile(registriesConf, []byte(fmt.Sprintf(`
+[[registry]]
+url = "primary.invalid"
+prefix = "example.com"
+
+[[registry.mirror]]
+url = "%s"
+insecure = true
+`, c.mirror)), 0600)
+		require.NoError(t, err)
+		sysregistriesv2.InvalidateCache()
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath: registriesConf,
+			AuthFilePath:             filepath.Join(tmpDir, "auth.json"),
+			RegistriesDirPath:        tmpDir,
+			DockerCertPath:           tmpDir,
+		}
+
+		ref, err := ParseReference("//example.com/ns/image:tag")
+		require.NoError(t, err)
+		src, err := newImageSource(context.Background(), sys, ref.(dockerReference))
+		require.NoError(t, err, c.mirror)
+		assert.Equal(t, "example.com/ns/image:tag", src.Reference().DockerReference().String(), c.mirror)
+		assert.Equal(t, c.expectedPhysical, src.physicalRef.ref.String(), c.mirror)
+		src.Close()
+	}
+}
+
+// blobTestRegistry is a minimal registry implementation serving a single blob, which can simulate dropped connections.
+type blobTestRegistry struct {
+	mutex        sync.Mutex
+	blob         []byte
+	etag         string
+	failRequests int      // If > 0, the number of responses which are cut off after sending half of the requested data
+	changeETag   bool     // If true, the blob's ETag changes after the first request
+	ranges       []string // Range values of received blob requests
+}
+
+func (r *blobTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/v2/ns/image/blobs/"):
+		r.ranges = append(r.ranges, req.Header.Get("Range"))
+		etag := r.etag
+		if r.changeETag {
+			r.etag = `"changed"`
+		}
+		if r.failRequests > 0 {
+			r.failRequests--
+			start := 0
+			if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+				if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-", &start); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if start == 0 {
+				fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\n")
+			} else {
+				fmt.Fprintf(buf, "HTTP/1.1 206 Partial Content\r\nContent-Range: bytes %d-%d/%d\r\n", start, len(r.blob)-1, len(r.blob))
+			}
+			fmt.Fprintf(buf, "ETag: %s\r\nContent-Length: %d\r\n\r\n", etag, len(r.blob)-start)
+			buf.Write(r.blob[start : start+(len(r.blob)-start)/2])
+			buf.Flush()
+			return
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(r.blob))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGetBlobResumable(t *testing.T) {
+	defer func(delay time.Duration) { blobDownloadRetryDelay = delay }(blobDownloadRetryDelay)
+	blobDownloadRetryDelay = 0
+
+	tmpDir, err := ioutil.TempDir("", "docker-get-blob")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "registries.conf"), []byte{}, 0600)
+	require.NoError(t, err)
+	blob := bytes.Repeat([]byte("0123456789"), 10)
+	blobDigest := digest.FromBytes(blob)
+
+	for _, c := range []struct {
+		served         []byte
+		failRequests   int
+		changeETag     bool
+		expectedRanges []string
+		expectedError  bool
+	}{
+		// No failures
+		{blob, 0, false, []string{""}, false},
+		// Resuming after failures
+		{blob, 2, false, []string{"", "bytes=50-", "bytes=75-"}, false},
+		// Too many failures
+		{blob, maxBlobDownloadResumes + 1, false, []string{"", "bytes=50-", "bytes=75-", "bytes=87-", "bytes=93-", "bytes=96-"}, true},
+		// The blob changes while resuming
+		{blob, 1, true, []string{"", "bytes=50-"}, true},
+		// The served data does not match the digest
+		{bytes.Repeat([]byte("x"), len(blob)), 1, false, []string{"", "bytes=50-"}, true},
+	} {
+		registry := &blobTestRegistry{blob: c.served, etag: `"original"`, failRequests: c.failRequests, changeETag: c.changeETag}
+		server := httptest.NewServer(registry)
+		serverLocation := strings.TrimPrefix(server.URL, "http://")
+		ref, err := ParseReference("//" + serverLocation + "/ns/image:tag")
+		require.NoError(t, err)
+		sys := &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+			RegistriesDirPath:           tmpDir,
+			DockerCertPath:              tmpDir,
+			SystemRegistriesConfPath:    filepath.Join(tmpDir, "registries.conf"),
+		}
+		src, err := ref.NewImageSource(context.Background(), sys)
+		require.NoError(t, err)
+
+		reader, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NewMemoryCache())
+		require.NoError(t, err, "%#v", c)
+		assert.Equal(t, int64(len(blob)), size, "%#v", c)
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if c.expectedError {
+			assert.Error(t, err, "%#v", c)
+		} else {
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, blob, data, "%#v", c)
+		}
+		assert.Equal(t, c.expectedRanges, registry.ranges, "%#v", c)
+
+		src.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteImages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-delete")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "DELETE" && r.URL.Path == "/v2/tags/manifests/v1":
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == "DELETE" && r.URL.Path == "/v2/digests-only/manifests/v1":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverLocation := strings.TrimPrefix(server.URL, "http://")
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+		DockerCertPath:              tmpDir,
+		RegistriesDirPath:           tmpDir,
+	}
+
+	refs := []types.ImageReference{}
+	for _, name := range []string{"tags:v1", "digests-only:v1", "tags:missing", "tags@sha256:0000000000000000000000000000000000000000000000000000000000000000"} {
+		ref, err := ParseReference("//" + serverLocation + "/" + name)
+		require.NoError(t, err)
+		refs = append(refs, ref)
+	}
+	results := DeleteImages(context.Background(), sys, refs, true)
+	require.Len(t, results, len(refs))
+	for i, result := range results {
+		assert.Equal(t, refs[i], result.Reference)
+	}
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, ErrTagDeletionNotSupported, errors.Cause(results[1].Err))
+	assert.Error(t, results[2].Err)
+	assert.NotEqual(t, ErrTagDeletionNotSupported, errors.Cause(results[2].Err))
+	assert.Error(t, results[3].Err) // Not a tag
+	assert.Equal(t, []string{"/v2/tags/manifests/v1"}, deleted)
+
+	// Deletions are not attempted after the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = DeleteImages(ctx, sys, refs[:1], true)
+	require.Len(t, results, 1)
+	assert.Equal(t, context.Canceled, results[0].Err)
+	assert.Len(t, deleted, 1)
+}
@@ -181,6 +181,14 @@ func TestReferenceNewImageDestination(t *testing.T) {
 	defer dest.Close()
 }
 
+func TestReferenceDestinationIsInspectable(t *testing.T) {
+	ref, err := ParseReference("//busybox")
+	require.NoError(t, err)
+	inspectable, ok := ref.(types.ImageReferenceWithInspectableDestination)
+	require.True(t, ok)
+	assert.True(t, inspectable.DestinationIsInspectable())
+}
+
 func TestReferenceTagOrDigest(t *testing.T) {
 	for input, expected := range map[string]string{
 		"//busybox:notlatest":      "notlatest",
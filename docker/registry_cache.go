@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// pingCacheLifetime is the time for which a successful ping of a registry is reused by other dockerClient instances.
+const pingCacheLifetime = 10 * time.Minute
+
+// cachedPing is the result of a successful detectPropertiesHelper.
+type cachedPing struct {
+	scheme             string
+	challenges         []challenge
+	supportsSignatures bool
+	expirationTime     time.Time
+}
+
+var (
+	// pingCache is shared by all dockerClient instances in the process (key: pingCacheKey, value: cachedPing).
+	pingCache sync.Map
+	// tokenCache is shared by all dockerClient instances in the process (key: tokenCacheKey, value: bearerToken).
+	tokenCache sync.Map
+)
+
+// pingCacheKey returns the key used for c in pingCache.
+func (c *dockerClient) pingCacheKey() string {
+	// insecureSkipTLSVerify determines whether a HTTP fallback is allowed, so it must be a part of the key.
+	return fmt.Sprintf("%s\x00%t", c.registry, c.insecureSkipTLSVerify)
+}
+
+// loadCachedPing sets the detected registry properties of c from pingCache, and returns true if they were found.
+func (c *dockerClient) loadCachedPing() bool {
+	v, ok := pingCache.Load(c.pingCacheKey())
+	if !ok {
+		return false
+	}
+	ping := v.(cachedPing)
+	if time.Now().After(ping.expirationTime) {
+		return false
+	}
+	c.scheme = ping.scheme
+	c.challenges = ping.challenges
+	c.supportsSignatures = ping.supportsSignatures
+	return true
+}
+
+// storeCachedPing records the detected registry properties of c in pingCache.
+func (c *dockerClient) storeCachedPing() {
+	pingCache.Store(c.pingCacheKey(), cachedPing{
+		scheme:             c.scheme,
+		challenges:         c.challenges,
+		supportsSignatures: c.supportsSignatures,
+		expirationTime:     time.Now().Add(pingCacheLifetime),
+	})
+}
+
+// tokenCacheKey returns the key used in tokenCache for a token for scopes, obtained using the credentials of c.
+func (c *dockerClient) tokenCacheKey(scopes []authScope) string {
+	c.identityTokenLock.Lock()
+	identityToken := c.identityToken
+	c.identityTokenLock.Unlock()
+	// Use a digest to avoid keeping yet another copy of the credentials in memory.
+	credentials := digest.FromString(c.username + "\x00" + c.password + "\x00" + identityToken)
+	parts := []string{c.registry, credentials.String()}
+	for _, scope := range scopes {
+		parts = append(parts, scope.String())
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// loadCachedToken returns a token for key from tokenCache, and true, if a token that has not expired yet is available.
+func loadCachedToken(key string) (bearerToken, bool) {
+	v, ok := tokenCache.Load(key)
+	if !ok {
+		return bearerToken{}, false
+	}
+	token := v.(bearerToken)
+	if time.Now().After(token.expirationTime) {
+		return bearerToken{}, false
+	}
+	return token, true
+}
+
+// storeCachedToken records token for key in tokenCache, and drops any expired tokens.
+func storeCachedToken(key string, token bearerToken) {
+	now := time.Now()
+	tokenCache.Range(func(k, v interface{}) bool {
+		if now.After(v.(bearerToken).expirationTime) {
+			tokenCache.Delete(k)
+		}
+		return true
+	})
+	tokenCache.Store(key, token)
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryCacheSharedByClients(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-registry-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	pings, tokenRequests := 0, 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			pings++
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			tokenRequests++
+			fmt.Fprintf(w, `{"token":"token-%d"}`, tokenRequests)
+		case "/v2/ns/image/tags/list", "/v2/other/tags/list":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"tags":["latest"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverLocation := strings.TrimPrefix(server.URL, "http://")
+
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		AuthFilePath:                filepath.Join(tmpDir, "auth.json"),
+		DockerCertPath:              tmpDir,
+	}
+	for i, c := range []struct {
+		repo, username    string
+		pings, tokenCount int
+	}{
+		{"ns/image", "", 1, 1},
+		{"ns/image", "", 1, 1},     // Both the ping and the token are reused
+		{"other", "", 1, 2},        // A different scope requires a different token
+		{"ns/image", "user", 1, 3}, // So do different credentials
+	} {
+		ref, err := ParseReference("//" + serverLocation + "/" + c.repo)
+		require.NoError(t, err)
+		caseSys := *sys
+		if c.username != "" {
+			caseSys.DockerAuthConfig = &types.DockerAuthConfig{Username: c.username, Password: "password"}
+		}
+		tags, err := GetRepositoryTags(context.Background(), &caseSys, ref)
+		require.NoError(t, err, "%d", i)
+		assert.Equal(t, []string{"latest"}, tags, "%d", i)
+		assert.Equal(t, c.pings, pings, "%d", i)
+		assert.Equal(t, c.tokenCount, tokenRequests, "%d", i)
+	}
+}
+
+func TestLoadCachedToken(t *testing.T) {
+	storeCachedToken("valid-test-token", bearerToken{Token: "valid", expirationTime: time.Now().Add(time.Minute)})
+	storeCachedToken("expired-test-token", bearerToken{Token: "expired", expirationTime: time.Now().Add(-time.Minute)})
+
+	token, ok := loadCachedToken("valid-test-token")
+	assert.True(t, ok)
+	assert.Equal(t, "valid", token.Token)
+	_, ok = loadCachedToken("expired-test-token")
+	assert.False(t, ok)
+	_, ok = loadCachedToken("unknown-test-token")
+	assert.False(t, ok)
+
+	// Expired tokens are dropped when storing new ones
+	storeCachedToken("other-test-token", bearerToken{Token: "other", expirationTime: time.Now().Add(time.Minute)})
+	_, ok = tokenCache.Load("expired-test-token")
+	assert.False(t, ok)
+}
@@ -418,10 +418,10 @@ func (d *memoryImageDest) PutBlob(ctx context.Context, stream io.Reader, inputIn
 func (d *memoryImageDest) TryReusingBlob(context.Context, types.BlobInfo, types.BlobInfoCache, bool) (bool, types.BlobInfo, error) {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) PutManifest(ctx context.Context, m []byte) error {
+func (d *memoryImageDest) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) PutSignatures(ctx context.Context, signatures [][]byte) error {
+func (d *memoryImageDest) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
 	panic("Unexpected call to a mock function")
 }
 func (d *memoryImageDest) Commit(ctx context.Context) error {
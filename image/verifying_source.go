@@ -0,0 +1,89 @@
+package image
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// verifyingSource is a types.ImageSource whose GetBlob verifies the contents of blobs.
+type verifyingSource struct {
+	types.ImageSource
+}
+
+// NewVerifyingSource returns a types.ImageSource wrapping src, whose GetBlob returns streams which fail
+// (with a non-EOF error) if the blob does not match BlobInfo.Digest, or BlobInfo.Size if it is known.
+// Closing the returned ImageSource closes src.
+func NewVerifyingSource(src types.ImageSource) types.ImageSource {
+	if _, ok := src.(*verifyingSource); ok {
+		return src
+	}
+	return &verifyingSource{ImageSource: src}
+}
+
+// GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
+// The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
+// Reading the stream fails if the blob does not match info.
+func (s *verifyingSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if err := info.Digest.Validate(); err != nil {
+		return nil, -1, errors.Wrapf(err, "Invalid digest specification %q", info.Digest)
+	}
+	if !info.Digest.Algorithm().Available() {
+		return nil, -1, errors.Errorf("Invalid digest specification %s: unsupported digest algorithm %s", info.Digest, info.Digest.Algorithm())
+	}
+	stream, size, err := s.ImageSource.GetBlob(ctx, info, cache)
+	if err != nil {
+		return nil, -1, err
+	}
+	if info.Size != -1 && size != -1 && size != info.Size {
+		stream.Close()
+		return nil, -1, errors.Errorf("Size of blob %s did not match, expected %d, got %d", info.Digest, info.Size, size)
+	}
+	if size == -1 {
+		size = info.Size
+	}
+	return &verifyingReader{
+		source:         stream,
+		digester:       info.Digest.Algorithm().Digester(),
+		expectedDigest: info.Digest,
+		expectedSize:   info.Size,
+	}, size, nil
+}
+
+// verifyingReader is an io.ReadCloser which verifies that the contents of source match expectedDigest and expectedSize.
+type verifyingReader struct {
+	source         io.ReadCloser
+	digester       digest.Digester
+	expectedDigest digest.Digest
+	expectedSize   int64 // -1 if unknown
+	size           int64 // The number of bytes read so far
+}
+
+// Read implements io.Reader.
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	if n > 0 {
+		r.digester.Hash().Write(p[:n])
+		r.size += int64(n)
+		if r.expectedSize != -1 && r.size > r.expectedSize {
+			return 0, errors.Errorf("Size of blob %s did not match, expected %d, got more", r.expectedDigest, r.expectedSize)
+		}
+	}
+	if err == io.EOF {
+		if r.expectedSize != -1 && r.size != r.expectedSize {
+			return 0, errors.Errorf("Size of blob %s did not match, expected %d, got %d", r.expectedDigest, r.expectedSize, r.size)
+		}
+		if actualDigest := r.digester.Digest(); actualDigest != r.expectedDigest {
+			return 0, errors.Errorf("Digest did not match, expected %s, got %s", r.expectedDigest, actualDigest)
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *verifyingReader) Close() error {
+	return r.source.Close()
+}
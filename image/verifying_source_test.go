@@ -0,0 +1,72 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blobImageSource is an ImageSource which returns the result of f from GetBlob.
+type blobImageSource struct {
+	unusedImageSource // We inherit almost all of the methods, which just panic()
+	f                 func() (io.ReadCloser, int64, error)
+}
+
+func (f blobImageSource) GetBlob(context.Context, types.BlobInfo, types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	return f.f()
+}
+
+func TestVerifyingSourceGetBlob(t *testing.T) {
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+
+	for _, c := range []struct {
+		name       string
+		served     []byte
+		servedSize int64
+		info       types.BlobInfo
+		getBlobErr bool
+		readErr    bool
+	}{
+		{"match", blob, int64(len(blob)), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, false, false},
+		{"unknown size", blob, -1, types.BlobInfo{Digest: blobDigest, Size: -1}, false, false},
+		{"digest mismatch", []byte("other contents"), -1, types.BlobInfo{Digest: blobDigest, Size: -1}, false, true},
+		{"reported size mismatch", blob, int64(len(blob)), types.BlobInfo{Digest: blobDigest, Size: 1}, true, false},
+		{"stream too long", blob, -1, types.BlobInfo{Digest: blobDigest, Size: 4}, false, true},
+		{"stream too short", blob, -1, types.BlobInfo{Digest: blobDigest, Size: 100}, false, true},
+		{"invalid digest", blob, -1, types.BlobInfo{Digest: "sha256:invalid", Size: -1}, true, false},
+	} {
+		src := NewVerifyingSource(blobImageSource{f: func() (io.ReadCloser, int64, error) {
+			return ioutil.NopCloser(bytes.NewReader(c.served)), c.servedSize, nil
+		}})
+		stream, size, err := src.GetBlob(context.Background(), c.info, blobinfocache.NoCache)
+		if c.getBlobErr {
+			assert.Error(t, err, c.name)
+			continue
+		}
+		require.NoError(t, err, c.name)
+		if c.info.Size != -1 {
+			assert.Equal(t, c.info.Size, size, c.name)
+		}
+		contents, err := ioutil.ReadAll(stream)
+		stream.Close()
+		if c.readErr {
+			assert.Error(t, err, c.name)
+		} else {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, blob, contents, c.name)
+		}
+	}
+
+	// Wrapping is idempotent
+	src := NewVerifyingSource(blobImageSource{})
+	assert.Equal(t, src, NewVerifyingSource(src))
+}
@@ -0,0 +1,81 @@
+// Package testimage is a TESTING-ONLY utility.
+//
+// It creates small images (a config and a single layer) in arbitrary transports, for tests which need
+// an existing image as a source or destination; failures are reported using testify/require.
+//
+// NEVER use this in non-testing subpackages!
+package testimage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// Image describes an image created by Put or PutBlobs.
+type Image struct {
+	Manifest     []byte
+	ManifestType string
+	Config       types.BlobInfo
+	Layer        types.BlobInfo
+}
+
+// Put writes an image with the specified config and a single layer to ref, using a manifestType manifest
+// (manifest.DockerV2Schema2MediaType or imgspecv1.MediaTypeImageManifest), and commits it.
+// The manifest describes the layer as gzip-compressed; use Gzip if the layer contents matter.
+func Put(t *testing.T, sys *types.SystemContext, ref types.ImageReference, manifestType string, config, layer []byte) Image {
+	dest, err := ref.NewImageDestination(context.Background(), sys)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	img := PutBlobs(t, dest, manifestType, config, layer)
+	err = dest.PutManifest(context.Background(), img.Manifest, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	return img
+}
+
+// PutBlobs writes config and layer to dest, and returns an image with a manifestType manifest referring to them;
+// the manifest is not written to dest.
+func PutBlobs(t *testing.T, dest types.ImageDestination, manifestType string, config, layer []byte) Image {
+	cache := blobinfocache.NewMemoryCache()
+	configInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(config), types.BlobInfo{Size: -1}, cache, true)
+	require.NoError(t, err)
+	layerInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(layer), types.BlobInfo{Size: -1}, cache, false)
+	require.NoError(t, err)
+
+	var m []byte
+	switch manifestType {
+	case manifest.DockerV2Schema2MediaType:
+		m, err = manifest.Schema2FromComponents(
+			manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2ConfigMediaType, Size: configInfo.Size, Digest: configInfo.Digest},
+			[]manifest.Schema2Descriptor{{MediaType: manifest.DockerV2Schema2LayerMediaType, Size: layerInfo.Size, Digest: layerInfo.Digest}}).Serialize()
+	case imgspecv1.MediaTypeImageManifest:
+		m, err = manifest.OCI1FromComponents(
+			imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Size: configInfo.Size, Digest: configInfo.Digest},
+			[]imgspecv1.Descriptor{{MediaType: imgspecv1.MediaTypeImageLayerGzip, Size: layerInfo.Size, Digest: layerInfo.Digest}}).Serialize()
+	default:
+		require.FailNow(t, "Unsupported manifest type", manifestType)
+	}
+	require.NoError(t, err)
+	return Image{Manifest: m, ManifestType: manifestType, Config: configInfo, Layer: layerInfo}
+}
+
+// Gzip returns contents compressed using gzip.
+func Gzip(t *testing.T, contents []byte) []byte {
+	buf := bytes.Buffer{}
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(contents)
+	require.NoError(t, err)
+	err = writer.Close()
+	require.NoError(t, err)
+	return buf.Bytes()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/containers/image/pkg/compression"
 	"github.com/containers/image/pkg/strslice"
 	"github.com/containers/image/types"
 	"github.com/opencontainers/go-digest"
@@ -207,6 +208,9 @@ func (m *Schema2) UpdateLayerInfos(layerInfos []types.BlobInfo) error {
 	original := m.LayersDescriptors
 	m.LayersDescriptors = make([]Schema2Descriptor, len(layerInfos))
 	for i, info := range layerInfos {
+		if info.CompressionOperation == types.Compress && info.CompressionAlgorithm != nil && info.CompressionAlgorithm.Name() != compression.Gzip.Name() {
+			return errors.Errorf("Error preparing updated manifest: layer %q uses %s compression, which is not supported by Docker schema2 manifests", info.Digest, info.CompressionAlgorithm.Name())
+		}
 		m.LayersDescriptors[i].MediaType = original[i].MediaType
 		m.LayersDescriptors[i].Digest = info.Digest
 		m.LayersDescriptors[i].Size = info.Size
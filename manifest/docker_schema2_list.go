@@ -0,0 +1,245 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Schema2PlatformSpec describes the platform which a particular manifest is
+// specialized for.
+type Schema2PlatformSpec struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"` // removed in OCI
+}
+
+// Schema2ManifestDescriptor references a platform-specific manifest.
+type Schema2ManifestDescriptor struct {
+	Schema2Descriptor
+	Platform Schema2PlatformSpec `json:"platform"`
+}
+
+// Schema2List is a list of platform-specific manifests.
+type Schema2List struct {
+	SchemaVersion int                         `json:"schemaVersion"`
+	MediaType     string                      `json:"mediaType"`
+	Manifests     []Schema2ManifestDescriptor `json:"manifests"`
+}
+
+// Schema2ListFromManifest creates a Schema2 manifest list instance from marshalled JSON, presumably generated by encoding a Schema2 manifest list.
+func Schema2ListFromManifest(manifest []byte) (*Schema2List, error) {
+	list := Schema2List{
+		Manifests: []Schema2ManifestDescriptor{},
+	}
+	if err := json.Unmarshal(manifest, &list); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing Docker manifest list")
+	}
+	return &list, nil
+}
+
+// Schema2ListFromComponents creates a Schema2 manifest list instance from the supplied data.
+func Schema2ListFromComponents(components []Schema2ManifestDescriptor) *Schema2List {
+	list := Schema2List{
+		SchemaVersion: 2,
+		MediaType:     DockerV2ListMediaType,
+		Manifests:     make([]Schema2ManifestDescriptor, len(components)),
+	}
+	for i, component := range components {
+		list.Manifests[i] = schema2ManifestDescriptorClone(component)
+	}
+	return &list
+}
+
+// Schema2ListClone creates a deep copy of the passed-in list.
+func Schema2ListClone(list *Schema2List) *Schema2List {
+	return Schema2ListFromComponents(list.Manifests)
+}
+
+// schema2ManifestDescriptorClone returns a deep copy of d.
+func schema2ManifestDescriptorClone(d Schema2ManifestDescriptor) Schema2ManifestDescriptor {
+	clone := d
+	clone.URLs = dupStringSlice(d.URLs)
+	clone.Platform.OSFeatures = dupStringSlice(d.Platform.OSFeatures)
+	clone.Platform.Features = dupStringSlice(d.Platform.Features)
+	return clone
+}
+
+// MIMEType returns the MIME type of this particular manifest list.
+func (list *Schema2List) MIMEType() string {
+	return DockerV2ListMediaType
+}
+
+// Instances returns a list of the manifests that this list knows of, in order.
+func (list *Schema2List) Instances() []ListInstance {
+	instances := make([]ListInstance, len(list.Manifests))
+	for i, m := range list.Manifests {
+		instances[i] = schema2ListInstance(m)
+	}
+	return instances
+}
+
+// schema2ListInstance returns a ListInstance describing m.
+func schema2ListInstance(m Schema2ManifestDescriptor) ListInstance {
+	return ListInstance{
+		Digest:    m.Digest,
+		Size:      m.Size,
+		MediaType: m.MediaType,
+		Platform: &imgspecv1.Platform{
+			Architecture: m.Platform.Architecture,
+			OS:           m.Platform.OS,
+			OSVersion:    m.Platform.OSVersion,
+			OSFeatures:   dupStringSlice(m.Platform.OSFeatures),
+			Variant:      m.Platform.Variant,
+		},
+	}
+}
+
+// Instance returns information about the first instance with digest instanceDigest.
+func (list *Schema2List) Instance(instanceDigest digest.Digest) (ListInstance, error) {
+	for _, m := range list.Manifests {
+		if m.Digest == instanceDigest {
+			return schema2ListInstance(m), nil
+		}
+	}
+	return ListInstance{}, errors.Errorf("unable to find instance %s in Docker manifest list", instanceDigest)
+}
+
+// ChooseInstance returns the digest of the instance most appropriate for the platform specified in sys
+// (or the current system, if sys does not specify one), preferring exact matches over compatible ones; see WantedPlatforms.
+func (list *Schema2List) ChooseInstance(sys *types.SystemContext) (digest.Digest, error) {
+	platforms := make([]*imgspecv1.Platform, 0, len(list.Manifests))
+	for _, m := range list.Manifests {
+		platforms = append(platforms, schema2ListInstance(m).Platform)
+	}
+	i, err := chooseInstance(sys, platforms, "manifest list")
+	if err != nil {
+		return "", err
+	}
+	return list.Manifests[i].Digest, nil
+}
+
+// AddInstance adds instance at the end of the list.
+func (list *Schema2List) AddInstance(instance ListInstance) error {
+	if instance.Platform == nil {
+		return errors.Errorf("Instance %s of a Docker manifest list must specify a platform", instance.Digest)
+	}
+	if len(instance.Annotations) != 0 {
+		return errors.Errorf("Docker manifest lists do not support annotations, instance %s has some", instance.Digest)
+	}
+	list.Manifests = append(list.Manifests, Schema2ManifestDescriptor{
+		Schema2Descriptor: Schema2Descriptor{
+			MediaType: instance.MediaType,
+			Size:      instance.Size,
+			Digest:    instance.Digest,
+		},
+		Platform: Schema2PlatformSpec{
+			Architecture: instance.Platform.Architecture,
+			OS:           instance.Platform.OS,
+			OSVersion:    instance.Platform.OSVersion,
+			OSFeatures:   dupStringSlice(instance.Platform.OSFeatures),
+			Variant:      instance.Platform.Variant,
+		},
+	})
+	return nil
+}
+
+// RemoveInstance removes all instances with digest instanceDigest.
+func (list *Schema2List) RemoveInstance(instanceDigest digest.Digest) error {
+	manifests := []Schema2ManifestDescriptor{}
+	for _, m := range list.Manifests {
+		if m.Digest != instanceDigest {
+			manifests = append(manifests, m)
+		}
+	}
+	if len(manifests) == len(list.Manifests) {
+		return errors.Errorf("unable to find instance %s in Docker manifest list", instanceDigest)
+	}
+	list.Manifests = manifests
+	return nil
+}
+
+// UpdateInstances updates the digests, sizes and MIME types of the instances, in order;
+// updates must contain exactly one entry for each instance.
+func (list *Schema2List) UpdateInstances(updates []ListUpdate) error {
+	if len(updates) != len(list.Manifests) {
+		return errors.Errorf("incorrect number of update entries passed to Schema2List.UpdateInstances: expected %d, got %d", len(list.Manifests), len(updates))
+	}
+	for i := range updates {
+		if err := updates[i].Digest.Validate(); err != nil {
+			return errors.Wrapf(err, "update %d of %d passed to Schema2List.UpdateInstances contained an invalid digest", i+1, len(updates))
+		}
+		list.Manifests[i].Digest = updates[i].Digest
+		if updates[i].Size < 0 {
+			return errors.Errorf("update %d of %d passed to Schema2List.UpdateInstances had an invalid size (%d)", i+1, len(updates), updates[i].Size)
+		}
+		list.Manifests[i].Size = updates[i].Size
+		if updates[i].MediaType == "" {
+			return errors.Errorf("update %d of %d passed to Schema2List.UpdateInstances had no media type", i+1, len(updates))
+		}
+		list.Manifests[i].MediaType = updates[i].MediaType
+	}
+	return nil
+}
+
+// Serialize returns the list in a blob format.
+// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+func (list *Schema2List) Serialize() ([]byte, error) {
+	buf, err := json.Marshal(list)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling %#v", list)
+	}
+	return buf, nil
+}
+
+// ConvertToMIMEType returns the list converted to the specified list MIME type, or an error if the conversion
+// is not possible.  The instances are not converted; they keep their original MIME types.
+func (list *Schema2List) ConvertToMIMEType(mimeType string) (List, error) {
+	switch NormalizedMIMEType(mimeType) {
+	case DockerV2ListMediaType:
+		return list.Clone(), nil
+	case imgspecv1.MediaTypeImageIndex:
+		return list.ToOCI1Index(), nil
+	default:
+		return nil, fmt.Errorf("Can not convert manifest list to MIME type %q, which is not a list type", mimeType)
+	}
+}
+
+// ToOCI1Index returns the list encoded as an OCI1 index.
+// The Features of the platforms, which OCI does not support, are dropped.
+func (list *Schema2List) ToOCI1Index() *OCI1Index {
+	components := make([]imgspecv1.Descriptor, 0, len(list.Manifests))
+	for _, m := range list.Manifests {
+		instance := schema2ListInstance(m)
+		components = append(components, imgspecv1.Descriptor{
+			MediaType: instance.MediaType,
+			Size:      instance.Size,
+			Digest:    instance.Digest,
+			URLs:      dupStringSlice(m.URLs),
+			Platform:  instance.Platform,
+		})
+	}
+	return OCI1IndexFromComponents(components, nil)
+}
+
+// Clone returns a deep copy of this list.
+func (list *Schema2List) Clone() List {
+	return Schema2ListClone(list)
+}
+
+// dupStringSlice returns a copy of s, preserving nil values.
+func dupStringSlice(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	res := make([]string, len(s))
+	copy(res, s)
+	return res
+}
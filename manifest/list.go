@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// List is an interface for parsing, modifying lists of image manifests.
+// Callers can either use this abstract interface without understanding the details of the formats,
+// or instantiate a specific implementation (e.g. manifest.OCI1Index) and access the public members
+// directly.
+type List interface {
+	// MIMEType returns the MIME type of this particular manifest list.
+	MIMEType() string
+
+	// Instances returns a list of the manifests that this list knows of, in order.
+	Instances() []ListInstance
+
+	// Instance returns information about the first instance with digest instanceDigest.
+	Instance(instanceDigest digest.Digest) (ListInstance, error)
+
+	// ChooseInstance returns the digest of the instance most appropriate for the platform specified in sys
+	// (or the current system, if sys does not specify one), preferring exact matches over compatible ones; see WantedPlatforms.
+	ChooseInstance(sys *types.SystemContext) (digest.Digest, error)
+
+	// AddInstance adds instance at the end of the list.
+	AddInstance(instance ListInstance) error
+
+	// RemoveInstance removes all instances with digest instanceDigest.
+	RemoveInstance(instanceDigest digest.Digest) error
+
+	// UpdateInstances updates the digests, sizes and MIME types of the instances, in order;
+	// updates must contain exactly one entry for each instance.
+	UpdateInstances(updates []ListUpdate) error
+
+	// Serialize returns the list in a blob format.
+	// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+	Serialize() ([]byte, error)
+
+	// ConvertToMIMEType returns the list converted to the specified list MIME type, or an error if the conversion
+	// is not possible.  The instances are not converted; they keep their original MIME types.
+	ConvertToMIMEType(mimeType string) (List, error)
+
+	// Clone returns a deep copy of this list.
+	Clone() List
+}
+
+// ListInstance describes an instance of a List.
+type ListInstance struct {
+	Digest    digest.Digest
+	Size      int64
+	MediaType string
+	// Platform is the platform of the instance; it may be nil in OCI image indexes, and must be set in Docker manifest lists.
+	Platform    *imgspecv1.Platform
+	Annotations map[string]string // Not supported in Docker manifest lists
+}
+
+// ListUpdate includes the fields which a List's UpdateInstances() method will modify.
+type ListUpdate struct {
+	Digest    digest.Digest
+	Size      int64
+	MediaType string
+}
+
+// ListFromBlob parses a list of manifests.
+func ListFromBlob(manifest []byte, manifestMIMEType string) (List, error) {
+	normalized := NormalizedMIMEType(manifestMIMEType)
+	switch normalized {
+	case DockerV2ListMediaType:
+		return Schema2ListFromManifest(manifest)
+	case imgspecv1.MediaTypeImageIndex:
+		return OCI1IndexFromManifest(manifest)
+	case DockerV2Schema1MediaType, DockerV2Schema1SignedMediaType, imgspecv1.MediaTypeImageManifest, DockerV2Schema2MediaType:
+		return nil, fmt.Errorf("Treating single images as manifest lists is not implemented")
+	default:
+		return nil, fmt.Errorf("Unimplemented manifest list MIME type %s", manifestMIMEType)
+	}
+}
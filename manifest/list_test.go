@@ -0,0 +1,244 @@
+package manifest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFromBlob(t *testing.T) {
+	for _, c := range []struct {
+		path     string
+		mimeType string
+	}{
+		{"v2list.manifest.json", DockerV2ListMediaType},
+		{"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex},
+	} {
+		blob, err := ioutil.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		list, err := ListFromBlob(blob, c.mimeType)
+		require.NoError(t, err, c.path)
+		assert.Equal(t, c.mimeType, list.MIMEType(), c.path)
+		instances := list.Instances()
+		require.True(t, len(instances) >= 2, c.path)
+		for _, instance := range instances {
+			assert.NotEmpty(t, instance.Digest, c.path)
+			require.NotNil(t, instance.Platform, c.path)
+			assert.NotEmpty(t, instance.Platform.Architecture, c.path)
+		}
+
+		// Serialization round-trip
+		serialized, err := list.Serialize()
+		require.NoError(t, err, c.path)
+		assert.Equal(t, c.mimeType, GuessMIMEType(serialized), c.path)
+		reparsed, err := ListFromBlob(serialized, c.mimeType)
+		require.NoError(t, err, c.path)
+		assert.Equal(t, instances, reparsed.Instances(), c.path)
+
+		_, err = ListFromBlob(bytes.Join([][]byte{blob, []byte("!INVALID")}, nil), c.mimeType)
+		assert.Error(t, err, c.path)
+	}
+
+	for _, mimeType := range []string{DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest} {
+		_, err := ListFromBlob([]byte("{}"), mimeType)
+		assert.Error(t, err, mimeType)
+	}
+}
+
+func TestListModifications(t *testing.T) {
+	newDigest := digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	for _, c := range []struct {
+		path     string
+		mimeType string
+	}{
+		{"v2list.manifest.json", DockerV2ListMediaType},
+		{"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex},
+	} {
+		blob, err := ioutil.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		list, err := ListFromBlob(blob, c.mimeType)
+		require.NoError(t, err, c.path)
+		original := list.Clone()
+		instances := list.Instances()
+
+		// Instance
+		instance, err := list.Instance(instances[1].Digest)
+		require.NoError(t, err, c.path)
+		assert.Equal(t, instances[1], instance, c.path)
+		_, err = list.Instance(newDigest)
+		assert.Error(t, err, c.path)
+
+		// UpdateInstances
+		updates := []ListUpdate{}
+		for _, instance := range instances {
+			updates = append(updates, ListUpdate{Digest: instance.Digest, Size: instance.Size, MediaType: instance.MediaType})
+		}
+		updates[0] = ListUpdate{Digest: newDigest, Size: 42, MediaType: imgspecv1.MediaTypeImageManifest}
+		err = list.UpdateInstances(updates)
+		require.NoError(t, err, c.path)
+		updated, err := list.Instance(newDigest)
+		require.NoError(t, err, c.path)
+		assert.Equal(t, int64(42), updated.Size, c.path)
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, updated.MediaType, c.path)
+		assert.Equal(t, instances[0].Platform, updated.Platform, c.path)
+		err = list.UpdateInstances(updates[1:])
+		assert.Error(t, err, c.path)
+		for _, invalid := range []ListUpdate{
+			{Digest: "invalid", Size: 1, MediaType: "a"},
+			{Digest: newDigest, Size: -1, MediaType: "a"},
+			{Digest: newDigest, Size: 1, MediaType: ""},
+		} {
+			invalidUpdates := append([]ListUpdate{invalid}, updates[1:]...)
+			err = list.UpdateInstances(invalidUpdates)
+			assert.Error(t, err, "%s %#v", c.path, invalid)
+		}
+
+		// RemoveInstance
+		err = list.RemoveInstance(newDigest)
+		require.NoError(t, err, c.path)
+		assert.Len(t, list.Instances(), len(instances)-1, c.path)
+		err = list.RemoveInstance(newDigest)
+		assert.Error(t, err, c.path)
+
+		// AddInstance
+		added := ListInstance{Digest: newDigest, Size: 10, MediaType: DockerV2Schema2MediaType, Platform: &imgspecv1.Platform{Architecture: "s390x", OS: "linux"}}
+		err = list.AddInstance(added)
+		require.NoError(t, err, c.path)
+		all := list.Instances()
+		require.Len(t, all, len(instances), c.path)
+		assert.Equal(t, added, all[len(all)-1], c.path)
+
+		// The clone was not modified
+		assert.Equal(t, instances, original.Instances(), c.path)
+	}
+
+	// Docker manifest lists require platforms and don't support annotations
+	list := Schema2ListFromComponents(nil)
+	err := list.AddInstance(ListInstance{Digest: newDigest, Size: 1, MediaType: DockerV2Schema2MediaType})
+	assert.Error(t, err)
+	err = list.AddInstance(ListInstance{Digest: newDigest, Size: 1, MediaType: DockerV2Schema2MediaType,
+		Platform: &imgspecv1.Platform{Architecture: "amd64", OS: "linux"}, Annotations: map[string]string{"a": "b"}})
+	assert.Error(t, err)
+}
+
+func TestListConversions(t *testing.T) {
+	for _, c := range []struct {
+		path     string
+		mimeType string
+	}{
+		{"v2list.manifest.json", DockerV2ListMediaType},
+		{"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex},
+	} {
+		blob, err := ioutil.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		list, err := ListFromBlob(blob, c.mimeType)
+		require.NoError(t, err, c.path)
+
+		for _, target := range []string{DockerV2ListMediaType, imgspecv1.MediaTypeImageIndex} {
+			converted, err := list.ConvertToMIMEType(target)
+			require.NoError(t, err, "%s → %s", c.path, target)
+			assert.Equal(t, target, converted.MIMEType())
+			serialized, err := converted.Serialize()
+			require.NoError(t, err)
+			reparsed, err := ListFromBlob(serialized, target)
+			require.NoError(t, err)
+			require.Len(t, reparsed.Instances(), len(list.Instances()))
+			for i, instance := range reparsed.Instances() {
+				original := list.Instances()[i]
+				assert.Equal(t, original.Digest, instance.Digest)
+				assert.Equal(t, original.Size, instance.Size)
+				assert.Equal(t, original.MediaType, instance.MediaType)
+				assert.Equal(t, original.Platform, instance.Platform)
+			}
+		}
+		_, err = list.ConvertToMIMEType(DockerV2Schema2MediaType)
+		assert.Error(t, err, c.path)
+	}
+
+	// Converting to a Docker manifest list requires platforms
+	index := OCI1IndexFromComponents([]imgspecv1.Descriptor{{MediaType: imgspecv1.MediaTypeImageManifest, Size: 1,
+		Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"}}, nil)
+	_, err := index.ConvertToMIMEType(DockerV2ListMediaType)
+	assert.Error(t, err)
+}
+
+func TestListChooseInstance(t *testing.T) {
+	for _, c := range []struct {
+		path        string
+		mimeType    string
+		matches     map[string]digest.Digest
+		unmatchedOS string
+	}{
+		{
+			"schema2list.json", DockerV2ListMediaType,
+			map[string]digest.Digest{
+				"amd64": "sha256:030fcb92e1487b18c974784dcc110a93147c9fc402188370fbfd17efabffc6af",
+				"s390x": "sha256:e5aa1b0a24620228b75382997a0977f609b3ca3a95533dafdef84c74cc8df642",
+				// There are several "arm" images with different variants; v7 is the default.
+				"arm":   "sha256:a8fe0549cac196f439de3bf2b57af14f7cd4e59915ccd524428f588628a4ef31",
+				"arm64": "sha256:dc472a59fb006797aa2a6bfb54cc9c57959bb0a6d11fadaa608df8c16dea39cf",
+			},
+			"Unmatched",
+		},
+		{
+			"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex,
+			map[string]digest.Digest{
+				"amd64":   "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+				"ppc64le": "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+			},
+			"Unmatched",
+		},
+		{
+			"v2list.manifest.json", DockerV2ListMediaType,
+			map[string]digest.Digest{
+				// The "armv7" and "armv8" variant spellings are also accepted.
+				"arm":   "sha256:07ebe243465ef4a667b78154ae6c3ea46fdb1582936aac3ac899ea311a701b40",
+				"arm64": "sha256:fb2fc0707b86dafa9959fe3d29e66af8787aee4d9a23581714be65db4265ad8a",
+			},
+			"Unmatched",
+		},
+	} {
+		blob, err := ioutil.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		list, err := ListFromBlob(blob, c.mimeType)
+		require.NoError(t, err, c.path)
+		// Match found
+		for arch, expected := range c.matches {
+			d, err := list.ChooseInstance(&types.SystemContext{
+				ArchitectureChoice: arch,
+				OSChoice:           "linux",
+			})
+			require.NoError(t, err, arch)
+			assert.Equal(t, expected, d)
+		}
+		// Not found
+		_, err = list.ChooseInstance(&types.SystemContext{OSChoice: c.unmatchedOS})
+		assert.Error(t, err, c.path)
+	}
+}
+
+func TestListChooseInstanceConverted(t *testing.T) {
+	// An OCI index converted from a Docker list contains Docker schema2 instances; they can be chosen as well.
+	blob, err := ioutil.ReadFile(filepath.Join("fixtures", "schema2list.json"))
+	require.NoError(t, err)
+	list, err := ListFromBlob(blob, DockerV2ListMediaType)
+	require.NoError(t, err)
+	index, err := list.ConvertToMIMEType(imgspecv1.MediaTypeImageIndex)
+	require.NoError(t, err)
+	require.Equal(t, imgspecv1.MediaTypeImageIndex, index.MIMEType())
+	d, err := index.ChooseInstance(&types.SystemContext{
+		ArchitectureChoice: "amd64",
+		OSChoice:           "linux",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("sha256:030fcb92e1487b18c974784dcc110a93147c9fc402188370fbfd17efabffc6af"), d)
+	_, err = index.ChooseInstance(&types.SystemContext{OSChoice: "Unmatched"})
+	assert.Error(t, err)
+}
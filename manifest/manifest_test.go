@@ -131,6 +131,8 @@ func TestMIMETypeIsMultiImage(t *testing.T) {
 		expected bool
 	}{
 		{DockerV2ListMediaType, true},
+		{imgspecv1.MediaTypeImageIndex, true},
+		{imgspecv1.MediaTypeImageManifest, false},
 		{DockerV2Schema1MediaType, false},
 		{DockerV2Schema1SignedMediaType, false},
 		{DockerV2Schema2MediaType, false},
@@ -147,6 +149,7 @@ func TestNormalizedMIMEType(t *testing.T) {
 		DockerV2Schema2MediaType,
 		DockerV2ListMediaType,
 		imgspecv1.MediaTypeImageManifest,
+		imgspecv1.MediaTypeImageIndex,
 	} {
 		res := NormalizedMIMEType(c)
 		assert.Equal(t, c, res, c)
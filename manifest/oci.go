@@ -3,6 +3,7 @@ package manifest
 import (
 	"encoding/json"
 
+	"github.com/containers/image/pkg/compression"
 	"github.com/containers/image/types"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
@@ -81,7 +82,11 @@ func (m *OCI1) UpdateLayerInfos(layerInfos []types.BlobInfo) error {
 	original := m.Layers
 	m.Layers = make([]imgspecv1.Descriptor, len(layerInfos))
 	for i, info := range layerInfos {
-		m.Layers[i].MediaType = original[i].MediaType
+		mimeType, err := updatedOCI1LayerMIMEType(original[i].MediaType, info)
+		if err != nil {
+			return errors.Wrapf(err, "Error preparing updated manifest, layer %q", info.Digest)
+		}
+		m.Layers[i].MediaType = mimeType
 		m.Layers[i].Digest = info.Digest
 		m.Layers[i].Size = info.Size
 		m.Layers[i].Annotations = info.Annotations
@@ -90,6 +95,60 @@ func (m *OCI1) UpdateLayerInfos(layerInfos []types.BlobInfo) error {
 	return nil
 }
 
+// oci1LayerMIMETypes maps the uncompressed OCI layer MIME types to their compressed variants, indexed by compression algorithm name.
+var oci1LayerMIMETypes = map[string]map[string]string{
+	imgspecv1.MediaTypeImageLayer: {
+		compression.Gzip.Name(): imgspecv1.MediaTypeImageLayerGzip,
+		compression.Zstd.Name(): OCI1LayerZstdMediaType,
+	},
+	imgspecv1.MediaTypeImageLayerNonDistributable: {
+		compression.Gzip.Name(): imgspecv1.MediaTypeImageLayerNonDistributableGzip,
+		compression.Zstd.Name(): OCI1NonDistributableLayerZstdMediaType,
+	},
+}
+
+// updatedOCI1LayerMIMEType returns the MIME type of a layer originally using mimeType, after applying info.CompressionOperation.
+func updatedOCI1LayerMIMEType(mimeType string, info types.BlobInfo) (string, error) {
+	if info.CompressionOperation == types.PreserveOriginal {
+		return mimeType, nil
+	}
+	uncompressedType := ""
+	for base, variants := range oci1LayerMIMETypes {
+		if mimeType == base {
+			uncompressedType = base
+			break
+		}
+		for _, t := range variants {
+			if mimeType == t {
+				uncompressedType = base
+				break
+			}
+		}
+		if uncompressedType != "" {
+			break
+		}
+	}
+	if uncompressedType == "" {
+		return "", errors.Errorf("unsupported MIME type %q for a (de)compressed layer", mimeType)
+	}
+
+	switch info.CompressionOperation {
+	case types.Decompress:
+		return uncompressedType, nil
+	case types.Compress:
+		if info.CompressionAlgorithm == nil {
+			return "", errors.New("Internal error: no compression algorithm set for a compressed layer")
+		}
+		compressedType, ok := oci1LayerMIMETypes[uncompressedType][info.CompressionAlgorithm.Name()]
+		if !ok {
+			return "", errors.Errorf("%s compression is not supported for OCI layers", info.CompressionAlgorithm.Name())
+		}
+		return compressedType, nil
+	default:
+		return "", errors.Errorf("Internal error: unexpected CompressionOperation value %#v", info.CompressionOperation)
+	}
+}
+
 // Serialize returns the manifest in a blob format.
 // NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
 func (m *OCI1) Serialize() ([]byte, error) {
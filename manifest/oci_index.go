@@ -0,0 +1,238 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// OCI1Index is just an alias for the OCI index type, but one which we can
+// provide methods for.
+type OCI1Index struct {
+	imgspecv1.Index
+}
+
+// OCI1IndexFromManifest creates an OCI1 manifest index instance from marshalled JSON, presumably generated by encoding an OCI1 manifest index.
+func OCI1IndexFromManifest(manifest []byte) (*OCI1Index, error) {
+	index := OCI1Index{
+		Index: imgspecv1.Index{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			Manifests:   []imgspecv1.Descriptor{},
+			Annotations: make(map[string]string),
+		},
+	}
+	if err := json.Unmarshal(manifest, &index); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing OCI image index")
+	}
+	return &index, nil
+}
+
+// OCI1IndexFromComponents creates an OCI1 image index instance from the supplied data.
+func OCI1IndexFromComponents(components []imgspecv1.Descriptor, annotations map[string]string) *OCI1Index {
+	index := OCI1Index{
+		imgspecv1.Index{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			Manifests:   make([]imgspecv1.Descriptor, len(components)),
+			Annotations: dupStringStringMap(annotations),
+		},
+	}
+	for i, component := range components {
+		index.Manifests[i] = oci1DescriptorClone(component)
+	}
+	return &index
+}
+
+// OCI1IndexClone creates a deep copy of the passed-in index.
+func OCI1IndexClone(index *OCI1Index) *OCI1Index {
+	return OCI1IndexFromComponents(index.Manifests, index.Annotations)
+}
+
+// oci1DescriptorClone returns a deep copy of d.
+func oci1DescriptorClone(d imgspecv1.Descriptor) imgspecv1.Descriptor {
+	clone := d
+	clone.URLs = dupStringSlice(d.URLs)
+	clone.Annotations = dupStringStringMap(d.Annotations)
+	if d.Platform != nil {
+		platform := *d.Platform
+		platform.OSFeatures = dupStringSlice(d.Platform.OSFeatures)
+		clone.Platform = &platform
+	}
+	return clone
+}
+
+// MIMEType returns the MIME type of this particular manifest index.
+func (index *OCI1Index) MIMEType() string {
+	return imgspecv1.MediaTypeImageIndex
+}
+
+// Instances returns a list of the manifests that this index knows of, in order.
+func (index *OCI1Index) Instances() []ListInstance {
+	instances := make([]ListInstance, len(index.Manifests))
+	for i, m := range index.Manifests {
+		instances[i] = oci1ListInstance(m)
+	}
+	return instances
+}
+
+// oci1ListInstance returns a ListInstance describing m.
+func oci1ListInstance(m imgspecv1.Descriptor) ListInstance {
+	clone := oci1DescriptorClone(m)
+	return ListInstance{
+		Digest:      clone.Digest,
+		Size:        clone.Size,
+		MediaType:   clone.MediaType,
+		Platform:    clone.Platform,
+		Annotations: clone.Annotations,
+	}
+}
+
+// Instance returns information about the first instance with digest instanceDigest.
+func (index *OCI1Index) Instance(instanceDigest digest.Digest) (ListInstance, error) {
+	for _, m := range index.Manifests {
+		if m.Digest == instanceDigest {
+			return oci1ListInstance(m), nil
+		}
+	}
+	return ListInstance{}, errors.Errorf("unable to find instance %s in OCI image index", instanceDigest)
+}
+
+// ChooseInstance returns the digest of the instance most appropriate for the platform specified in sys
+// (or the current system, if sys does not specify one), preferring exact matches over compatible ones; see WantedPlatforms.
+// Nested indexes are never chosen; other instances, e.g. Docker schema2 manifests in an index converted from a Docker list, may be.
+func (index *OCI1Index) ChooseInstance(sys *types.SystemContext) (digest.Digest, error) {
+	platforms := make([]*imgspecv1.Platform, 0, len(index.Manifests))
+	for _, d := range index.Manifests {
+		if MIMETypeIsMultiImage(d.MediaType) {
+			platforms = append(platforms, nil)
+		} else {
+			platforms = append(platforms, d.Platform)
+		}
+	}
+	i, err := chooseInstance(sys, platforms, "image index")
+	if err != nil {
+		return "", err
+	}
+	return index.Manifests[i].Digest, nil
+}
+
+// AddInstance adds instance at the end of the index.
+func (index *OCI1Index) AddInstance(instance ListInstance) error {
+	index.Manifests = append(index.Manifests, oci1DescriptorClone(imgspecv1.Descriptor{
+		MediaType:   instance.MediaType,
+		Size:        instance.Size,
+		Digest:      instance.Digest,
+		Platform:    instance.Platform,
+		Annotations: instance.Annotations,
+	}))
+	return nil
+}
+
+// RemoveInstance removes all instances with digest instanceDigest.
+func (index *OCI1Index) RemoveInstance(instanceDigest digest.Digest) error {
+	manifests := []imgspecv1.Descriptor{}
+	for _, m := range index.Manifests {
+		if m.Digest != instanceDigest {
+			manifests = append(manifests, m)
+		}
+	}
+	if len(manifests) == len(index.Manifests) {
+		return errors.Errorf("unable to find instance %s in OCI image index", instanceDigest)
+	}
+	index.Manifests = manifests
+	return nil
+}
+
+// UpdateInstances updates the digests, sizes and MIME types of the instances, in order;
+// updates must contain exactly one entry for each instance.
+func (index *OCI1Index) UpdateInstances(updates []ListUpdate) error {
+	if len(updates) != len(index.Manifests) {
+		return errors.Errorf("incorrect number of update entries passed to OCI1Index.UpdateInstances: expected %d, got %d", len(index.Manifests), len(updates))
+	}
+	for i := range updates {
+		if err := updates[i].Digest.Validate(); err != nil {
+			return errors.Wrapf(err, "update %d of %d passed to OCI1Index.UpdateInstances contained an invalid digest", i+1, len(updates))
+		}
+		index.Manifests[i].Digest = updates[i].Digest
+		if updates[i].Size < 0 {
+			return errors.Errorf("update %d of %d passed to OCI1Index.UpdateInstances had an invalid size (%d)", i+1, len(updates), updates[i].Size)
+		}
+		index.Manifests[i].Size = updates[i].Size
+		if updates[i].MediaType == "" {
+			return errors.Errorf("update %d of %d passed to OCI1Index.UpdateInstances had no media type", i+1, len(updates))
+		}
+		index.Manifests[i].MediaType = updates[i].MediaType
+	}
+	return nil
+}
+
+// Serialize returns the index in a blob format.
+// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+func (index *OCI1Index) Serialize() ([]byte, error) {
+	buf, err := json.Marshal(index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling %#v", index)
+	}
+	return buf, nil
+}
+
+// ConvertToMIMEType returns the index converted to the specified list MIME type, or an error if the conversion
+// is not possible.  The instances are not converted; they keep their original MIME types.
+func (index *OCI1Index) ConvertToMIMEType(mimeType string) (List, error) {
+	switch NormalizedMIMEType(mimeType) {
+	case DockerV2ListMediaType:
+		return index.ToSchema2List()
+	case imgspecv1.MediaTypeImageIndex:
+		return index.Clone(), nil
+	default:
+		return nil, fmt.Errorf("Can not convert image index to MIME type %q, which is not a list type", mimeType)
+	}
+}
+
+// ToSchema2List returns the index encoded as a Docker manifest list.
+// All instances must specify a platform; annotations, which Docker manifest lists do not support, are dropped.
+func (index *OCI1Index) ToSchema2List() (*Schema2List, error) {
+	components := make([]Schema2ManifestDescriptor, 0, len(index.Manifests))
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			return nil, errors.Errorf("Can not convert image index to a Docker manifest list: instance %s does not specify a platform", m.Digest)
+		}
+		components = append(components, Schema2ManifestDescriptor{
+			Schema2Descriptor: Schema2Descriptor{
+				MediaType: m.MediaType,
+				Size:      m.Size,
+				Digest:    m.Digest,
+				URLs:      m.URLs,
+			},
+			Platform: Schema2PlatformSpec{
+				Architecture: m.Platform.Architecture,
+				OS:           m.Platform.OS,
+				OSVersion:    m.Platform.OSVersion,
+				OSFeatures:   m.Platform.OSFeatures,
+				Variant:      m.Platform.Variant,
+			},
+		})
+	}
+	return Schema2ListFromComponents(components), nil
+}
+
+// Clone returns a deep copy of this index.
+func (index *OCI1Index) Clone() List {
+	return OCI1IndexClone(index)
+}
+
+// dupStringStringMap returns a copy of m, preserving nil values.
+func dupStringStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCI1UpdateLayerInfosCompression(t *testing.T) {
+	gzip := compression.Gzip
+	zstd := compression.Zstd
+	bzip2 := compression.Bzip2
+	for _, c := range []struct {
+		original  string
+		operation types.LayerCompression
+		algorithm *compression.Algorithm
+		expected  string // "" if an error is expected
+	}{
+		{imgspecv1.MediaTypeImageLayerGzip, types.PreserveOriginal, nil, imgspecv1.MediaTypeImageLayerGzip},
+		{"this is not a layer MIME type", types.PreserveOriginal, nil, "this is not a layer MIME type"},
+		{imgspecv1.MediaTypeImageLayer, types.Compress, &gzip, imgspecv1.MediaTypeImageLayerGzip},
+		{imgspecv1.MediaTypeImageLayer, types.Compress, &zstd, OCI1LayerZstdMediaType},
+		{imgspecv1.MediaTypeImageLayerGzip, types.Compress, &zstd, OCI1LayerZstdMediaType},
+		{imgspecv1.MediaTypeImageLayerNonDistributable, types.Compress, &gzip, imgspecv1.MediaTypeImageLayerNonDistributableGzip},
+		{imgspecv1.MediaTypeImageLayerNonDistributable, types.Compress, &zstd, OCI1NonDistributableLayerZstdMediaType},
+		{imgspecv1.MediaTypeImageLayerGzip, types.Decompress, nil, imgspecv1.MediaTypeImageLayer},
+		{OCI1LayerZstdMediaType, types.Decompress, nil, imgspecv1.MediaTypeImageLayer},
+		{OCI1NonDistributableLayerZstdMediaType, types.Decompress, nil, imgspecv1.MediaTypeImageLayerNonDistributable},
+		{imgspecv1.MediaTypeImageLayer, types.Compress, nil, ""},
+		{imgspecv1.MediaTypeImageLayer, types.Compress, &bzip2, ""},
+		{"this is not a layer MIME type", types.Compress, &gzip, ""},
+		{"this is not a layer MIME type", types.Decompress, nil, ""},
+	} {
+		m := OCI1FromComponents(imgspecv1.Descriptor{}, []imgspecv1.Descriptor{{MediaType: c.original}})
+		err := m.UpdateLayerInfos([]types.BlobInfo{{
+			Digest:               "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb",
+			Size:                 1,
+			CompressionOperation: c.operation,
+			CompressionAlgorithm: c.algorithm,
+		}})
+		if c.expected == "" {
+			assert.Error(t, err, c.original)
+		} else {
+			require.NoError(t, err, c.original)
+			assert.Equal(t, c.expected, m.Layers[0].MediaType, c.original)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// platformVariantCompatibility lists, for architectures with variants, the variants in decreasing order of capability:
+// a system supporting one of the variants can also run images built for any of the following ones.
+var platformVariantCompatibility = map[string][]string{
+	"amd64": {"v4", "v3", "v2", "v1"},
+	"arm":   {"v8", "v7", "v6", "v5"},
+	"arm64": {"v8"},
+}
+
+// platformDefaultVariants are the variants assumed for architectures if the variant is neither specified nor detected.
+var platformDefaultVariants = map[string]string{
+	"amd64": "v1",
+	"arm":   "v7",
+	"arm64": "v8",
+}
+
+// platformArchitectureFallbacks lists, for architectures which can also run images of another architecture,
+// the variant of the other architecture to use as a fallback after all images of the native architecture.
+var platformArchitectureFallbacks = map[string]struct{ architecture, variant string }{
+	"arm64": {"arm", "v8"},
+}
+
+// WantedPlatforms returns the platforms of images which can be used on the system specified in sys (or the current system,
+// if sys does not specify one), from the most to the least preferred.
+// If sys.OSVersionChoice is set, it is included in all of the returned platforms; images which specify another OS version
+// with the same build are also acceptable, as are (least preferred) images which do not specify an OS version.
+func WantedPlatforms(sys *types.SystemContext) []imgspecv1.Platform {
+	wantedArch := runtime.GOARCH
+	if sys != nil && sys.ArchitectureChoice != "" {
+		wantedArch = sys.ArchitectureChoice
+	}
+	wantedOS := runtime.GOOS
+	if sys != nil && sys.OSChoice != "" {
+		wantedOS = sys.OSChoice
+	}
+	wantedVariant := ""
+	if sys != nil && sys.VariantChoice != "" {
+		wantedVariant = normalizeVariant(sys.VariantChoice)
+	} else if wantedArch == runtime.GOARCH {
+		wantedVariant = cpuVariant()
+	}
+	if wantedVariant == "" {
+		wantedVariant = platformDefaultVariants[wantedArch]
+	}
+	wantedOSVersion := ""
+	if sys != nil {
+		wantedOSVersion = sys.OSVersionChoice
+	}
+
+	res := architecturePlatforms(wantedOS, wantedOSVersion, wantedArch, wantedVariant)
+	if fallback, ok := platformArchitectureFallbacks[wantedArch]; ok {
+		res = append(res, architecturePlatforms(wantedOS, wantedOSVersion, fallback.architecture, fallback.variant)...)
+	}
+	return res
+}
+
+// architecturePlatforms returns the platforms of arch compatible with variant, from the most to the least preferred:
+// the exact variant, less capable variants, and finally images which do not specify a variant.
+func architecturePlatforms(osName, osVersion, arch, variant string) []imgspecv1.Platform {
+	variants := []string{variant}
+	for i, v := range platformVariantCompatibility[arch] {
+		if v == variant {
+			variants = append([]string{}, platformVariantCompatibility[arch][i:]...)
+			break
+		}
+	}
+	if variant != "" {
+		variants = append(variants, "")
+	}
+	res := make([]imgspecv1.Platform, 0, len(variants))
+	for _, v := range variants {
+		res = append(res, imgspecv1.Platform{Architecture: arch, OS: osName, OSVersion: osVersion, Variant: v})
+	}
+	return res
+}
+
+// chooseInstance returns the index of the most appropriate of platforms (which may contain nil values for instances that
+// can never be chosen) for the system specified in sys, or an error mentioning listKind if none can be used.
+// Among equally appropriate instances, the first one is chosen.
+func chooseInstance(sys *types.SystemContext, platforms []*imgspecv1.Platform, listKind string) (int, error) {
+	wanted := WantedPlatforms(sys)
+	bestIndex, bestRank := -1, -1
+	for i, p := range platforms {
+		if p == nil {
+			continue
+		}
+		if rank := platformRank(wanted, p); rank != -1 && (bestIndex == -1 || rank < bestRank) {
+			bestIndex, bestRank = i, rank
+		}
+	}
+	if bestIndex == -1 {
+		w := wanted[0]
+		if w.Variant != "" {
+			return -1, fmt.Errorf("no image found in %s for architecture %s, variant %s, OS %s", listKind, w.Architecture, w.Variant, w.OS)
+		}
+		return -1, fmt.Errorf("no image found in %s for architecture %s, OS %s", listKind, w.Architecture, w.OS)
+	}
+	return bestIndex, nil
+}
+
+// platformRank returns the rank of an image for platform p when wanted are the acceptable platforms, as returned by
+// WantedPlatforms: lower values are more preferred, and -1 means that the image can not be used.
+func platformRank(wanted []imgspecv1.Platform, p *imgspecv1.Platform) int {
+	variant := normalizeVariant(p.Variant)
+	for i, w := range wanted {
+		if p.Architecture != w.Architecture || p.OS != w.OS || variant != w.Variant {
+			continue
+		}
+		versionRank := osVersionRank(w.OSVersion, p.OSVersion)
+		if versionRank == -1 {
+			return -1
+		}
+		return i*3 + versionRank
+	}
+	return -1
+}
+
+// osVersionRank returns the rank, between 0 (best) and 2, of an image built for imageOSVersion on a system with systemOSVersion,
+// or -1 if the image can not be used on that system.
+// OS versions are compared on their first three components, i.e. the build number for Windows.
+func osVersionRank(systemOSVersion, imageOSVersion string) int {
+	switch {
+	case systemOSVersion == "" || imageOSVersion == systemOSVersion:
+		return 0
+	case imageOSVersion == "":
+		return 2
+	case osVersionBuild(imageOSVersion) == osVersionBuild(systemOSVersion):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// osVersionBuild returns the first three dot-separated components of osVersion.
+func osVersionBuild(osVersion string) string {
+	components := strings.SplitN(osVersion, ".", 4)
+	if len(components) > 3 {
+		components = components[:3]
+	}
+	return strings.Join(components, ".")
+}
+
+// normalizeVariant returns variant in the "v7" form, also accepting the "armv7" form used by some older manifest lists.
+func normalizeVariant(variant string) string {
+	variant = strings.ToLower(variant)
+	if strings.HasPrefix(variant, "armv") {
+		return variant[len("arm"):]
+	}
+	return variant
+}
+
+// cpuVariant returns the variant of the current system's CPU, or "" if it is not known.
+func cpuVariant() string {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "arm" {
+		return ""
+	}
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		logrus.Debugf("Error reading CPU information: %v", err)
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "CPU architecture" {
+			continue
+		}
+		switch value := strings.TrimSpace(parts[1]); {
+		case value == "8" || value == "AArch64":
+			return "v8"
+		case value == "7":
+			return "v7"
+		case strings.HasPrefix(value, "6"):
+			return "v6"
+		case strings.HasPrefix(value, "5"):
+			return "v5"
+		default:
+			logrus.Debugf("Unrecognized CPU architecture %q", value)
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,218 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWantedPlatforms(t *testing.T) {
+	for _, c := range []struct {
+		sys      types.SystemContext
+		expected []imgspecv1.Platform
+	}{
+		{ // Architectures without variants
+			types.SystemContext{ArchitectureChoice: "s390x", OSChoice: "linux"},
+			[]imgspecv1.Platform{{Architecture: "s390x", OS: "linux"}},
+		},
+		{ // Default variants
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux"},
+			[]imgspecv1.Platform{
+				{Architecture: "arm", OS: "linux", Variant: "v7"},
+				{Architecture: "arm", OS: "linux", Variant: "v6"},
+				{Architecture: "arm", OS: "linux", Variant: "v5"},
+				{Architecture: "arm", OS: "linux"},
+			},
+		},
+		{ // Falling back to another architecture
+			types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+			[]imgspecv1.Platform{
+				{Architecture: "arm64", OS: "linux", Variant: "v8"},
+				{Architecture: "arm64", OS: "linux"},
+				{Architecture: "arm", OS: "linux", Variant: "v8"},
+				{Architecture: "arm", OS: "linux", Variant: "v7"},
+				{Architecture: "arm", OS: "linux", Variant: "v6"},
+				{Architecture: "arm", OS: "linux", Variant: "v5"},
+				{Architecture: "arm", OS: "linux"},
+			},
+		},
+		{ // Explicit variants, including the "armv6" spelling
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "armv6"},
+			[]imgspecv1.Platform{
+				{Architecture: "arm", OS: "linux", Variant: "v6"},
+				{Architecture: "arm", OS: "linux", Variant: "v5"},
+				{Architecture: "arm", OS: "linux"},
+			},
+		},
+		{ // Unknown variants
+			types.SystemContext{ArchitectureChoice: "mips64le", OSChoice: "linux", VariantChoice: "r6"},
+			[]imgspecv1.Platform{
+				{Architecture: "mips64le", OS: "linux", Variant: "r6"},
+				{Architecture: "mips64le", OS: "linux"},
+			},
+		},
+		{ // OS versions
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows", OSVersionChoice: "10.0.17763.194"},
+			[]imgspecv1.Platform{
+				{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17763.194", Variant: "v1"},
+				{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17763.194"},
+			},
+		},
+	} {
+		sys := c.sys
+		assert.Equal(t, c.expected, WantedPlatforms(&sys), "%#v", c.sys)
+	}
+}
+
+func TestChooseInstancePlatforms(t *testing.T) {
+	digests := []digest.Digest{}
+	for _, hex := range []string{"1", "2", "3", "4", "5"} {
+		digests = append(digests, digest.Digest("sha256:"+hex+"000000000000000000000000000000000000000000000000000000000000000"))
+	}
+
+	for _, c := range []struct {
+		name      string
+		platforms []imgspecv1.Platform
+		sys       types.SystemContext
+		expected  int // Index into platforms / digests, or -1 if no instance should be found
+	}{
+		{
+			"arm exact variant",
+			[]imgspecv1.Platform{{Architecture: "arm", OS: "linux", Variant: "v6"}, {Architecture: "arm", OS: "linux", Variant: "v7"}},
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v7"},
+			1,
+		},
+		{
+			"arm older variant",
+			[]imgspecv1.Platform{{Architecture: "arm", OS: "linux", Variant: "v7"}, {Architecture: "arm", OS: "linux", Variant: "v5"}},
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v6"},
+			1,
+		},
+		{
+			"arm newer variant only",
+			[]imgspecv1.Platform{{Architecture: "arm", OS: "linux", Variant: "v7"}},
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v6"},
+			-1,
+		},
+		{
+			"arm without variant",
+			[]imgspecv1.Platform{{Architecture: "arm", OS: "linux"}, {Architecture: "arm", OS: "linux", Variant: "v6"}},
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v7"},
+			1,
+		},
+		{
+			"arm64 prefers native images",
+			[]imgspecv1.Platform{{Architecture: "arm", OS: "linux", Variant: "v7"}, {Architecture: "arm64", OS: "linux"}},
+			types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+			1,
+		},
+		{
+			"arm64 falls back to arm",
+			[]imgspecv1.Platform{{Architecture: "amd64", OS: "linux"}, {Architecture: "arm", OS: "linux", Variant: "v7"}},
+			types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+			1,
+		},
+		{
+			"amd64 exact variant",
+			[]imgspecv1.Platform{{Architecture: "amd64", OS: "linux"}, {Architecture: "amd64", OS: "linux", Variant: "v3"}, {Architecture: "amd64", OS: "linux", Variant: "v2"}},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux", VariantChoice: "v2"},
+			2,
+		},
+		{
+			"amd64 default variant",
+			[]imgspecv1.Platform{{Architecture: "amd64", OS: "linux", Variant: "v3"}, {Architecture: "amd64", OS: "linux"}},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"},
+			1,
+		},
+		{
+			"Windows exact OS version",
+			[]imgspecv1.Platform{
+				{Architecture: "amd64", OS: "windows"},
+				{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17763.100"},
+				{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17763.194"},
+			},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows", OSVersionChoice: "10.0.17763.194"},
+			2,
+		},
+		{
+			"Windows same build",
+			[]imgspecv1.Platform{
+				{Architecture: "amd64", OS: "windows"},
+				{Architecture: "amd64", OS: "windows", OSVersion: "10.0.14393.2608"},
+				{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17763.100"},
+			},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows", OSVersionChoice: "10.0.17763.194"},
+			2,
+		},
+		{
+			"Windows unspecified OS version",
+			[]imgspecv1.Platform{{Architecture: "amd64", OS: "windows", OSVersion: "10.0.14393.2608"}, {Architecture: "amd64", OS: "windows"}},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows", OSVersionChoice: "10.0.17763.194"},
+			1,
+		},
+		{
+			"Windows other build only",
+			[]imgspecv1.Platform{{Architecture: "amd64", OS: "windows", OSVersion: "10.0.14393.2608"}},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows", OSVersionChoice: "10.0.17763.194"},
+			-1,
+		},
+		{
+			"OS version ignored if not specified",
+			[]imgspecv1.Platform{{Architecture: "amd64", OS: "windows", OSVersion: "10.0.14393.2608"}, {Architecture: "amd64", OS: "windows"}},
+			types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows"},
+			0,
+		},
+	} {
+		schema2Components := []Schema2ManifestDescriptor{}
+		ociComponents := []imgspecv1.Descriptor{}
+		for i, p := range c.platforms {
+			schema2Components = append(schema2Components, Schema2ManifestDescriptor{
+				Schema2Descriptor: Schema2Descriptor{MediaType: DockerV2Schema2MediaType, Size: 1, Digest: digests[i]},
+				Platform:          Schema2PlatformSpec{Architecture: p.Architecture, OS: p.OS, OSVersion: p.OSVersion, Variant: p.Variant},
+			})
+			platform := p
+			ociComponents = append(ociComponents, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Size: 1, Digest: digests[i], Platform: &platform})
+		}
+		for _, list := range []List{Schema2ListFromComponents(schema2Components), OCI1IndexFromComponents(ociComponents, nil)} {
+			sys := c.sys
+			d, err := list.ChooseInstance(&sys)
+			if c.expected == -1 {
+				assert.Error(t, err, "%s %s", list.MIMEType(), c.name)
+			} else {
+				require.NoError(t, err, "%s %s", list.MIMEType(), c.name)
+				assert.Equal(t, digests[c.expected], d, "%s %s", list.MIMEType(), c.name)
+			}
+		}
+	}
+
+	// OCI index entries without a platform, or which are not image manifests, are never chosen
+	index := OCI1IndexFromComponents([]imgspecv1.Descriptor{
+		{MediaType: imgspecv1.MediaTypeImageManifest, Size: 1, Digest: digests[0]},
+		{MediaType: imgspecv1.MediaTypeImageIndex, Size: 1, Digest: digests[1], Platform: &imgspecv1.Platform{Architecture: "amd64", OS: "linux"}},
+		{MediaType: imgspecv1.MediaTypeImageManifest, Size: 1, Digest: digests[2], Platform: &imgspecv1.Platform{Architecture: "amd64", OS: "linux"}},
+	}, nil)
+	d, err := index.ChooseInstance(&types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"})
+	require.NoError(t, err)
+	assert.Equal(t, digests[2], d)
+}
+
+func TestOSVersionRank(t *testing.T) {
+	for _, c := range []struct {
+		system, image string
+		expected      int
+	}{
+		{"", "", 0},
+		{"", "10.0.17763.194", 0},
+		{"10.0.17763.194", "10.0.17763.194", 0},
+		{"10.0.17763.194", "10.0.17763.1", 1},
+		{"10.0.17763.194", "10.0.17763", 1},
+		{"10.0.17763.194", "", 2},
+		{"10.0.17763.194", "10.0.14393.194", -1},
+	} {
+		assert.Equal(t, c.expected, osVersionRank(c.system, c.image), "%q, %q", c.system, c.image)
+	}
+}
@@ -2,7 +2,6 @@ package layout
 
 import (
 	"context"
-	"encoding/json"
 	"io"
 	"io/ioutil"
 	"os"
@@ -19,29 +18,22 @@ import (
 
 type ociImageDestination struct {
 	ref                      ociReference
-	index                    imgspecv1.Index
+	manifests                []imgspecv1.Descriptor // Descriptors to add to index.json on Commit
 	sharedBlobDir            string
 	acceptUncompressedLayers bool
+	unlockBlobs              func() // Releases the shared lock preventing GarbageCollect from removing our blobs
 }
 
 // newImageDestination returns an ImageDestination for writing to an existing directory.
 func newImageDestination(sys *types.SystemContext, ref ociReference) (types.ImageDestination, error) {
-	var index *imgspecv1.Index
 	if indexExists(ref) {
-		var err error
-		index, err = ref.getIndex()
-		if err != nil {
+		// Fail early if the index is unusable, instead of only in Commit.
+		if _, err := ref.getIndex(); err != nil {
 			return nil, err
 		}
-	} else {
-		index = &imgspecv1.Index{
-			Versioned: imgspec.Versioned{
-				SchemaVersion: 2,
-			},
-		}
 	}
 
-	d := &ociImageDestination{ref: ref, index: *index}
+	d := &ociImageDestination{ref: ref}
 	if sys != nil {
 		d.sharedBlobDir = sys.OCISharedBlobDirPath
 		d.acceptUncompressedLayers = sys.OCIAcceptUncompressedLayers
@@ -56,6 +48,16 @@ func newImageDestination(sys *types.SystemContext, ref ociReference) (types.Imag
 	if err := ensureDirectoryExists(filepath.Join(d.ref.dir, "blobs")); err != nil {
 		return nil, err
 	}
+	blobDir := d.ref.blobDir(d.sharedBlobDir)
+	if err := ensureDirectoryExists(blobDir); err != nil {
+		return nil, err
+	}
+	// Blobs we write are not referenced from index.json until Commit; make sure GarbageCollect does not remove them meanwhile.
+	unlock, err := lockDirectory(blobDir, false)
+	if err != nil {
+		return nil, err
+	}
+	d.unlockBlobs = unlock
 	return d, nil
 }
 
@@ -67,12 +69,14 @@ func (d *ociImageDestination) Reference() types.ImageReference {
 
 // Close removes resources associated with an initialized ImageDestination, if any.
 func (d *ociImageDestination) Close() error {
+	d.unlockBlobs()
 	return nil
 }
 
 func (d *ociImageDestination) SupportedManifestMIMETypes() []string {
 	return []string{
 		imgspecv1.MediaTypeImageManifest,
+		imgspecv1.MediaTypeImageIndex,
 	}
 }
 
@@ -206,19 +210,25 @@ func (d *ociImageDestination) TryReusingBlob(ctx context.Context, info types.Blo
 }
 
 // PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
 // FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
 // If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 // but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
-func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte) error {
+func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	var mediaType string
+	switch manifest.GuessMIMEType(m) {
+	case imgspecv1.MediaTypeImageIndex:
+		mediaType = imgspecv1.MediaTypeImageIndex
+	case manifest.DockerV2ListMediaType:
+		return types.ManifestTypeRejectedError{Err: errors.New(`Docker manifest lists are not supported by "oci:"`)}
+	default:
+		mediaType = imgspecv1.MediaTypeImageManifest
+	}
 	digest, err := manifest.Digest(m)
 	if err != nil {
 		return err
 	}
-	desc := imgspecv1.Descriptor{}
-	desc.Digest = digest
-	// TODO(runcom): beaware and add support for OCI manifest list
-	desc.MediaType = imgspecv1.MediaTypeImageManifest
-	desc.Size = int64(len(m))
 
 	blobPath, err := d.ref.blobPath(digest, d.sharedBlobDir)
 	if err != nil {
@@ -231,32 +241,52 @@ func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte) error {
 		return err
 	}
 
+	if instanceDigest != nil {
+		// An instance of an image index is only referenced from the index, not from index.json.
+		return nil
+	}
+
+	desc := imgspecv1.Descriptor{}
+	desc.Digest = digest
+	desc.MediaType = mediaType
+	desc.Size = int64(len(m))
 	if d.ref.image != "" {
 		annotations := make(map[string]string)
 		annotations["org.opencontainers.image.ref.name"] = d.ref.image
 		desc.Annotations = annotations
 	}
-	desc.Platform = &imgspecv1.Platform{
-		Architecture: runtime.GOARCH,
-		OS:           runtime.GOOS,
+	if mediaType == imgspecv1.MediaTypeImageManifest {
+		desc.Platform = &imgspecv1.Platform{
+			Architecture: runtime.GOARCH,
+			OS:           runtime.GOOS,
+		}
 	}
 	d.addManifest(&desc)
 
 	return nil
 }
 
+// addManifest records desc to be added to index.json on Commit, replacing a previously recorded descriptor with the same name.
 func (d *ociImageDestination) addManifest(desc *imgspecv1.Descriptor) {
-	for i, manifest := range d.index.Manifests {
+	d.manifests = addManifestToIndex(d.manifests, desc)
+}
+
+// addManifestToIndex returns manifests with desc added, replacing an existing descriptor with the same name.
+func addManifestToIndex(manifests []imgspecv1.Descriptor, desc *imgspecv1.Descriptor) []imgspecv1.Descriptor {
+	for i, manifest := range manifests {
 		if manifest.Annotations["org.opencontainers.image.ref.name"] == desc.Annotations["org.opencontainers.image.ref.name"] {
 			// TODO Should there first be a cleanup based on the descriptor we are going to replace?
-			d.index.Manifests[i] = *desc
-			return
+			manifests[i] = *desc
+			return manifests
 		}
 	}
-	d.index.Manifests = append(d.index.Manifests, *desc)
+	return append(manifests, *desc)
 }
 
-func (d *ociImageDestination) PutSignatures(ctx context.Context, signatures [][]byte) error {
+// PutSignatures writes a set of signatures to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write or overwrite the signatures for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+func (d *ociImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
 	if len(signatures) != 0 {
 		return errors.Errorf("Pushing signatures for OCI images is not supported")
 	}
@@ -271,11 +301,28 @@ func (d *ociImageDestination) Commit(ctx context.Context) error {
 	if err := ioutil.WriteFile(d.ref.ociLayoutPath(), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644); err != nil {
 		return err
 	}
-	indexJSON, err := json.Marshal(d.index)
+
+	// Other destinations, or DeleteImage, may have modified index.json since we were created;
+	// apply our changes to the current version.
+	unlock, err := lockDirectory(d.ref.dir, true)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(d.ref.indexPath(), indexJSON, 0644)
+	defer unlock()
+	index := &imgspecv1.Index{
+		Versioned: imgspec.Versioned{
+			SchemaVersion: 2,
+		},
+	}
+	if indexExists(d.ref) {
+		if index, err = d.ref.getIndex(); err != nil {
+			return err
+		}
+	}
+	for i := range d.manifests {
+		index.Manifests = addManifestToIndex(index.Manifests, &d.manifests[i])
+	}
+	return d.ref.putIndex(index)
 }
 
 func ensureDirectoryExists(path string) error {
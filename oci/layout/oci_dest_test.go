@@ -2,12 +2,16 @@ package layout
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/pkg/blobinfocache"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -104,12 +108,95 @@ func TestPutManifestTwice(t *testing.T) {
 	assert.Equal(t, 1, len(index.Manifests), "Unexpected number of manifests")
 }
 
+// TestPutManifestIndex tests that image indexes and their instances can be written and read back.
+func TestPutManifestIndex(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	ref, err := NewReference(tmpDir, "multi")
+	require.NoError(t, err)
+
+	instance, err := ioutil.ReadFile("../../manifest/fixtures/ociv1.manifest.json")
+	require.NoError(t, err)
+	instanceDigest := digest.FromBytes(instance)
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"mediaType":"%s","size":%d,"digest":"%s","platform":{"architecture":"amd64","os":"linux"}}]}`,
+		imgspecv1.MediaTypeImageManifest, len(instance), instanceDigest))
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	assert.Contains(t, dest.SupportedManifestMIMETypes(), imgspecv1.MediaTypeImageIndex)
+	err = dest.PutManifest(context.Background(), instance, &instanceDigest)
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), index, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	// Only the index is referenced from index.json
+	ociIndex, err := ref.(ociReference).getIndex()
+	require.NoError(t, err)
+	require.Len(t, ociIndex.Manifests, 1)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, ociIndex.Manifests[0].MediaType)
+	assert.Equal(t, digest.FromBytes(index), ociIndex.Manifests[0].Digest)
+	assert.Nil(t, ociIndex.Manifests[0].Platform)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, index, m)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, mimeType)
+	m, mimeType, err = src.GetManifest(context.Background(), &instanceDigest)
+	require.NoError(t, err)
+	assert.Equal(t, instance, m)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+
+	// Docker manifest lists are rejected
+	list, err := ioutil.ReadFile("../../manifest/fixtures/v2list.manifest.json")
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), list, nil)
+	assert.IsType(t, types.ManifestTypeRejectedError{}, err)
+}
+
+// TestCommitConcurrentDestinations tests that destinations opened at the same time do not overwrite each other's index.json changes.
+func TestCommitConcurrentDestinations(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+
+	dests := []types.ImageDestination{}
+	for _, name := range []string{"first", "second"} {
+		ref, err := NewReference(tmpDir, name)
+		require.NoError(t, err)
+		dest, err := ref.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+		defer dest.Close()
+		err = dest.PutManifest(context.Background(), []byte(name), nil)
+		require.NoError(t, err)
+		dests = append(dests, dest)
+	}
+	for _, dest := range dests {
+		err := dest.Commit(context.Background())
+		require.NoError(t, err)
+	}
+
+	index, err := ref.(ociReference).getIndex()
+	require.NoError(t, err)
+	names := []string{}
+	for _, desc := range index.Manifests {
+		names = append(names, desc.Annotations["org.opencontainers.image.ref.name"])
+	}
+	assert.Equal(t, []string{"imageValue", "first", "second"}, names)
+}
+
 func putTestManifest(t *testing.T, ociRef ociReference, tmpDir string) {
 	imageDest, err := newImageDestination(nil, ociRef)
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	defer imageDest.Close()
 
 	data := []byte("abc")
-	err = imageDest.PutManifest(context.Background(), data)
+	err = imageDest.PutManifest(context.Background(), data, nil)
 	assert.NoError(t, err)
 
 	err = imageDest.Commit(context.Background())
@@ -0,0 +1,160 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// GarbageCollect removes blobs which are not reachable from index.json of any of the OCI layouts in dirs,
+// and returns the digests of the removed blobs.
+//
+// If sys.OCISharedBlobDirPath is set, blobs are removed from that directory instead of from the layouts;
+// in that case dirs must list ALL layouts using the shared blob directory, otherwise blobs used by other layouts will be removed.
+//
+// Writes to the affected blob directories using this package are blocked while GarbageCollect runs, and GarbageCollect
+// waits for any such writes in progress to be closed, so that blobs which are not yet referenced from index.json are not removed.
+func GarbageCollect(ctx context.Context, sys *types.SystemContext, dirs ...string) ([]digest.Digest, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("No OCI layouts to garbage-collect specified")
+	}
+	refs := []ociReference{}
+	for _, dir := range dirs {
+		ref, err := NewReference(dir, "")
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref.(ociReference))
+	}
+
+	if sys != nil && sys.OCISharedBlobDirPath != "" {
+		return garbageCollectBlobDir(ctx, sys.OCISharedBlobDirPath, refs)
+	}
+	removed := []digest.Digest{}
+	for _, ref := range refs {
+		r, err := garbageCollectBlobDir(ctx, ref.blobDir(""), []ociReference{ref})
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, r...)
+	}
+	return removed, nil
+}
+
+// garbageCollectBlobDir removes blobs in blobDir which are not reachable from any of refs, and returns their digests.
+func garbageCollectBlobDir(ctx context.Context, blobDir string, refs []ociReference) ([]digest.Digest, error) {
+	unlock, err := lockDirectory(blobDir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	reachable := map[digest.Digest]struct{}{}
+	for _, ref := range refs {
+		index, err := ref.getIndex()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "error reading index of %s", ref.dir)
+		}
+		for _, desc := range index.Manifests {
+			if err := markReachable(blobDir, desc, reachable); err != nil {
+				return nil, errors.Wrapf(err, "error reading images in %s", ref.dir)
+			}
+		}
+	}
+
+	removed := []digest.Digest{}
+	algorithms, err := ioutil.ReadDir(blobDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		blobs, err := ioutil.ReadDir(filepath.Join(blobDir, algorithm.Name()))
+		if err != nil {
+			return removed, err
+		}
+		for _, blob := range blobs {
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+			d := digest.NewDigestFromHex(algorithm.Name(), blob.Name())
+			if d.Validate() != nil {
+				logrus.Debugf("Ignoring unexpected file %s in %s", blob.Name(), filepath.Join(blobDir, algorithm.Name()))
+				continue
+			}
+			if _, ok := reachable[d]; ok {
+				continue
+			}
+			logrus.Debugf("Removing unreachable blob %s", d.String())
+			if err := os.Remove(filepath.Join(blobDir, algorithm.Name(), blob.Name())); err != nil {
+				return removed, err
+			}
+			removed = append(removed, d)
+		}
+	}
+	return removed, nil
+}
+
+// markReachable adds desc, and all blobs referenced from it if it is a manifest or an index, to reachable.
+func markReachable(blobDir string, desc imgspecv1.Descriptor, reachable map[digest.Digest]struct{}) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return errors.Wrapf(err, "unexpected digest reference %s", desc.Digest)
+	}
+	if _, ok := reachable[desc.Digest]; ok {
+		return nil
+	}
+	reachable[desc.Digest] = struct{}{}
+	switch desc.MediaType {
+	case imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageIndex,
+		manifest.DockerV2Schema2MediaType, manifest.DockerV2ListMediaType:
+	default: // Not a manifest, there is nothing more to do.
+		return nil
+	}
+
+	blob, err := ioutil.ReadFile(filepath.Join(blobDir, desc.Digest.Algorithm().String(), desc.Digest.Hex()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing references blobs from a missing manifest, so there is nothing more to protect.
+			logrus.Debugf("Manifest %s is missing", desc.Digest.String())
+			return nil
+		}
+		return err
+	}
+	// Parse only the fields we need, so that this works for all of the manifest and index formats,
+	// including Docker schema2 manifests stored with the OCI manifest MIME type.
+	var parsed struct {
+		Config    *imgspecv1.Descriptor  `json:"config,omitempty"`
+		Layers    []imgspecv1.Descriptor `json:"layers,omitempty"`
+		Manifests []imgspecv1.Descriptor `json:"manifests,omitempty"`
+	}
+	if err := json.Unmarshal(blob, &parsed); err != nil {
+		return errors.Wrapf(err, "error parsing manifest %s", desc.Digest.String())
+	}
+	children := append(parsed.Layers, parsed.Manifests...)
+	if parsed.Config != nil {
+		children = append(children, *parsed.Config)
+	}
+	for _, child := range children {
+		if child.Digest == "" {
+			continue
+		}
+		if err := markReachable(blobDir, child, reachable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package layout
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/internal/testing/testimage"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putTestImage writes an image with a config and a single layer to dir:name, and returns the digests of all of its blobs.
+func putTestImage(t *testing.T, sys *types.SystemContext, dir, name string, layer []byte) []digest.Digest {
+	ref, err := NewReference(dir, name)
+	require.NoError(t, err)
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","name":%q}`, name))
+	img := testimage.Put(t, sys, ref, imgspecv1.MediaTypeImageManifest, config, layer)
+	return []digest.Digest{digest.FromBytes(img.Manifest), img.Config.Digest, img.Layer.Digest}
+}
+
+// blobsInDir returns the digests of all blobs in blobDir.
+func blobsInDir(t *testing.T, blobDir string) []digest.Digest {
+	res := []digest.Digest{}
+	files, err := ioutil.ReadDir(filepath.Join(blobDir, "sha256"))
+	require.NoError(t, err)
+	for _, f := range files {
+		res = append(res, digest.NewDigestFromHex("sha256", f.Name()))
+	}
+	return res
+}
+
+func TestGarbageCollect(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-gc-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	sharedLayer := []byte("shared layer")
+	first := putTestImage(t, nil, tmpDir, "first", sharedLayer)
+	second := putTestImage(t, nil, tmpDir, "second", sharedLayer)
+	orphan := digest.FromString("orphan")
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "blobs", "sha256", orphan.Hex()), []byte("orphan"), 0644)
+	require.NoError(t, err)
+	unexpected := filepath.Join(tmpDir, "blobs", "sha256", "not-a-digest")
+	err = ioutil.WriteFile(unexpected, []byte{}, 0644)
+	require.NoError(t, err)
+
+	// Only the unreferenced blob is removed
+	removed, err := GarbageCollect(context.Background(), nil, tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, []digest.Digest{orphan}, removed)
+	_, err = os.Stat(unexpected)
+	assert.NoError(t, err)
+	require.NoError(t, os.Remove(unexpected))
+	assert.ElementsMatch(t, []digest.Digest{first[0], first[1], second[0], second[1], first[2]}, blobsInDir(t, filepath.Join(tmpDir, "blobs")))
+
+	// Blobs of a deleted image are removed, unless they are used by another image
+	ref, err := NewReference(tmpDir, "second")
+	require.NoError(t, err)
+	err = ref.DeleteImage(context.Background(), nil)
+	require.NoError(t, err)
+	removed, err = GarbageCollect(context.Background(), nil, tmpDir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []digest.Digest{second[0], second[1]}, removed)
+	assert.ElementsMatch(t, first, blobsInDir(t, filepath.Join(tmpDir, "blobs")))
+
+	// Blobs referenced through an image index are kept
+	indexRef, err := NewReference(tmpDir, "index")
+	require.NoError(t, err)
+	dest, err := indexRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"mediaType":"%s","size":1,"digest":"%s"}]}`, imgspecv1.MediaTypeImageManifest, first[0]))
+	err = dest.PutManifest(context.Background(), index, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	dest.Close()
+	ref, err = NewReference(tmpDir, "first")
+	require.NoError(t, err)
+	err = ref.DeleteImage(context.Background(), nil)
+	require.NoError(t, err)
+	removed, err = GarbageCollect(context.Background(), nil, tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	// No layouts
+	_, err = GarbageCollect(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestGarbageCollectSharedBlobDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-gc-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	sharedBlobDir := filepath.Join(tmpDir, "shared")
+	sys := &types.SystemContext{OCISharedBlobDirPath: sharedBlobDir}
+	dir1 := filepath.Join(tmpDir, "layout1")
+	dir2 := filepath.Join(tmpDir, "layout2")
+
+	image1 := putTestImage(t, sys, dir1, "first", []byte("layer 1"))
+	image2 := putTestImage(t, sys, dir2, "second", []byte("layer 2"))
+	assert.ElementsMatch(t, append(append([]digest.Digest{}, image1...), image2...), blobsInDir(t, sharedBlobDir))
+
+	// All layouts using the shared directory are considered
+	removed, err := GarbageCollect(context.Background(), sys, dir1, dir2)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	ref, err := NewReference(dir2, "second")
+	require.NoError(t, err)
+	err = ref.DeleteImage(context.Background(), sys)
+	require.NoError(t, err)
+	removed, err = GarbageCollect(context.Background(), sys, dir1, dir2)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, image2, removed)
+	assert.ElementsMatch(t, image1, blobsInDir(t, sharedBlobDir))
+}
+
+func TestGarbageCollectConcurrentWriter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-gc-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	ref, err := NewReference(tmpDir, "image")
+	require.NoError(t, err)
+
+	// A blob written, but not yet referenced from index.json, is not removed
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	layer := []byte("layer")
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(layer), types.BlobInfo{Size: -1}, blobinfocache.NewMemoryCache(), false)
+	require.NoError(t, err)
+
+	done := make(chan []digest.Digest)
+	go func() {
+		removed, err := GarbageCollect(context.Background(), nil, tmpDir)
+		assert.NoError(t, err)
+		done <- removed
+	}()
+	select {
+	case <-done:
+		t.Fatal("GarbageCollect did not wait for the destination to be closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	m := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"mediaType":"%s","size":%d,"digest":"%s"},"layers":[]}`,
+		imgspecv1.MediaTypeImageConfig, info.Size, info.Digest))
+	err = dest.PutManifest(context.Background(), m, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	dest.Close()
+
+	removed := <-done
+	assert.Empty(t, removed)
+	assert.ElementsMatch(t, []digest.Digest{digest.FromBytes(m), info.Digest}, blobsInDir(t, filepath.Join(tmpDir, "blobs")))
+}
@@ -0,0 +1,9 @@
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package layout
+
+// lockDirectory would take a lock on the directory at path; on this platform, locking is not implemented,
+// so GarbageCollect is not safe to run concurrently with writers.
+func lockDirectory(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,35 @@
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package layout
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockDirectory takes a flock(2) lock on the directory at path, shared or exclusive, waiting until it is available.
+// The caller must call the returned function to release the lock.
+func lockDirectory(path string, exclusive bool) (func(), error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err = syscall.Flock(int(dir.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		dir.Close()
+		return nil, errors.Wrapf(err, "error locking %s", path)
+	}
+	// Closing the file releases the lock.
+	return func() { dir.Close() }, nil
+}
@@ -2,12 +2,14 @@ package layout
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
 
+	"github.com/containers/image/manifest"
 	"github.com/containers/image/pkg/tlsclientconfig"
 	"github.com/containers/image/types"
 	"github.com/docker/go-connections/tlsconfig"
@@ -70,28 +72,84 @@ func (s *ociImageSource) GetManifest(ctx context.Context, instanceDigest *digest
 		dig = digest.Digest(s.descriptor.Digest)
 		mimeType = s.descriptor.MediaType
 	} else {
+		// The MIME type is determined from the referencing image index below.
 		dig = *instanceDigest
-		// XXX: instanceDigest means that we don't immediately have the context of what
-		//      mediaType the manifest has. In OCI this means that we don't know
-		//      what reference it came from, so we just *assume* that its
-		//      MediaTypeImageManifest.
-		// FIXME: We should actually be able to look up the manifest in the index,
-		// and see the MIME type there.
-		mimeType = imgspecv1.MediaTypeImageManifest
 	}
 
-	manifestPath, err := s.ref.blobPath(dig, s.sharedBlobDir)
+	m, err := s.readManifestBlob(dig)
 	if err != nil {
 		return nil, "", err
 	}
-	m, err := ioutil.ReadFile(manifestPath)
-	if err != nil {
-		return nil, "", err
+	if mimeType == "" {
+		mimeType, err = s.instanceMIMEType(dig, m)
+		if err != nil {
+			return nil, "", err
+		}
 	}
 
 	return m, mimeType, nil
 }
 
+// readManifestBlob returns the contents of the manifest blob with digest dig.
+func (s *ociImageSource) readManifestBlob(dig digest.Digest) ([]byte, error) {
+	manifestPath, err := s.ref.blobPath(dig, s.sharedBlobDir)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(manifestPath)
+}
+
+// maxIndexDepth is the maximum depth of nested image indexes searched by instanceMIMEType.
+const maxIndexDepth = 8
+
+// instanceMIMEType returns the MIME type of the manifest m with digest dig, an instance of the primary manifest.
+func (s *ociImageSource) instanceMIMEType(dig digest.Digest, m []byte) (string, error) {
+	if s.descriptor.MediaType == imgspecv1.MediaTypeImageIndex {
+		mimeType, err := s.findMIMETypeInIndex(s.descriptor.Digest, dig, maxIndexDepth)
+		if err != nil {
+			return "", err
+		}
+		if mimeType != "" {
+			return mimeType, nil
+		}
+	}
+	// The instance is not referenced from the index (or the index is missing MIME types); guess.
+	if mimeType := manifest.GuessMIMEType(m); mimeType == imgspecv1.MediaTypeImageIndex {
+		return mimeType, nil
+	}
+	return imgspecv1.MediaTypeImageManifest, nil
+}
+
+// findMIMETypeInIndex returns the MIME type of a descriptor of dig in the image index indexDigest,
+// or in image indexes it references, up to depth levels deep, or "" if no such descriptor was found.
+func (s *ociImageSource) findMIMETypeInIndex(indexDigest, dig digest.Digest, depth int) (string, error) {
+	if depth == 0 {
+		return "", nil
+	}
+	blob, err := s.readManifestBlob(indexDigest)
+	if err != nil {
+		return "", err
+	}
+	index := imgspecv1.Index{}
+	if err := json.Unmarshal(blob, &index); err != nil {
+		return "", errors.Wrapf(err, "Error parsing image index %s", indexDigest)
+	}
+	for _, d := range index.Manifests {
+		if d.Digest == dig {
+			return d.MediaType, nil
+		}
+	}
+	for _, d := range index.Manifests {
+		if d.MediaType == imgspecv1.MediaTypeImageIndex {
+			mimeType, err := s.findMIMETypeInIndex(d.Digest, dig, depth-1)
+			if err != nil || mimeType != "" {
+				return mimeType, err
+			}
+		}
+	}
+	return "", nil
+}
+
 // HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
 func (s *ociImageSource) HasThreadSafeGetBlob() bool {
 	return false
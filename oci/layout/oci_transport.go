@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/containers/image/directory/explicitfilepath"
@@ -177,6 +179,47 @@ func (ref ociReference) getIndex() (*imgspecv1.Index, error) {
 	return index, nil
 }
 
+// putIndex atomically replaces the index.json of the layout with index.
+// The caller should hold an exclusive lock on ref.dir (see lockDirectory).
+func (ref ociReference) putIndex(index *imgspecv1.Index) error {
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	indexFile, err := ioutil.TempFile(ref.dir, "oci-put-index")
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			indexFile.Close()
+			os.Remove(indexFile.Name())
+		}
+	}()
+	if _, err := indexFile.Write(indexJSON); err != nil {
+		return err
+	}
+	if err := indexFile.Sync(); err != nil {
+		return err
+	}
+	// See the comment in ociImageDestination.PutBlob about file permissions on Windows.
+	if runtime.GOOS != "windows" {
+		if err := indexFile.Chmod(0644); err != nil {
+			return err
+		}
+	}
+	// need to explicitly close the file, since a rename won't otherwise not work on Windows
+	if err := indexFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(indexFile.Name(), ref.indexPath()); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
+
 func (ref ociReference) getManifestDescriptor() (imgspecv1.Descriptor, error) {
 	index, err := ref.getIndex()
 	if err != nil {
@@ -195,7 +238,7 @@ func (ref ociReference) getManifestDescriptor() (imgspecv1.Descriptor, error) {
 	} else {
 		// if image specified, look through all manifests for a match
 		for _, md := range index.Manifests {
-			if md.MediaType != imgspecv1.MediaTypeImageManifest {
+			if md.MediaType != imgspecv1.MediaTypeImageManifest && md.MediaType != imgspecv1.MediaTypeImageIndex {
 				continue
 			}
 			refName, ok := md.Annotations["org.opencontainers.image.ref.name"]
@@ -237,8 +280,36 @@ func (ref ociReference) NewImageDestination(ctx context.Context, sys *types.Syst
 }
 
 // DeleteImage deletes the named image from the registry, if supported.
+// For oci: layouts, this only removes the reference from index.json; use GarbageCollect to remove blobs which are no longer used.
 func (ref ociReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
-	return errors.Errorf("Deleting images not implemented for oci: images")
+	unlock, err := lockDirectory(ref.dir, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := ref.getIndex()
+	if err != nil {
+		return err
+	}
+	manifests := []imgspecv1.Descriptor{}
+	if ref.image == "" {
+		// Like getManifestDescriptor, only allow deleting the only image in the layout
+		if len(index.Manifests) != 1 {
+			return ErrMoreThanOneImage
+		}
+	} else {
+		for _, md := range index.Manifests {
+			if md.Annotations["org.opencontainers.image.ref.name"] != ref.image {
+				manifests = append(manifests, md)
+			}
+		}
+		if len(manifests) == len(index.Manifests) {
+			return fmt.Errorf("no descriptor found for reference %q", ref.image)
+		}
+	}
+	index.Manifests = manifests
+	return ref.putIndex(index)
 }
 
 // ociLayoutPath returns a path for the oci-layout within a directory using OCI conventions.
@@ -256,9 +327,13 @@ func (ref ociReference) blobPath(digest digest.Digest, sharedBlobDir string) (st
 	if err := digest.Validate(); err != nil {
 		return "", errors.Wrapf(err, "unexpected digest reference %s", digest)
 	}
-	blobDir := filepath.Join(ref.dir, "blobs")
+	return filepath.Join(ref.blobDir(sharedBlobDir), digest.Algorithm().String(), digest.Hex()), nil
+}
+
+// blobDir returns a path for the directory containing blobs of the layout, which is sharedBlobDir if it is set.
+func (ref ociReference) blobDir(sharedBlobDir string) string {
 	if sharedBlobDir != "" {
-		blobDir = sharedBlobDir
+		return sharedBlobDir
 	}
-	return filepath.Join(blobDir, digest.Algorithm().String(), digest.Hex()), nil
+	return filepath.Join(ref.dir, "blobs")
 }
@@ -263,8 +263,48 @@ func TestReferenceNewImageDestination(t *testing.T) {
 func TestReferenceDeleteImage(t *testing.T) {
 	ref, tmpDir := refToTempOCI(t)
 	defer os.RemoveAll(tmpDir)
-	err := ref.DeleteImage(context.Background(), nil)
+	ociRef, ok := ref.(ociReference)
+	require.True(t, ok)
+	otherRef, err := NewReference(tmpDir, "otherImage")
+	require.NoError(t, err)
+	putTestManifest(t, otherRef.(ociReference), tmpDir)
+
+	err = ref.DeleteImage(context.Background(), nil)
+	require.NoError(t, err)
+	index, err := ociRef.getIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, "otherImage", index.Manifests[0].Annotations["org.opencontainers.image.ref.name"])
+	// Deleting does not remove any blobs
+	_, err = os.Stat(filepath.Join(tmpDir, "blobs", "sha256", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"))
+	assert.NoError(t, err)
+
+	// The image no longer exists
+	err = ref.DeleteImage(context.Background(), nil)
 	assert.Error(t, err)
+
+	// An unnamed reference deletes the only image
+	unnamedRef, err := NewReference(tmpDir, "")
+	require.NoError(t, err)
+	err = unnamedRef.DeleteImage(context.Background(), nil)
+	require.NoError(t, err)
+	index, err = ociRef.getIndex()
+	require.NoError(t, err)
+	assert.Len(t, index.Manifests, 0)
+
+	// An unnamed reference fails if there is more than one image
+	ref, tmpDir2 := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir2)
+	otherRef, err = NewReference(tmpDir2, "otherImage")
+	require.NoError(t, err)
+	putTestManifest(t, otherRef.(ociReference), tmpDir2)
+	unnamedRef, err = NewReference(tmpDir2, "")
+	require.NoError(t, err)
+	err = unnamedRef.DeleteImage(context.Background(), nil)
+	assert.Equal(t, ErrMoreThanOneImage, err)
+	index, err = ref.(ociReference).getIndex()
+	require.NoError(t, err)
+	assert.Len(t, index.Manifests, 2)
 }
 
 func TestReferenceOCILayoutPath(t *testing.T) {
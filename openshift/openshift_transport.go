@@ -151,6 +151,12 @@ func (ref openshiftReference) NewImageDestination(ctx context.Context, sys *type
 	return newImageDestination(ctx, sys, ref)
 }
 
+// DestinationIsInspectable returns true: creating an ImageDestination, and checking for existing blobs, only uses
+// read-only registry operations.  See types.ImageReferenceWithInspectableDestination.
+func (ref openshiftReference) DestinationIsInspectable() bool {
+	return true
+}
+
 // DeleteImage deletes the named image from the registry, if supported.
 func (ref openshiftReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	return errors.Errorf("Deleting images not implemented for atomic: images")
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -118,6 +119,14 @@ func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
 // openshiftReference.NewImage, openshiftReference.NewImageSource, openshiftReference.NewImageDestination untested because they depend
 // on per-user configuration when initializing httpClient.
 
+func TestReferenceDestinationIsInspectable(t *testing.T) {
+	ref, err := ParseReference("registry.example.com:8443/ns/stream:notlatest")
+	require.NoError(t, err)
+	inspectable, ok := ref.(types.ImageReferenceWithInspectableDestination)
+	require.True(t, ok)
+	assert.True(t, inspectable.DestinationIsInspectable())
+}
+
 func TestReferenceDeleteImage(t *testing.T) {
 	ref, err := ParseReference("registry.example.com:8443/ns/stream:notlatest")
 	require.NoError(t, err)
@@ -3,9 +3,11 @@ package compression
 import (
 	"bytes"
 	"compress/bzip2"
+	"fmt"
 	"io"
 	"io/ioutil"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/klauspost/pgzip"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -16,6 +18,10 @@ import (
 // The caller must call Close() on the decompressed stream (even if the compressed input stream does not need closing!).
 type DecompressorFunc func(io.Reader) (io.ReadCloser, error)
 
+// compressorFunc returns a compressing stream writing to dest, using level if it is not nil.
+// The caller must call Close() on the returned stream to flush all compressed data.
+type compressorFunc func(dest io.Writer, level *int) (io.WriteCloser, error)
+
 // GzipDecompressor is a DecompressorFunc for the gzip compression algorithm.
 func GzipDecompressor(r io.Reader) (io.ReadCloser, error) {
 	return pgzip.NewReader(r)
@@ -35,32 +41,119 @@ func XzDecompressor(r io.Reader) (io.ReadCloser, error) {
 	return ioutil.NopCloser(r), nil
 }
 
-// compressionAlgos is an internal implementation detail of DetectCompression
-var compressionAlgos = map[string]struct {
+// ZstdDecompressor is a DecompressorFunc for the zstd compression algorithm.
+func ZstdDecompressor(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// gzipCompressor is a compressorFunc for the gzip compression algorithm.
+func gzipCompressor(dest io.Writer, level *int) (io.WriteCloser, error) {
+	if level != nil {
+		return pgzip.NewWriterLevel(dest, *level)
+	}
+	return pgzip.NewWriter(dest), nil
+}
+
+// xzCompressor is a compressorFunc for the xz compression algorithm.
+// xz does not support compression levels; level is ignored.
+func xzCompressor(dest io.Writer, level *int) (io.WriteCloser, error) {
+	return xz.NewWriter(dest)
+}
+
+// zstdCompressor is a compressorFunc for the zstd compression algorithm.
+// level uses the zstd command-line scale; it is mapped to the closest encoder level supported by the implementation.
+func zstdCompressor(dest io.Writer, level *int) (io.WriteCloser, error) {
+	if level != nil {
+		return zstd.NewWriter(dest, zstd.WithEncoderLevel(zstdEncoderLevel(*level)))
+	}
+	return zstd.NewWriter(dest)
+}
+
+// zstdEncoderLevel returns the zstd.EncoderLevel closest to the zstd command-line compression level.
+// The vendored github.com/klauspost/compress/zstd only implements SpeedFastest (roughly level 1) and SpeedDefault
+// (roughly level 3), so higher levels can not be honored and use SpeedDefault as well.
+func zstdEncoderLevel(level int) zstd.EncoderLevel {
+	if level < 3 {
+		return zstd.SpeedFastest
+	}
+	return zstd.SpeedDefault
+}
+
+// Algorithm is a compression algorithm that can be detected by DetectCompressionFormat,
+// and possibly used by CompressStream.
+type Algorithm struct {
+	name         string
 	prefix       []byte
 	decompressor DecompressorFunc
-}{
-	"gzip":  {[]byte{0x1F, 0x8B, 0x08}, GzipDecompressor},                 // gzip (RFC 1952)
-	"bzip2": {[]byte{0x42, 0x5A, 0x68}, Bzip2Decompressor},                // bzip2 (decompress.c:BZ2_decompress)
-	"xz":    {[]byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}, XzDecompressor}, // xz (/usr/share/doc/xz/xz-file-format.txt)
+	compressor   compressorFunc // nil if compressing is not supported
 }
 
-// DetectCompression returns a DecompressorFunc if the input is recognized as a compressed format, nil otherwise.
+// Name returns the name for the compression algorithm.
+func (c Algorithm) Name() string {
+	return c.name
+}
+
+var (
+	// Gzip compression.
+	Gzip = Algorithm{"gzip", []byte{0x1F, 0x8B, 0x08}, GzipDecompressor, gzipCompressor} // gzip (RFC 1952)
+	// Bzip2 compression.
+	Bzip2 = Algorithm{"bzip2", []byte{0x42, 0x5A, 0x68}, Bzip2Decompressor, nil} // bzip2 (decompress.c:BZ2_decompress)
+	// Xz compression.
+	Xz = Algorithm{"xz", []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}, XzDecompressor, xzCompressor} // xz (/usr/share/doc/xz/xz-file-format.txt)
+	// Zstd compression.
+	Zstd = Algorithm{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, ZstdDecompressor, zstdCompressor} // zstd (http://www.zstd.net)
+)
+
+// compressionAlgos is an internal implementation detail of DetectCompressionFormat and AlgorithmByName
+var compressionAlgos = map[string]Algorithm{
+	Gzip.name:  Gzip,
+	Bzip2.name: Bzip2,
+	Xz.name:    Xz,
+	Zstd.name:  Zstd,
+}
+
+// AlgorithmByName returns the compressor by its name
+func AlgorithmByName(name string) (Algorithm, error) {
+	algorithm, ok := compressionAlgos[name]
+	if ok {
+		return algorithm, nil
+	}
+	return Algorithm{}, fmt.Errorf("cannot find compressor for %q", name)
+}
+
+// CompressStream returns a stream which writes a version of its input compressed using algo to dest.
+// If level is not nil, it is used as the algorithm-specific compression level.
+// The caller must call Close() on the returned stream to flush all compressed data.
+func CompressStream(dest io.Writer, algo Algorithm, level *int) (io.WriteCloser, error) {
+	if algo.compressor == nil {
+		return nil, fmt.Errorf("compressing using %q is not supported", algo.name)
+	}
+	return algo.compressor(dest, level)
+}
+
+// DetectCompressionFormat returns a DecompressorFunc if the input is recognized as a compressed format, an invalid
+// value (Algorithm.Name() == "") and nil otherwise.
 // Because it consumes the start of input, other consumers must use the returned io.Reader instead to also read from the beginning.
-func DetectCompression(input io.Reader) (DecompressorFunc, io.Reader, error) {
+func DetectCompressionFormat(input io.Reader) (Algorithm, DecompressorFunc, io.Reader, error) {
 	buffer := [8]byte{}
 
 	n, err := io.ReadAtLeast(input, buffer[:], len(buffer))
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		// This is a “real” error. We could just ignore it this time, process the data we have, and hope that the source will report the same error again.
 		// Instead, fail immediately with the original error cause instead of a possibly secondary/misleading error returned later.
-		return nil, nil, err
+		return Algorithm{}, nil, nil, err
 	}
 
+	var retAlgo Algorithm
 	var decompressor DecompressorFunc
-	for name, algo := range compressionAlgos {
+	for _, algo := range compressionAlgos {
 		if bytes.HasPrefix(buffer[:n], algo.prefix) {
-			logrus.Debugf("Detected compression format %s", name)
+			logrus.Debugf("Detected compression format %s", algo.name)
+			retAlgo = algo
 			decompressor = algo.decompressor
 			break
 		}
@@ -69,7 +162,14 @@ func DetectCompression(input io.Reader) (DecompressorFunc, io.Reader, error) {
 		logrus.Debugf("No compression detected")
 	}
 
-	return decompressor, io.MultiReader(bytes.NewReader(buffer[:n]), input), nil
+	return retAlgo, decompressor, io.MultiReader(bytes.NewReader(buffer[:n]), input), nil
+}
+
+// DetectCompression returns a DecompressorFunc if the input is recognized as a compressed format, nil otherwise.
+// Because it consumes the start of input, other consumers must use the returned io.Reader instead to also read from the beginning.
+func DetectCompression(input io.Reader) (DecompressorFunc, io.Reader, error) {
+	_, d, r, e := DetectCompressionFormat(input)
+	return d, r, e
 }
 
 // AutoDecompress takes a stream and returns an uncompressed version of the
@@ -9,6 +9,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,6 +20,7 @@ func TestDetectCompression(t *testing.T) {
 		"fixtures/Hello.gz",
 		"fixtures/Hello.bz2",
 		"fixtures/Hello.xz",
+		"fixtures/Hello.zst",
 	}
 
 	// The original stream is preserved.
@@ -87,6 +89,7 @@ func TestAutoDecompress(t *testing.T) {
 		{"fixtures/Hello.gz", true},
 		{"fixtures/Hello.bz2", true},
 		{"fixtures/Hello.xz", true},
+		{"fixtures/Hello.zst", true},
 	}
 
 	// The correct decompressor is chosen, and the result is as expected.
@@ -125,3 +128,84 @@ func TestAutoDecompress(t *testing.T) {
 	_, _, err = AutoDecompress(reader)
 	assert.Error(t, err)
 }
+
+func TestDetectCompressionFormat(t *testing.T) {
+	for _, c := range []struct {
+		filename string
+		expected string
+	}{
+		{"fixtures/Hello.uncompressed", ""},
+		{"fixtures/Hello.gz", "gzip"},
+		{"fixtures/Hello.bz2", "bzip2"},
+		{"fixtures/Hello.xz", "xz"},
+		{"fixtures/Hello.zst", "zstd"},
+	} {
+		stream, err := os.Open(c.filename)
+		require.NoError(t, err, c.filename)
+		defer stream.Close()
+
+		algo, _, _, err := DetectCompressionFormat(stream)
+		require.NoError(t, err, c.filename)
+		assert.Equal(t, c.expected, algo.Name(), c.filename)
+	}
+}
+
+func TestAlgorithmByName(t *testing.T) {
+	for _, name := range []string{"gzip", "bzip2", "xz", "zstd"} {
+		algo, err := AlgorithmByName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, algo.Name())
+	}
+	_, err := AlgorithmByName("this-is-not-an-algorithm")
+	assert.Error(t, err)
+}
+
+func TestCompressStream(t *testing.T) {
+	level := 1
+	for _, c := range []struct {
+		algo  Algorithm
+		level *int
+	}{
+		{Gzip, nil},
+		{Gzip, &level},
+		{Xz, nil},
+		{Zstd, nil},
+		{Zstd, &level},
+	} {
+		var buf bytes.Buffer
+		compressor, err := CompressStream(&buf, c.algo, c.level)
+		require.NoError(t, err, c.algo.Name())
+		_, err = compressor.Write([]byte("Hello"))
+		require.NoError(t, err, c.algo.Name())
+		err = compressor.Close()
+		require.NoError(t, err, c.algo.Name())
+
+		algo, decompressor, stream, err := DetectCompressionFormat(&buf)
+		require.NoError(t, err, c.algo.Name())
+		assert.Equal(t, c.algo.Name(), algo.Name())
+		uncompressedStream, err := decompressor(stream)
+		require.NoError(t, err, c.algo.Name())
+		defer uncompressedStream.Close()
+		uncompressedContents, err := ioutil.ReadAll(uncompressedStream)
+		require.NoError(t, err, c.algo.Name())
+		assert.Equal(t, []byte("Hello"), uncompressedContents, c.algo.Name())
+	}
+
+	// Compressing using bzip2 is not supported.
+	_, err := CompressStream(ioutil.Discard, Bzip2, nil)
+	assert.Error(t, err)
+}
+
+func TestZstdEncoderLevel(t *testing.T) {
+	for _, c := range []struct {
+		level    int
+		expected zstd.EncoderLevel
+	}{
+		{-5, zstd.SpeedFastest},
+		{1, zstd.SpeedFastest},
+		{3, zstd.SpeedDefault},
+		{19, zstd.SpeedDefault},
+	} {
+		assert.Equal(t, c.expected, zstdEncoderLevel(c.level), "%d", c.level)
+	}
+}
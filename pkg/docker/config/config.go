@@ -18,7 +18,8 @@ import (
 )
 
 type dockerAuthConfig struct {
-	Auth string `json:"auth,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
 }
 
 type dockerConfigFile struct {
@@ -37,15 +38,33 @@ var (
 	ErrNotLoggedIn = errors.New("not logged in")
 )
 
+// identityTokenUsername is the username used by credential helpers to indicate that the secret is an identity token, as in docker/cli.
+const identityTokenUsername = "<token>"
+
 // SetAuthentication stores the username and password in the auth.json file
 func SetAuthentication(sys *types.SystemContext, registry, username, password string) error {
+	return SetCredentials(sys, registry, types.DockerAuthConfig{Username: username, Password: password})
+}
+
+// SetCredentials stores the credentials for registry in the auth.json file, replacing any existing ones.
+// If creds.IdentityToken is set, it is stored instead of creds.Password.
+func SetCredentials(sys *types.SystemContext, registry string, creds types.DockerAuthConfig) error {
 	return modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
 		if ch, exists := auths.CredHelpers[registry]; exists {
-			return false, setAuthToCredHelper(ch, registry, username, password)
+			if creds.IdentityToken != "" {
+				return false, setAuthToCredHelper(ch, registry, identityTokenUsername, creds.IdentityToken)
+			}
+			return false, setAuthToCredHelper(ch, registry, creds.Username, creds.Password)
 		}
 
-		creds := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
-		newCreds := dockerAuthConfig{Auth: creds}
+		newCreds := dockerAuthConfig{}
+		if creds.IdentityToken != "" {
+			// Like docker/cli, record the username for GetUserLoggedIn, but not the password.
+			newCreds.Auth = base64.StdEncoding.EncodeToString([]byte(creds.Username + ":"))
+			newCreds.IdentityToken = creds.IdentityToken
+		} else {
+			newCreds.Auth = base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+		}
 		auths.AuthConfigs[registry] = newCreds
 		return true, nil
 	})
@@ -54,9 +73,31 @@ func SetAuthentication(sys *types.SystemContext, registry, username, password st
 // GetAuthentication returns the registry credentials stored in
 // either auth.json file or .docker/config.json
 // If an entry is not found empty strings are returned for the username and password
+// Identity tokens are ignored; use GetCredentials to obtain them.
 func GetAuthentication(sys *types.SystemContext, registry string) (string, string, error) {
+	creds, err := GetCredentials(sys, registry)
+	if err != nil {
+		return "", "", err
+	}
+	if creds.IdentityToken != "" {
+		return "", "", nil
+	}
+	return creds.Username, creds.Password, nil
+}
+
+// GetCredentials returns the registry credentials, including an identity token if one is stored,
+// from either sys.DockerAuthConfig, auth.json file or .docker/config.json.
+// If an entry is not found, an empty types.DockerAuthConfig is returned.
+func GetCredentials(sys *types.SystemContext, registry string) (types.DockerAuthConfig, error) {
+	creds, _, err := GetCredentialsAndOrigin(sys, registry)
+	return creds, err
+}
+
+// GetCredentialsAndOrigin returns the same credentials as GetCredentials, and true if they were read from the auth.json
+// file (including a credential helper configured in it), i.e. if SetCredentials would replace them.
+func GetCredentialsAndOrigin(sys *types.SystemContext, registry string) (types.DockerAuthConfig, bool, error) {
 	if sys != nil && sys.DockerAuthConfig != nil {
-		return sys.DockerAuthConfig.Username, sys.DockerAuthConfig.Password, nil
+		return *sys.DockerAuthConfig, false, nil
 	}
 
 	dockerLegacyPath := filepath.Join(homedir.Get(), dockerLegacyHomePath)
@@ -74,15 +115,15 @@ func GetAuthentication(sys *types.SystemContext, registry string) (string, strin
 
 	for _, path := range paths {
 		legacyFormat := path == dockerLegacyPath
-		username, password, err := findAuthentication(registry, path, legacyFormat)
+		creds, err := findAuthentication(registry, path, legacyFormat)
 		if err != nil {
-			return "", "", err
+			return types.DockerAuthConfig{}, false, err
 		}
-		if username != "" && password != "" {
-			return username, password, nil
+		if (creds.Username != "" && creds.Password != "") || creds.IdentityToken != "" {
+			return creds, pathToAuth != "" && path == pathToAuth, nil
 		}
 	}
-	return "", "", nil
+	return types.DockerAuthConfig{}, false, nil
 }
 
 // GetUserLoggedIn returns the username logged in to registry from either
@@ -93,9 +134,9 @@ func GetUserLoggedIn(sys *types.SystemContext, registry string) (string, error)
 	if err != nil {
 		return "", err
 	}
-	username, _, _ := findAuthentication(registry, path, false)
-	if username != "" {
-		return username, nil
+	creds, _ := findAuthentication(registry, path, false)
+	if creds.Username != "" {
+		return creds.Username, nil
 	}
 	return "", nil
 }
@@ -224,14 +265,17 @@ func modifyJSON(sys *types.SystemContext, editor func(auths *dockerConfigFile) (
 	return nil
 }
 
-func getAuthFromCredHelper(credHelper, registry string) (string, string, error) {
+func getAuthFromCredHelper(credHelper, registry string) (types.DockerAuthConfig, error) {
 	helperName := fmt.Sprintf("docker-credential-%s", credHelper)
 	p := helperclient.NewShellProgramFunc(helperName)
 	creds, err := helperclient.Get(p, registry)
 	if err != nil {
-		return "", "", err
+		return types.DockerAuthConfig{}, err
+	}
+	if creds.Username == identityTokenUsername {
+		return types.DockerAuthConfig{IdentityToken: creds.Secret}, nil
 	}
-	return creds.Username, creds.Secret, nil
+	return types.DockerAuthConfig{Username: creds.Username, Password: creds.Secret}, nil
 }
 
 func setAuthToCredHelper(credHelper, registry, username, password string) error {
@@ -252,10 +296,10 @@ func deleteAuthFromCredHelper(credHelper, registry string) error {
 }
 
 // findAuthentication looks for auth of registry in path
-func findAuthentication(registry, path string, legacyFormat bool) (string, string, error) {
+func findAuthentication(registry, path string, legacyFormat bool) (types.DockerAuthConfig, error) {
 	auths, err := readJSONFile(path, legacyFormat)
 	if err != nil {
-		return "", "", errors.Wrapf(err, "error reading JSON file %q", path)
+		return types.DockerAuthConfig{}, errors.Wrapf(err, "error reading JSON file %q", path)
 	}
 
 	// First try cred helpers. They should always be normalized.
@@ -265,7 +309,7 @@ func findAuthentication(registry, path string, legacyFormat bool) (string, strin
 
 	// I'm feeling lucky
 	if val, exists := auths.AuthConfigs[registry]; exists {
-		return decodeDockerAuth(val.Auth)
+		return decodeDockerAuth(val)
 	}
 
 	// bad luck; let's normalize the entries first
@@ -275,24 +319,29 @@ func findAuthentication(registry, path string, legacyFormat bool) (string, strin
 		normalizedAuths[normalizeRegistry(k)] = v
 	}
 	if val, exists := normalizedAuths[registry]; exists {
-		return decodeDockerAuth(val.Auth)
+		return decodeDockerAuth(val)
 	}
-	return "", "", nil
+	return types.DockerAuthConfig{}, nil
 }
 
-func decodeDockerAuth(s string) (string, string, error) {
-	decoded, err := base64.StdEncoding.DecodeString(s)
+// decodeDockerAuth decodes the username and password from conf.Auth, and adds conf.IdentityToken, if any.
+func decodeDockerAuth(conf dockerAuthConfig) (types.DockerAuthConfig, error) {
+	decoded, err := base64.StdEncoding.DecodeString(conf.Auth)
 	if err != nil {
-		return "", "", err
+		return types.DockerAuthConfig{}, err
 	}
 	parts := strings.SplitN(string(decoded), ":", 2)
 	if len(parts) != 2 {
 		// if it's invalid just skip, as docker does
-		return "", "", nil
+		return types.DockerAuthConfig{IdentityToken: conf.IdentityToken}, nil
 	}
 	user := parts[0]
 	password := strings.Trim(parts[1], "\x00")
-	return user, password, nil
+	return types.DockerAuthConfig{
+		Username:      user,
+		Password:      password,
+		IdentityToken: conf.IdentityToken,
+	}, nil
 }
 
 // convertToHostname converts a registry url which has http|https prepended
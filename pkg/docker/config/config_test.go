@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/types"
@@ -57,3 +58,38 @@ func TestGetPathToAuth(t *testing.T) {
 		}
 	}
 }
+
+func TestSetCredentials(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestSetCredentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	sys := &types.SystemContext{AuthFilePath: filepath.Join(tmpDir, "auth.json")}
+
+	// Username and password
+	err = SetAuthentication(sys, "example.com", "user", "password")
+	require.NoError(t, err)
+	creds, err := GetCredentials(sys, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "password"}, creds)
+
+	// An identity token replaces the password
+	err = SetCredentials(sys, "example.com", types.DockerAuthConfig{Username: "user", IdentityToken: "refresh-token"})
+	require.NoError(t, err)
+	creds, err = GetCredentials(sys, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", IdentityToken: "refresh-token"}, creds)
+	username, password, err := GetAuthentication(sys, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+	assert.Equal(t, "", password)
+	username, err = GetUserLoggedIn(sys, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+
+	// The format used by docker
+	err = ioutil.WriteFile(sys.AuthFilePath, []byte(`{"auths":{"https://index.docker.io/v1/":{"auth":"dXNlcjo=","identitytoken":"docker-token"}}}`), 0600)
+	require.NoError(t, err)
+	creds, err = GetCredentials(sys, "docker.io")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", IdentityToken: "docker-token"}, creds)
+}
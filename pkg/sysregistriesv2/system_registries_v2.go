@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"github.com/BurntSushi/toml"
+	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/types"
 )
 
@@ -51,6 +52,59 @@ type Registry struct {
 	// effectively be pulled from "example.com/foo/bar/myimage:latest".
 	// If no Prefix is specified, it defaults to the specified URL.
 	Prefix string `toml:"prefix"`
+	// Hosts (host[:port]) of other registries which share the storage backend of this registry, using the same repository paths.
+	// Blobs known to exist in a repository of such a registry can be mounted into this registry
+	// by the repository path, instead of being uploaded again.
+	MountEquivalent []string `toml:"mount-equivalent"`
+}
+
+// PullSource is a location an image can be pulled from.
+type PullSource struct {
+	// Location is the registry location, i.e. Registry.URL or Mirror.URL.
+	Location string
+	// If true, certs verification will be skipped and HTTP (non-TLS)
+	// connections will be allowed.
+	Insecure bool
+	// Mirror is true if Location is one of the registry's mirrors.
+	Mirror bool
+	// Reference is the image reference to pull from Location, i.e. the
+	// original reference with Registry.Prefix replaced by Location.
+	Reference reference.Named
+}
+
+// rewriteReference returns a reference with prefix of ref replaced by location.
+func rewriteReference(ref reference.Named, prefix, location string) (reference.Named, error) {
+	refString := ref.String()
+	if !refMatchesPrefix(refString, prefix) {
+		return nil, fmt.Errorf("invalid prefix '%v' for reference '%v'", prefix, refString)
+	}
+
+	newNamedRef := location + refString[len(prefix):]
+	newParsedRef, err := reference.ParseNamed(newNamedRef)
+	if err != nil {
+		return nil, fmt.Errorf("error rewriting reference '%v' to '%v': %v", refString, newNamedRef, err)
+	}
+	return newParsedRef, nil
+}
+
+// PullSourcesFromReference returns the locations ref can be pulled from, in order of preference:
+// the registry's mirrors first, in the order they are configured, followed by the registry itself.
+// ref must match r.Prefix, e.g. r must have been returned by FindRegistry for ref.Name().
+func (r *Registry) PullSourcesFromReference(ref reference.Named) ([]PullSource, error) {
+	sources := []PullSource{}
+	for _, mirror := range r.Mirrors {
+		mirrorRef, err := rewriteReference(ref, r.Prefix, mirror.URL)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, PullSource{Location: mirror.URL, Insecure: mirror.Insecure, Mirror: true, Reference: mirrorRef})
+	}
+	primaryRef, err := rewriteReference(ref, r.Prefix, r.URL)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, PullSource{Location: r.URL, Insecure: r.Insecure, Reference: primaryRef})
+	return sources, nil
 }
 
 // backwards compatability to sysregistries v1
@@ -185,12 +239,23 @@ func postProcessRegistries(regs []Registry) ([]Registry, error) {
 		}
 
 		// make sure mirrors are valid
-		for _, mir := range reg.Mirrors {
-			mir.URL, err = parseURL(mir.URL)
+		for i := range reg.Mirrors {
+			reg.Mirrors[i].URL, err = parseURL(reg.Mirrors[i].URL)
 			if err != nil {
 				return nil, err
 			}
 		}
+		// make sure mount-equivalent hosts are valid
+		for i := range reg.MountEquivalent {
+			reg.MountEquivalent[i], err = parseURL(reg.MountEquivalent[i])
+			if err != nil {
+				return nil, err
+			}
+			if strings.Contains(reg.MountEquivalent[i], "/") {
+				msg := fmt.Sprintf("invalid mount-equivalent host '%s': must not contain a path", reg.MountEquivalent[i])
+				return nil, &InvalidRegistries{s: msg}
+			}
+		}
 		registries = append(registries, reg)
 		regMap[reg.URL] = append(regMap[reg.URL], reg)
 	}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testConfig = []byte("")
@@ -86,6 +88,34 @@ blocked = true`)
 	assert.True(t, reg.Mirrors[1].Insecure)
 }
 
+func TestMountEquivalent(t *testing.T) {
+	testConfig = []byte(`
+[[registry]]
+url = "registry.com"
+mount-equivalent = ["registry-alias.com", "registry-backend.com:5000/"]
+
+[[registry]]
+url = "other.com"`)
+
+	configCache = make(map[string][]Registry)
+	reg, err := FindRegistry(nil, "registry.com/image:tag")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	assert.Equal(t, []string{"registry-alias.com", "registry-backend.com:5000"}, reg.MountEquivalent)
+	reg, err = FindRegistry(nil, "other.com/image:tag")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	assert.Empty(t, reg.MountEquivalent)
+
+	testConfig = []byte(`
+[[registry]]
+url = "registry.com"
+mount-equivalent = ["registry-alias.com/namespace"]`)
+	configCache = make(map[string][]Registry)
+	_, err = GetRegistries(nil)
+	assert.Error(t, err)
+}
+
 func TestMissingRegistryURL(t *testing.T) {
 	testConfig = []byte(`
 [[registry]]
@@ -485,3 +515,66 @@ insecure = true`)
 	assert.Equal(t, 4, len(registries))
 	assertSearchRegistryURLsEqual(t, []string{"registry.com", "blocked.registry.com", "insecure.registry.com", "untrusted.registry.com"}, registries)
 }
+
+func TestPullSourcesFromReference(t *testing.T) {
+	testConfig = []byte(`
+[[registry]]
+url = "registry.com/foo"
+prefix = "example.com/bar"
+insecure = true
+
+[[registry.mirror]]
+url = "mirror-1.registry.com/"
+
+[[registry.mirror]]
+url = "mirror-2.registry.com/with/path"
+insecure = true
+
+[[registry]]
+url = "no-mirrors.com"`)
+
+	configCache = make(map[string][]Registry)
+	registries, err := GetRegistries(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(registries))
+
+	ref, err := reference.ParseNamed("example.com/bar/image:tag")
+	require.NoError(t, err)
+	reg, err := FindRegistry(nil, ref.Name())
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	sources, err := reg.PullSourcesFromReference(ref)
+	require.NoError(t, err)
+	require.Len(t, sources, 3)
+	for i, expected := range []struct {
+		location, ref string
+		insecure      bool
+		mirror        bool
+	}{
+		{"mirror-1.registry.com", "mirror-1.registry.com/image:tag", false, true},
+		{"mirror-2.registry.com/with/path", "mirror-2.registry.com/with/path/image:tag", true, true},
+		{"registry.com/foo", "registry.com/foo/image:tag", true, false},
+	} {
+		assert.Equal(t, expected.location, sources[i].Location)
+		assert.Equal(t, expected.ref, sources[i].Reference.String())
+		assert.Equal(t, expected.insecure, sources[i].Insecure)
+		assert.Equal(t, expected.mirror, sources[i].Mirror)
+	}
+
+	ref, err = reference.ParseNamed("no-mirrors.com/image@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	require.NoError(t, err)
+	reg, err = FindRegistry(nil, ref.Name())
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	sources, err = reg.PullSourcesFromReference(ref)
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, ref.String(), sources[0].Reference.String())
+	assert.False(t, sources[0].Mirror)
+
+	// A reference which does not match the prefix is rejected.
+	ref, err = reference.ParseNamed("other.com/image:tag")
+	require.NoError(t, err)
+	_, err = reg.PullSourcesFromReference(ref)
+	assert.Error(t, err)
+}
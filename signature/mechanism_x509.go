@@ -0,0 +1,326 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// x509SignatureFormat identifies the x509Signature format in the signature blob.
+const x509SignatureFormat = "x509-detached-v1"
+
+// x509Signature is the serialized form of a signature created by the X.509 signing mechanism:
+// a detached signature of the payload, along with the signer's certificate chain.
+type x509Signature struct {
+	Format string `json:"format"`
+	// Certificates contains DER-encoded certificates, the signer's certificate first,
+	// followed by any intermediate certificates needed to validate it.
+	Certificates [][]byte `json:"certificates"`
+	Payload      []byte   `json:"payload"`
+	Signature    []byte   `json:"signature"`
+}
+
+// An X.509 signing mechanism, implemented using crypto/x509.
+type x509SigningMechanism struct {
+	// Exactly one of trustedCertificates and roots is set.
+	trustedCertificates []*x509.Certificate // Signatures must be made by one of these certificates.
+	roots               *x509.CertPool      // Signatures must be made by a certificate issued by one of these CAs.
+
+	signer           crypto.Signer       // nil if signing is not supported
+	signerChain      []*x509.Certificate // The signer's certificate chain, signer's certificate first.
+	signerIdentities []string            // Identities accepted as keyIdentity by Sign.
+}
+
+// NewX509SigningMechanism returns a new X.509 signing mechanism which signs using privateKey,
+// and the certificate chain (signer's certificate first) in certificateChain, both PEM-encoded.
+// The mechanism verifies only signatures made by the signer's certificate.
+// The key identity used by Sign and returned by Verify is the uppercase hexadecimal SHA-256
+// fingerprint of the signer's certificate.
+// The caller must call .Close() on the returned SigningMechanism.
+func NewX509SigningMechanism(certificateChain, privateKey []byte) (SigningMechanism, error) {
+	chain, err := parsePEMCertificates(certificateChain)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("No certificates found")
+	}
+	signer, err := parsePEMPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	certPublicKey, err := x509.MarshalPKIXPublicKey(chain[0].PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error encoding certificate public key")
+	}
+	signerPublicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, errors.Wrap(err, "Error encoding private key's public key")
+	}
+	if !bytes.Equal(certPublicKey, signerPublicKey) {
+		return nil, errors.New("The private key does not match the signer's certificate")
+	}
+	return &x509SigningMechanism{
+		trustedCertificates: chain[:1],
+		signer:              signer,
+		signerChain:         chain,
+		signerIdentities:    []string{x509CertificateFingerprint(chain[0])},
+	}, nil
+}
+
+// NewEphemeralX509CertificatesSigningMechanism returns a new X.509 signing mechanism which
+// recognizes _only_ signatures made by one of the PEM-encoded certificates in blob, and returns the identities
+// (SHA-256 fingerprints) of these certificates.
+// The caller must call .Close() on the returned SigningMechanism.
+func NewEphemeralX509CertificatesSigningMechanism(blob []byte) (SigningMechanism, []string, error) {
+	certs, err := parsePEMCertificates(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	identities := []string{}
+	for _, cert := range certs {
+		identities = append(identities, x509CertificateFingerprint(cert))
+	}
+	return &x509SigningMechanism{trustedCertificates: certs}, identities, nil
+}
+
+// NewEphemeralX509CASigningMechanism returns a new X.509 signing mechanism which recognizes
+// _only_ signatures made by certificates issued, possibly through intermediate certificates included in the signature,
+// by one of the PEM-encoded CA certificates in blob.
+// If the signer's certificate restricts its extended key usage, it must allow code signing.
+// The caller must call .Close() on the returned SigningMechanism.
+func NewEphemeralX509CASigningMechanism(blob []byte) (SigningMechanism, error) {
+	certs, err := parsePEMCertificates(blob)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No CA certificates found")
+	}
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		roots.AddCert(cert)
+	}
+	return &x509SigningMechanism{roots: roots}, nil
+}
+
+// parsePEMCertificates returns all certificates in a PEM-encoded blob.
+func parsePEMCertificates(blob []byte) ([]*x509.Certificate, error) {
+	res := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, blob = pem.Decode(blob)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error parsing certificate")
+		}
+		res = append(res, cert)
+	}
+	return res, nil
+}
+
+// parsePEMPrivateKey returns the first private key in a PEM-encoded blob.
+func parsePEMPrivateKey(blob []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, blob = pem.Decode(blob)
+		if block == nil {
+			return nil, errors.New("No private key found")
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error parsing private key")
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case *ecdsa.PrivateKey:
+			return k, nil
+		default:
+			return nil, errors.Errorf("Unsupported private key type %T", key)
+		}
+	}
+}
+
+// x509CertificateFingerprint returns the key identity of cert.
+func x509CertificateFingerprint(cert *x509.Certificate) string {
+	return strings.ToUpper(fmt.Sprintf("%x", sha256.Sum256(cert.Raw)))
+}
+
+// x509SignatureAlgorithm returns the signature algorithm used with publicKey.
+func x509SignatureAlgorithm(publicKey interface{}) (x509.SignatureAlgorithm, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		return x509.ECDSAWithSHA256, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, errors.Errorf("Unsupported public key type %T", publicKey)
+	}
+}
+
+// parseX509Signature parses an x509Signature blob, and returns it and the parsed certificates.
+func parseX509Signature(unverifiedSignature []byte) (*x509Signature, []*x509.Certificate, error) {
+	sig := x509Signature{}
+	if err := json.Unmarshal(unverifiedSignature, &sig); err != nil {
+		return nil, nil, InvalidSignatureError{msg: err.Error()}
+	}
+	if sig.Format != x509SignatureFormat {
+		return nil, nil, InvalidSignatureError{msg: fmt.Sprintf("Unrecognized X.509 signature format %q", sig.Format)}
+	}
+	if len(sig.Certificates) == 0 {
+		return nil, nil, InvalidSignatureError{msg: "No certificates in X.509 signature"}
+	}
+	certs := make([]*x509.Certificate, 0, len(sig.Certificates))
+	for _, der := range sig.Certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, InvalidSignatureError{msg: fmt.Sprintf("Invalid certificate in X.509 signature: %v", err)}
+		}
+		certs = append(certs, cert)
+	}
+	return &sig, certs, nil
+}
+
+// isX509Signature returns true if untrustedSignature seems to be created by the X.509 signing mechanism.
+func isX509Signature(untrustedSignature []byte) bool {
+	sig := x509Signature{}
+	return json.Unmarshal(untrustedSignature, &sig) == nil && sig.Format == x509SignatureFormat
+}
+
+// Close removes resources associated with the mechanism, if any.
+func (m *x509SigningMechanism) Close() error {
+	return nil
+}
+
+// SupportsSigning returns nil if the mechanism supports signing, or a SigningNotSupportedError.
+func (m *x509SigningMechanism) SupportsSigning() error {
+	if m.signer == nil {
+		return SigningNotSupportedError("X.509 signing requires a private key")
+	}
+	return nil
+}
+
+// Sign creates a (non-detached) signature of input using keyIdentity.
+// Fails with a SigningNotSupportedError if the mechanism does not support signing.
+func (m *x509SigningMechanism) Sign(input []byte, keyIdentity string) ([]byte, error) {
+	if err := m.SupportsSigning(); err != nil {
+		return nil, err
+	}
+	if !stringsContainFold(m.signerIdentities, keyIdentity) {
+		return nil, errors.Errorf("Key %s is not available for signing", keyIdentity)
+	}
+	digest := sha256.Sum256(input)
+	signature, err := m.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	sig := x509Signature{
+		Format:    x509SignatureFormat,
+		Payload:   input,
+		Signature: signature,
+	}
+	for _, cert := range m.signerChain {
+		sig.Certificates = append(sig.Certificates, cert.Raw)
+	}
+	return json.Marshal(sig)
+}
+
+// Verify parses unverifiedSignature and returns the content and the signer's identity
+func (m *x509SigningMechanism) Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error) {
+	sig, certs, err := parseX509Signature(unverifiedSignature)
+	if err != nil {
+		return nil, "", err
+	}
+	signer := certs[0]
+	if err := m.verifyCertificate(signer, certs[1:]); err != nil {
+		return nil, "", err
+	}
+	algorithm, err := x509SignatureAlgorithm(signer.PublicKey)
+	if err != nil {
+		return nil, "", InvalidSignatureError{msg: err.Error()}
+	}
+	if err := signer.CheckSignature(algorithm, sig.Payload, sig.Signature); err != nil {
+		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Invalid X.509 signature: %v", err)}
+	}
+	return sig.Payload, x509CertificateFingerprint(signer), nil
+}
+
+// verifyCertificate returns nil if signer, possibly using intermediates, is trusted by m.
+func (m *x509SigningMechanism) verifyCertificate(signer *x509.Certificate, intermediates []*x509.Certificate) error {
+	if m.roots != nil {
+		pool := x509.NewCertPool()
+		for _, cert := range intermediates {
+			pool.AddCert(cert)
+		}
+		if _, err := signer.Verify(x509.VerifyOptions{
+			Roots:         m.roots,
+			Intermediates: pool,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		}); err != nil {
+			return InvalidSignatureError{msg: fmt.Sprintf("Signer's certificate %s is not trusted: %v", signer.Subject, err)}
+		}
+		return nil
+	}
+
+	for _, trusted := range m.trustedCertificates {
+		if signer.Equal(trusted) {
+			if now := time.Now(); now.Before(signer.NotBefore) || now.After(signer.NotAfter) {
+				return InvalidSignatureError{msg: fmt.Sprintf("Signer's certificate %s is not valid at %s", signer.Subject, now)}
+			}
+			return nil
+		}
+	}
+	return InvalidSignatureError{msg: fmt.Sprintf("Signer's certificate %s is not trusted", signer.Subject)}
+}
+
+// UntrustedSignatureContents returns UNTRUSTED contents of the signature WITHOUT ANY VERIFICATION,
+// along with a short identifier of the key used for signing.
+// WARNING: The short key identifier (which correponds to "Key ID" for OpenPGP keys)
+// is NOT the same as a "key identity" used in other calls ot this interface, and
+// the values may have no recognizable relationship if the public key is not available.
+func (m *x509SigningMechanism) UntrustedSignatureContents(untrustedSignature []byte) (untrustedContents []byte, shortKeyIdentifier string, err error) {
+	sig, certs, err := parseX509Signature(untrustedSignature)
+	if err != nil {
+		return nil, "", err
+	}
+	return sig.Payload, x509CertificateFingerprint(certs[0]), nil
+}
+
+// stringsContainFold returns true if list contains s, ignoring case.
+func stringsContainFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// x509TestCertificate is a certificate and its private key, created for tests.
+type x509TestCertificate struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newX509TestCertificate creates a certificate for commonName, issued by parent (self-signed if parent is nil).
+func newX509TestCertificate(t *testing.T, commonName string, isCA bool, extKeyUsage []x509.ExtKeyUsage, parent *x509TestCertificate) *x509TestCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           extKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	issuerCert, issuerKey := template, crypto.Signer(key)
+	if parent != nil {
+		issuerCert, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuerCert, key.Public(), issuerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &x509TestCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func TestNewX509SigningMechanism(t *testing.T) {
+	ca := newX509TestCertificate(t, "CA", true, nil, nil)
+	signer := newX509TestCertificate(t, "signer", false, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, ca)
+
+	mech, err := NewX509SigningMechanism(append(append([]byte{}, signer.certPEM...), ca.certPEM...), signer.keyPEM)
+	require.NoError(t, err)
+	defer mech.Close()
+	assert.NoError(t, mech.SupportsSigning())
+
+	// Missing certificates
+	_, err = NewX509SigningMechanism([]byte{}, signer.keyPEM)
+	assert.Error(t, err)
+	// Missing private key
+	_, err = NewX509SigningMechanism(signer.certPEM, signer.certPEM)
+	assert.Error(t, err)
+	// Private key does not match the certificate
+	_, err = NewX509SigningMechanism(signer.certPEM, ca.keyPEM)
+	assert.Error(t, err)
+
+	// An RSA key in PKCS#8
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaKeyDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	rsaCertDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rsa"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, ca.cert, rsaKey.Public(), ca.key)
+	require.NoError(t, err)
+	rsaCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rsaCertDER})
+	mech, err = NewX509SigningMechanism(rsaCertPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaKeyDER}))
+	require.NoError(t, err)
+	defer mech.Close()
+	rsaCert, err := x509.ParseCertificate(rsaCertDER)
+	require.NoError(t, err)
+	sig, err := mech.Sign([]byte("payload"), x509CertificateFingerprint(rsaCert))
+	require.NoError(t, err)
+	content, identity, err := mech.Verify(sig)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), content)
+	assert.Equal(t, x509CertificateFingerprint(rsaCert), identity)
+}
+
+func TestX509SigningMechanismSign(t *testing.T) {
+	ca := newX509TestCertificate(t, "CA", true, nil, nil)
+	signer := newX509TestCertificate(t, "signer", false, nil, ca)
+	mech, err := NewX509SigningMechanism(signer.certPEM, signer.keyPEM)
+	require.NoError(t, err)
+	defer mech.Close()
+	identity := x509CertificateFingerprint(signer.cert)
+
+	sig, err := mech.Sign([]byte("payload"), identity)
+	require.NoError(t, err)
+	content, signedBy, err := mech.Verify(sig)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), content)
+	assert.Equal(t, identity, signedBy)
+
+	// Key identities are case-insensitive
+	_, err = mech.Sign([]byte("payload"), strings.ToLower(identity))
+	assert.NoError(t, err)
+	// Unknown key identities
+	_, err = mech.Sign([]byte("payload"), "")
+	assert.Error(t, err)
+	_, err = mech.Sign([]byte("payload"), x509CertificateFingerprint(ca.cert))
+	assert.Error(t, err)
+
+	// Verification-only mechanisms can not sign
+	verifier, _, err := NewEphemeralX509CertificatesSigningMechanism(signer.certPEM)
+	require.NoError(t, err)
+	defer verifier.Close()
+	err = verifier.SupportsSigning()
+	assert.IsType(t, SigningNotSupportedError(""), err)
+	_, err = verifier.Sign([]byte("payload"), identity)
+	assert.IsType(t, SigningNotSupportedError(""), err)
+}
+
+func TestX509SigningMechanismVerify(t *testing.T) {
+	ca := newX509TestCertificate(t, "CA", true, nil, nil)
+	intermediate := newX509TestCertificate(t, "intermediate", true, nil, ca)
+	signer := newX509TestCertificate(t, "signer", false, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, intermediate)
+	serverCert := newX509TestCertificate(t, "server", false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, intermediate)
+	otherCA := newX509TestCertificate(t, "other CA", true, nil, nil)
+
+	signerMech, err := NewX509SigningMechanism(append(append([]byte{}, signer.certPEM...), intermediate.certPEM...), signer.keyPEM)
+	require.NoError(t, err)
+	defer signerMech.Close()
+	signerIdentity := x509CertificateFingerprint(signer.cert)
+	sig, err := signerMech.Sign([]byte("payload"), signerIdentity)
+	require.NoError(t, err)
+	serverMech, err := NewX509SigningMechanism(append(append([]byte{}, serverCert.certPEM...), intermediate.certPEM...), serverCert.keyPEM)
+	require.NoError(t, err)
+	defer serverMech.Close()
+	serverSig, err := serverMech.Sign([]byte("payload"), x509CertificateFingerprint(serverCert.cert))
+	require.NoError(t, err)
+
+	// Trusted certificates
+	mech, identities, err := NewEphemeralX509CertificatesSigningMechanism(append(append([]byte{}, ca.certPEM...), signer.certPEM...))
+	require.NoError(t, err)
+	defer mech.Close()
+	assert.Equal(t, []string{x509CertificateFingerprint(ca.cert), signerIdentity}, identities)
+	content, identity, err := mech.Verify(sig)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), content)
+	assert.Equal(t, signerIdentity, identity)
+	_, _, err = mech.Verify(serverSig)
+	assert.IsType(t, InvalidSignatureError{}, err)
+
+	// Trusted CAs
+	mech, err = NewEphemeralX509CASigningMechanism(ca.certPEM)
+	require.NoError(t, err)
+	defer mech.Close()
+	content, identity, err = mech.Verify(sig)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), content)
+	assert.Equal(t, signerIdentity, identity)
+	// Certificates not allowing code signing are rejected
+	_, _, err = mech.Verify(serverSig)
+	assert.IsType(t, InvalidSignatureError{}, err)
+	// Certificates issued by other CAs are rejected
+	otherMech, err := NewEphemeralX509CASigningMechanism(otherCA.certPEM)
+	require.NoError(t, err)
+	defer otherMech.Close()
+	_, _, err = otherMech.Verify(sig)
+	assert.IsType(t, InvalidSignatureError{}, err)
+	// No CA certificates
+	_, err = NewEphemeralX509CASigningMechanism([]byte{})
+	assert.Error(t, err)
+
+	// Invalid or modified signatures
+	var parsed x509Signature
+	require.NoError(t, json.Unmarshal(sig, &parsed))
+	for _, modify := range []func(s *x509Signature){
+		func(s *x509Signature) { s.Payload = []byte("modified") },
+		func(s *x509Signature) { s.Signature = []byte("invalid") },
+		func(s *x509Signature) { s.Format = "unknown" },
+		func(s *x509Signature) { s.Certificates = nil },
+		func(s *x509Signature) { s.Certificates = [][]byte{[]byte("invalid")} },
+		func(s *x509Signature) { s.Certificates = [][]byte{otherCA.cert.Raw} },
+	} {
+		modified := parsed
+		modify(&modified)
+		blob, err := json.Marshal(modified)
+		require.NoError(t, err)
+		_, _, err = mech.Verify(blob)
+		assert.IsType(t, InvalidSignatureError{}, err)
+	}
+	_, _, err = mech.Verify([]byte("not JSON"))
+	assert.IsType(t, InvalidSignatureError{}, err)
+}
+
+func TestX509SigningMechanismUntrustedSignatureContents(t *testing.T) {
+	signer := newX509TestCertificate(t, "signer", false, nil, nil)
+	signerMech, err := NewX509SigningMechanism(signer.certPEM, signer.keyPEM)
+	require.NoError(t, err)
+	defer signerMech.Close()
+	sig, err := signerMech.Sign([]byte("payload"), x509CertificateFingerprint(signer.cert))
+	require.NoError(t, err)
+	assert.True(t, isX509Signature(sig))
+	assert.False(t, isX509Signature([]byte("not JSON")))
+
+	mech, _, err := NewEphemeralX509CertificatesSigningMechanism([]byte{})
+	require.NoError(t, err)
+	defer mech.Close()
+	content, shortKeyID, err := mech.UntrustedSignatureContents(sig)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), content)
+	assert.Equal(t, x509CertificateFingerprint(signer.cert), shortKeyID)
+
+	_, _, err = mech.UntrustedSignatureContents([]byte("not JSON"))
+	assert.Error(t, err)
+}
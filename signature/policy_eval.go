@@ -8,6 +8,7 @@ package signature
 import (
 	"context"
 
+	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -78,7 +79,24 @@ type PolicyReferenceMatch interface {
 // for speeding up its evaluation.
 type PolicyContext struct {
 	Policy *Policy
-	state  policyContextState // Internal consistency checking
+	// SystemContext is used when evaluating the policy requires accessing other images
+	// (e.g. the base image of a signedBaseLayer requirement, or the configuration of the evaluated image); it may be nil.
+	// It is not set automatically, e.g. by copy.Image; callers should set it (typically to the SystemContext used to
+	// access the evaluated image) for base images to be accessed with the right credentials, certificates and registries.conf.
+	SystemContext *types.SystemContext
+	state         policyContextState // Internal consistency checking
+	// openBaseImageSource, if not nil, replaces the docker transport for accessing signedBaseLayer base images.
+	openBaseImageSource func(ctx context.Context, sys *types.SystemContext, name reference.Named) (types.ImageSource, error)
+}
+
+// policyContextKey is the context.Context key used to make the PolicyContext being evaluated
+// available to PolicyRequirement implementations.
+type policyContextKey struct{}
+
+// policyContextFromContext returns the PolicyContext being evaluated in ctx, or nil.
+func policyContextFromContext(ctx context.Context) *PolicyContext {
+	pc, _ := ctx.Value(policyContextKey{}).(*PolicyContext)
+	return pc
 }
 
 // policyContextState is used internally to verify the users are not misusing a PolicyContext.
@@ -186,6 +204,12 @@ func (pc *PolicyContext) GetSignaturesWithAcceptedAuthor(ctx context.Context, im
 		}
 	}()
 
+	return pc.getSignaturesWithAcceptedAuthor(context.WithValue(ctx, policyContextKey{}, pc), image)
+}
+
+// getSignaturesWithAcceptedAuthor is GetSignaturesWithAcceptedAuthor, without the pc.state checks.
+// It can be used by PolicyRequirement implementations while pc is in use.
+func (pc *PolicyContext) getSignaturesWithAcceptedAuthor(ctx context.Context, image types.UnparsedImage) ([]*Signature, error) {
 	logrus.Debugf("GetSignaturesWithAcceptedAuthor for image %s", policyIdentityLogName(image.Reference()))
 	reqs := pc.requirementsForImageRef(image.Reference())
 
@@ -267,6 +291,12 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, image types.
 		}
 	}()
 
+	return pc.isRunningImageAllowed(context.WithValue(ctx, policyContextKey{}, pc), image)
+}
+
+// isRunningImageAllowed is IsRunningImageAllowed, without the pc.state checks.
+// It can be used by PolicyRequirement implementations while pc is in use.
+func (pc *PolicyContext) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
 	logrus.Debugf("IsRunningImageAllowed for image %s", policyIdentityLogName(image.Reference()))
 	reqs := pc.requirementsForImageRef(image.Reference())
 
@@ -4,17 +4,230 @@ package signature
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// baseImageNameAnnotation is the OCI annotation (or, for images without manifest annotations, config label)
+// recording the reference of the base image an image was built from.
+const baseImageNameAnnotation = "org.opencontainers.image.base.name"
+
+// maxBaseLayerDepth limits how many signedBaseLayer requirements can be evaluated recursively,
+// e.g. if the policy for a base image itself uses signedBaseLayer.
+const maxBaseLayerDepth = 8
+
+// baseLayerDepthKey is the context.Context key recording the current signedBaseLayer recursion depth.
+type baseLayerDepthKey struct{}
+
+// openBaseImageSource returns an ImageSource for the base image name, using the docker transport.
+func openBaseImageSource(ctx context.Context, sys *types.SystemContext, name reference.Named) (types.ImageSource, error) {
+	transport := transports.Get("docker")
+	if transport == nil {
+		return nil, errors.New(`The "docker" transport, needed to access base images, is not available`)
+	}
+	ref, err := transport.ParseReference("//" + name.String())
+	if err != nil {
+		return nil, err
+	}
+	return ref.NewImageSource(ctx, sys)
+}
+
 func (pr *prSignedBaseLayer) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
 	return sarUnknown, nil, nil
 }
 
-func (pr *prSignedBaseLayer) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
-	// FIXME? Reject this at policy parsing time already?
-	logrus.Errorf("signedBaseLayer not implemented yet!")
-	return false, PolicyRequirementError("signedBaseLayer not implemented yet!")
+func (pr *prSignedBaseLayer) isRunningImageAllowed(ctx context.Context, img types.UnparsedImage) (bool, error) {
+	pc := policyContextFromContext(ctx)
+	if pc == nil {
+		return false, errors.New("signedBaseLayer can only be evaluated through a PolicyContext")
+	}
+	depth, _ := ctx.Value(baseLayerDepthKey{}).(int)
+	if depth >= maxBaseLayerDepth {
+		return false, PolicyRequirementError(fmt.Sprintf("Too many nested signedBaseLayer requirements (%d)", depth))
+	}
+	ctx = context.WithValue(ctx, baseLayerDepthKey{}, depth+1)
+
+	baseName, err := pr.baseImageName(ctx, pc.SystemContext, img)
+	if err != nil {
+		return false, err
+	}
+	logrus.Debugf("Evaluating base image %s", baseName.String())
+
+	openBase := openBaseImageSource
+	if pc.openBaseImageSource != nil {
+		openBase = pc.openBaseImageSource
+	}
+	src, err := openBase(ctx, pc.SystemContext, baseName)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error opening base image %s", baseName.String())
+	}
+	defer src.Close()
+	base := image.UnparsedInstance(src, nil)
+	baseManifest, baseMIMEType, err := base.Manifest(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error reading manifest of base image %s", baseName.String())
+	}
+	if manifest.MIMETypeIsMultiImage(baseMIMEType) {
+		instanceDigest, err := image.ChooseManifestInstanceFromManifestList(ctx, pc.SystemContext, base)
+		if err != nil {
+			return false, errors.Wrapf(err, "Error choosing an instance of base image %s", baseName.String())
+		}
+		base = image.UnparsedInstance(src, &instanceDigest)
+		if baseManifest, baseMIMEType, err = base.Manifest(ctx); err != nil {
+			return false, errors.Wrapf(err, "Error reading manifest of base image %s", baseName.String())
+		}
+	}
+
+	if allowed, err := pc.isRunningImageAllowed(ctx, base); !allowed {
+		return false, err
+	}
+	sigs, err := pc.getSignaturesWithAcceptedAuthor(ctx, base)
+	if err != nil {
+		return false, err
+	}
+	signed := false
+	for _, sig := range sigs {
+		if pr.BaseLayerIdentity.matchesDockerReference(base, sig.DockerReference) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return false, PolicyRequirementError(fmt.Sprintf("Base image %s has no accepted signature matching baseLayerIdentity", baseName.String()))
+	}
+
+	return pr.layersMatchBaseImage(ctx, img, baseManifest, baseMIMEType)
+}
+
+// baseImageName returns the name of the base image of img, as required by pr.BaseLayerIdentity.
+func (pr *prSignedBaseLayer) baseImageName(ctx context.Context, sys *types.SystemContext, img types.UnparsedImage) (reference.Named, error) {
+	if exact, ok := pr.BaseLayerIdentity.(*prmExactReference); ok {
+		name, err := reference.ParseNormalizedNamed(exact.DockerReference)
+		if err != nil {
+			return nil, PolicyRequirementError(fmt.Sprintf("Invalid base image reference %s: %s", exact.DockerReference, err.Error()))
+		}
+		return name, nil
+	}
+
+	value, err := baseImageNameFromImage(ctx, sys, img)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, PolicyRequirementError(fmt.Sprintf("Image does not record its base image in %s, and baseLayerIdentity does not specify one", baseImageNameAnnotation))
+	}
+	name, err := reference.ParseNormalizedNamed(value)
+	if err != nil {
+		return nil, PolicyRequirementError(fmt.Sprintf("Invalid base image reference %s: %s", value, err.Error()))
+	}
+	if !pr.BaseLayerIdentity.matchesDockerReference(img, name.String()) {
+		return nil, PolicyRequirementError(fmt.Sprintf("Base image %s is not accepted by baseLayerIdentity", name.String()))
+	}
+	return name, nil
+}
+
+// baseImageNameFromImage returns the value of baseImageNameAnnotation in the manifest or the config of img, or "" if it is not set.
+func baseImageNameFromImage(ctx context.Context, sys *types.SystemContext, unparsed types.UnparsedImage) (string, error) {
+	m, mimeType, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return "", PolicyRequirementError("signedBaseLayer can not be evaluated for manifest lists")
+	}
+	if manifest.NormalizedMIMEType(mimeType) == imgspecv1.MediaTypeImageManifest {
+		oci, err := manifest.OCI1FromManifest(m)
+		if err != nil {
+			return "", err
+		}
+		if value, ok := oci.Annotations[baseImageNameAnnotation]; ok {
+			return value, nil
+		}
+	}
+	parsed, err := manifest.FromBlob(m, mimeType)
+	if err != nil {
+		return "", err
+	}
+	src, err := unparsed.Reference().NewImageSource(ctx, sys)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error opening %s to read its configuration", transports.ImageName(unparsed.Reference()))
+	}
+	defer src.Close()
+	info, err := parsed.Inspect(func(configInfo types.BlobInfo) ([]byte, error) {
+		return readConfigBlob(ctx, src, configInfo)
+	})
+	if err != nil {
+		return "", err
+	}
+	return info.Labels[baseImageNameAnnotation], nil
+}
+
+// readConfigBlob reads the config blob described by configInfo from src, verifying its digest.
+func readConfigBlob(ctx context.Context, src types.ImageSource, configInfo types.BlobInfo) ([]byte, error) {
+	stream, _, err := image.NewVerifyingSource(src).GetBlob(ctx, configInfo, blobinfocache.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return ioutil.ReadAll(stream)
+}
+
+// layersMatchBaseImage returns true if the bottom layers of img are the layers of base, with the same (compressed) digests.
+// Uncompressed digests recorded in the config of img are not used: nothing guarantees that they match the layers of img.
+func (pr *prSignedBaseLayer) layersMatchBaseImage(ctx context.Context, img types.UnparsedImage, baseManifest []byte, baseMIMEType string) (bool, error) {
+	m, mimeType, err := img.Manifest(ctx)
+	if err != nil {
+		return false, err
+	}
+	layers, err := manifestLayerDigests(m, mimeType)
+	if err != nil {
+		return false, err
+	}
+	baseLayers, err := manifestLayerDigests(baseManifest, baseMIMEType)
+	if err != nil {
+		return false, err
+	}
+	if !digestsHavePrefix(layers, baseLayers) {
+		return false, PolicyRequirementError("Image layers do not match the layers of the base image")
+	}
+	return true, nil
+}
+
+// manifestLayerDigests returns the digests of non-empty layers in a manifest.
+func manifestLayerDigests(m []byte, mimeType string) ([]digest.Digest, error) {
+	parsed, err := manifest.FromBlob(m, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	res := []digest.Digest{}
+	for _, layer := range parsed.LayerInfos() {
+		if !layer.EmptyLayer {
+			res = append(res, layer.Digest)
+		}
+	}
+	return res, nil
+}
+
+// digestsHavePrefix returns true if prefix is a non-empty prefix of digests.
+func digestsHavePrefix(digests, prefix []digest.Digest) bool {
+	if len(prefix) == 0 || len(prefix) > len(digests) {
+		return false
+	}
+	for i := range prefix {
+		if digests[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
 }
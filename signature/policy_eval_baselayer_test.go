@@ -2,8 +2,18 @@ package signature
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,11 +25,173 @@ func TestPRSignedBaseLayerIsSignatureAuthorAccepted(t *testing.T) {
 	assertSARUnknown(t, sar, parsedSig, err)
 }
 
+// baseImageDirMock sets up pc to use directories for the specified base image names.
+func baseImageDirMock(t *testing.T, pc *PolicyContext, dirs map[string]string) {
+	pc.openBaseImageSource = func(ctx context.Context, sys *types.SystemContext, name reference.Named) (types.ImageSource, error) {
+		dir, ok := dirs[name.String()]
+		require.True(t, ok, name.String())
+		ref, err := directory.NewReference(dir)
+		require.NoError(t, err)
+		src, err := ref.NewImageSource(ctx, sys)
+		require.NoError(t, err)
+		return &dirImageSourceMock{ImageSource: src, ref: pcImageReferenceMock{"docker", name}}, nil
+	}
+}
+
+// pcDirReferenceMock is a pcImageReferenceMock which can also be used to read an image from a directory.
+type pcDirReferenceMock struct {
+	pcImageReferenceMock
+	dir string
+}
+
+func (ref pcDirReferenceMock) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	dirRef, err := directory.NewReference(ref.dir)
+	if err != nil {
+		return nil, err
+	}
+	return dirRef.NewImageSource(ctx, sys)
+}
+
+// unparsedImageWrapper hides the implementation of a types.UnparsedImage.
+type unparsedImageWrapper struct {
+	types.UnparsedImage
+}
+
 func TestPRSignedBaseLayerIsRunningImageAllowed(t *testing.T) {
-	// This will obviously need to change after signedBaseLayer is implemented.
-	pr, err := NewPRSignedBaseLayer(NewPRMMatchRepository())
+	// Outside of a PolicyContext, the base image can not be evaluated.
+	pr, err := NewPRSignedBaseLayer(xNewPRMExactReference("testing/manifest:latest"))
+	require.NoError(t, err)
+	img, closer := pcImageMock(t, "fixtures/dir-img-valid", "testing/app:latest")
+	defer closer()
+	res, err := pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, res, err)
+
+	pc, err := NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing/manifest:latest": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact()),
+				},
+				"docker.io/testing/manifest:unsigned": {
+					NewPRInsecureAcceptAnything(),
+				},
+				"docker.io/testing/app:latest": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("testing/manifest:latest")),
+				},
+				"docker.io/testing/app:unsignedBase": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("testing/manifest:unsigned")),
+				},
+				"docker.io/testing/app:rejectedBase": {
+					xNewPRSignedBaseLayer(xNewPRMExactReference("testing/manifest:rejected")),
+				},
+				"docker.io/testing/app:unknownBase": {
+					xNewPRSignedBaseLayer(NewPRMMatchRepository()),
+				},
+				"docker.io/testing/manifest:app": {
+					xNewPRSignedBaseLayer(NewPRMMatchRepository()),
+				},
+			},
+		},
+	})
 	require.NoError(t, err)
-	// Pass a nil pointer to, kind of, test that the return value does not depend on the image.
-	res, err := pr.isRunningImageAllowed(context.Background(), nil)
+	defer pc.Destroy()
+	baseImageDirMock(t, pc, map[string]string{
+		"docker.io/testing/manifest:latest":   "fixtures/dir-img-valid",
+		"docker.io/testing/manifest:unsigned": "fixtures/dir-img-unsigned",
+		"docker.io/testing/manifest:rejected": "fixtures/dir-img-valid",
+	})
+
+	// An image identical to the base image
+	img, closer = pcImageMock(t, "fixtures/dir-img-valid", "testing/app:latest")
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, res, err)
+
+	// An image with an extra layer on top of the base image
+	tmpDir, err := ioutil.TempDir("", "signedBaseLayer")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	manifest, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	extraLayer := `{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": 1, "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111"}`
+	appManifest := strings.Replace(string(manifest), "\n    ]", ",\n"+extraLayer+"\n    ]", 1)
+	appDir := filepath.Join(tmpDir, "app")
+	require.NoError(t, os.Mkdir(appDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(appDir, "manifest.json"), []byte(appManifest), 0644))
+	img, closer = pcImageMock(t, appDir, "testing/app:latest")
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, res, err)
+
+	// An image with a different bottom layer; uncompressed digests in its config would not be trusted.
+	otherManifest := strings.Replace(string(manifest), "e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+		"1111111111111111111111111111111111111111111111111111111111111111", 1)
+	otherDir := filepath.Join(tmpDir, "other")
+	require.NoError(t, os.Mkdir(otherDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(otherDir, "manifest.json"), []byte(otherManifest), 0644))
+	img, closer = pcImageMock(t, otherDir, "testing/app:latest")
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, res, err)
+
+	// The base image is allowed but not signed
+	img, closer = pcImageMock(t, "fixtures/dir-img-valid", "testing/app:unsignedBase")
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, res, err)
+
+	// The base image is rejected by the policy
+	img, closer = pcImageMock(t, "fixtures/dir-img-valid", "testing/app:rejectedBase")
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejectedPolicyRequirement(t, res, err)
+
+	// The base image can not be determined (the config of the image is missing)
+	unknownBaseRef, err := reference.ParseNormalizedNamed("testing/app:unknownBase")
+	require.NoError(t, err)
+	img, closer = dirImageMockWithRef(t, "fixtures/dir-img-valid", pcDirReferenceMock{pcImageReferenceMock{"docker", unknownBaseRef}, "fixtures/dir-img-valid"})
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, res, err)
+
+	// The base image is recorded in a config label, and the image is not created by the image package
+	config := []byte(`{"architecture":"amd64","os":"linux","config":{"Labels":{"org.opencontainers.image.base.name":"docker.io/testing/manifest:latest"}},"rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest := digest.FromBytes(config)
+	labeledManifest := strings.Replace(string(manifest), `"size": 7023,
+        "digest": "sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7"`,
+		fmt.Sprintf(`"size": %d,
+        "digest": "%s"`, len(config), configDigest), 1)
+	require.NotEqual(t, string(manifest), labeledManifest)
+	labeledDir := filepath.Join(tmpDir, "labeled")
+	require.NoError(t, os.Mkdir(labeledDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(labeledDir, "manifest.json"), []byte(labeledManifest), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(labeledDir, configDigest.Hex()), config, 0644))
+	labeledRef, err := reference.ParseNormalizedNamed("testing/manifest:app")
+	require.NoError(t, err)
+	unparsed, closer := dirImageMockWithRef(t, labeledDir, pcDirReferenceMock{pcImageReferenceMock{"docker", labeledRef}, labeledDir})
+	defer closer()
+	res, err = pc.IsRunningImageAllowed(context.Background(), unparsedImageWrapper{unparsed})
+	assertRunningAllowed(t, res, err)
+}
+
+func TestDigestsHavePrefix(t *testing.T) {
+	d1 := digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	d2 := digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	d3 := digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	for _, c := range []struct {
+		digests, prefix []digest.Digest
+		expected        bool
+	}{
+		{[]digest.Digest{d1, d2, d3}, []digest.Digest{d1}, true},
+		{[]digest.Digest{d1, d2, d3}, []digest.Digest{d1, d2}, true},
+		{[]digest.Digest{d1, d2, d3}, []digest.Digest{d1, d2, d3}, true},
+		{[]digest.Digest{d1, d2, d3}, []digest.Digest{d2}, false},
+		{[]digest.Digest{d1, d2, d3}, []digest.Digest{d1, d3}, false},
+		{[]digest.Digest{d1}, []digest.Digest{d1, d2}, false},
+		{[]digest.Digest{d1, d2, d3}, []digest.Digest{}, false},
+		{[]digest.Digest{}, []digest.Digest{}, false},
+	} {
+		assert.Equal(t, c.expected, digestsHavePrefix(c.digests, c.prefix), "%#v, %#v", c.digests, c.prefix)
+	}
 }
@@ -17,8 +17,8 @@ import (
 
 func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
 	switch pr.KeyType {
-	case SBKeyTypeGPGKeys:
-	case SBKeyTypeSignedByGPGKeys, SBKeyTypeX509Certificates, SBKeyTypeSignedByX509CAs:
+	case SBKeyTypeGPGKeys, SBKeyTypeX509Certificates, SBKeyTypeSignedByX509CAs:
+	case SBKeyTypeSignedByGPGKeys:
 		// FIXME? Reject this at policy parsing time already?
 		return sarRejected, nil, errors.Errorf(`"Unimplemented "keyType" value "%s"`, string(pr.KeyType))
 	default:
@@ -42,24 +42,51 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types
 	}
 
 	// FIXME: move this to per-context initialization
-	mech, trustedIdentities, err := NewEphemeralGPGSigningMechanism(data)
-	if err != nil {
-		return sarRejected, nil, err
-	}
-	defer mech.Close()
-	if len(trustedIdentities) == 0 {
-		return sarRejected, nil, PolicyRequirementError("No public keys imported")
+	var mech SigningMechanism
+	var trustedIdentities []string // nil if any identity verified by mech is trusted
+	switch pr.KeyType {
+	case SBKeyTypeGPGKeys:
+		m, identities, err := NewEphemeralGPGSigningMechanism(data)
+		if err != nil {
+			return sarRejected, nil, err
+		}
+		defer m.Close()
+		if len(identities) == 0 {
+			return sarRejected, nil, PolicyRequirementError("No public keys imported")
+		}
+		mech, trustedIdentities = m, identities
+	case SBKeyTypeX509Certificates:
+		m, identities, err := NewEphemeralX509CertificatesSigningMechanism(data)
+		if err != nil {
+			return sarRejected, nil, err
+		}
+		defer m.Close()
+		if len(identities) == 0 {
+			return sarRejected, nil, PolicyRequirementError("No certificates imported")
+		}
+		mech, trustedIdentities = m, identities
+	case SBKeyTypeSignedByX509CAs:
+		// The mechanism only accepts signatures by certificates issued by the CAs, so any identity it returns is trusted.
+		m, err := NewEphemeralX509CASigningMechanism(data)
+		if err != nil {
+			return sarRejected, nil, err
+		}
+		defer m.Close()
+		mech = m
 	}
 
 	signature, err := verifyAndExtractSignature(mech, sig, signatureAcceptanceRules{
 		validateKeyIdentity: func(keyIdentity string) error {
+			if trustedIdentities == nil {
+				return nil
+			}
 			for _, trustedIdentity := range trustedIdentities {
 				if keyIdentity == trustedIdentity {
 					return nil
 				}
 			}
-			// Coverage: We use a private GPG home directory and only import trusted keys, so this should
-			// not be reachable.
+			// Coverage: We use a private GPG home directory / a set of certificates and only import trusted keys,
+			// so this should not be reachable.
 			return PolicyRequirementError(fmt.Sprintf("Signature by key %s is not accepted", keyIdentity))
 		},
 		validateSignedDockerReference: func(ref string) error {
@@ -2,6 +2,7 @@ package signature
 
 import (
 	"context"
+	"crypto/x509"
 	"io/ioutil"
 	"os"
 	"path"
@@ -75,8 +76,6 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 
 	// Unimplemented and invalid KeyType values
 	for _, keyType := range []sbKeyType{SBKeyTypeSignedByGPGKeys,
-		SBKeyTypeX509Certificates,
-		SBKeyTypeSignedByX509CAs,
 		sbKeyType("This is invalid"),
 	} {
 		// Do not use NewPRSignedByKeyData, because it would reject invalid values.
@@ -201,6 +200,68 @@ func createInvalidSigDir(t *testing.T) string {
 	return dir
 }
 
+func TestPRSignedByIsSignatureAuthorAcceptedX509(t *testing.T) {
+	prm := NewPRMMatchExact()
+	testImage, closer := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer closer()
+	m, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+
+	ca := newX509TestCertificate(t, "CA", true, nil, nil)
+	signer := newX509TestCertificate(t, "signer", false, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, ca)
+	otherCA := newX509TestCertificate(t, "other CA", true, nil, nil)
+	mech, err := NewX509SigningMechanism(signer.certPEM, signer.keyPEM)
+	require.NoError(t, err)
+	defer mech.Close()
+	sig, err := SignDockerManifest(m, "testing/manifest:latest", mech, x509CertificateFingerprint(signer.cert))
+	require.NoError(t, err)
+	expected := Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	}
+
+	// Trusted certificates
+	pr, err := NewPRSignedByKeyData(SBKeyTypeX509Certificates, signer.certPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARAccepted(t, sar, parsedSig, err, expected)
+	pr, err = NewPRSignedByKeyData(SBKeyTypeX509Certificates, ca.certPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejected(t, sar, parsedSig, err)
+	// No certificates
+	pr, err = NewPRSignedByKeyData(SBKeyTypeX509Certificates, []byte("no certificates"), prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// Trusted CAs
+	pr, err = NewPRSignedByKeyData(SBKeyTypeSignedByX509CAs, ca.certPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARAccepted(t, sar, parsedSig, err, expected)
+	pr, err = NewPRSignedByKeyData(SBKeyTypeSignedByX509CAs, otherCA.certPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, sig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// A GPG signature is not accepted by X.509 requirements
+	gpgSig, err := ioutil.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	pr, err = NewPRSignedByKeyData(SBKeyTypeSignedByX509CAs, ca.certPEM, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, gpgSig)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// Signature for a different identity
+	pr, err = NewPRSignedByKeyData(SBKeyTypeSignedByX509CAs, ca.certPEM, prm)
+	require.NoError(t, err)
+	otherImage, closer := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:notlatest")
+	defer closer()
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), otherImage, sig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+}
+
 func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	ktGPG := SBKeyTypeGPGKeys
 	prm := NewPRMMatchExact()
@@ -249,10 +249,19 @@ func verifyAndExtractSignature(mech SigningMechanism, unverifiedSignature []byte
 // There is NO REASON to expect the values to be correct, or not intentionally misleading
 // (including things like “✅ Verified by $authority”)
 func GetUntrustedSignatureInformationWithoutVerifying(untrustedSignatureBytes []byte) (*UntrustedSignatureInformation, error) {
-	// NOTE: This should eventualy do format autodetection.
-	mech, _, err := NewEphemeralGPGSigningMechanism([]byte{})
-	if err != nil {
-		return nil, err
+	var mech SigningMechanism
+	if isX509Signature(untrustedSignatureBytes) {
+		m, _, err := NewEphemeralX509CertificatesSigningMechanism([]byte{})
+		if err != nil {
+			return nil, err
+		}
+		mech = m
+	} else {
+		m, _, err := NewEphemeralGPGSigningMechanism([]byte{})
+		if err != nil {
+			return nil, err
+		}
+		mech = m
 	}
 	defer mech.Close()
 
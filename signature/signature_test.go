@@ -397,6 +397,21 @@ func TestGetUntrustedSignatureInformationWithoutVerifying(t *testing.T) {
 	assert.Nil(t, info.UntrustedTimestamp)
 	assert.Equal(t, TestKeyShortID, info.UntrustedShortKeyIdentifier)
 
+	// Successful parsing of an X.509 signature
+	signer := newX509TestCertificate(t, "signer", false, nil, nil)
+	mech, err := NewX509SigningMechanism(signer.certPEM, signer.keyPEM)
+	require.NoError(t, err)
+	defer mech.Close()
+	manifest, err := ioutil.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	signature, err = SignDockerManifest(manifest, TestImageSignatureReference, mech, x509CertificateFingerprint(signer.cert))
+	require.NoError(t, err)
+	info, err = GetUntrustedSignatureInformationWithoutVerifying(signature)
+	require.NoError(t, err)
+	assert.Equal(t, TestImageSignatureReference, info.UntrustedDockerReference)
+	assert.Equal(t, TestImageManifestDigest, info.UntrustedDockerManifestDigest)
+	assert.Equal(t, x509CertificateFingerprint(signer.cert), info.UntrustedShortKeyIdentifier)
+
 	// Completely invalid signature.
 	_, err = GetUntrustedSignatureInformationWithoutVerifying([]byte{})
 	assert.Error(t, err)
@@ -395,10 +395,10 @@ func TestWriteRead(t *testing.T) {
 		manifest = strings.Replace(manifest, "%li", li, -1)
 		manifest = strings.Replace(manifest, "%ci", sum.Hex(), -1)
 		t.Logf("this manifest is %q", manifest)
-		if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+		if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 			t.Fatalf("Error saving manifest to destination: %v", err)
 		}
-		if err := dest.PutSignatures(context.Background(), signatures); err != nil {
+		if err := dest.PutSignatures(context.Background(), signatures, nil); err != nil {
 			t.Fatalf("Error saving signatures to destination: %v", err)
 		}
 		if err := dest.Commit(context.Background()); err != nil {
@@ -557,7 +557,7 @@ func TestDuplicateName(t *testing.T) {
 		    ]
 		}
 	`, digest, size)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); err != nil {
@@ -592,7 +592,7 @@ func TestDuplicateName(t *testing.T) {
 		    ]
 		}
 	`, digest, size)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); err != nil {
@@ -644,7 +644,7 @@ func TestDuplicateID(t *testing.T) {
 		    ]
 		}
 	`, digest, size)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); err != nil {
@@ -679,7 +679,7 @@ func TestDuplicateID(t *testing.T) {
 		    ]
 		}
 	`, digest, size)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); errors.Cause(err) != storage.ErrDuplicateID {
@@ -734,7 +734,7 @@ func TestDuplicateNameID(t *testing.T) {
 		    ]
 		}
 	`, digest, size)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); err != nil {
@@ -769,7 +769,7 @@ func TestDuplicateNameID(t *testing.T) {
 		    ]
 		}
 	`, digest, size)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); errors.Cause(err) != storage.ErrDuplicateID {
@@ -890,7 +890,7 @@ func TestSize(t *testing.T) {
 		    ]
 		}
 	`, configInfo.Size, configInfo.Digest, digest1, size1, digest2, size2)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); err != nil {
@@ -1001,7 +1001,7 @@ func TestDuplicateBlob(t *testing.T) {
 		    ]
 		}
 	`, configInfo.Size, configInfo.Digest, digest1, size1, digest2, size2, digest1, size1, digest2, size2)
-	if err := dest.PutManifest(context.Background(), []byte(manifest)); err != nil {
+	if err := dest.PutManifest(context.Background(), []byte(manifest), nil); err != nil {
 		t.Fatalf("Error storing manifest to destination: %v", err)
 	}
 	if err := dest.Commit(context.Background()); err != nil {
@@ -0,0 +1,417 @@
+// Package sync synchronizes sets of images, e.g. whole repositories, to a destination, using copy.Image.
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/copy"
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Image is a single image to be synchronized.
+type Image struct {
+	Source types.ImageReference
+	// Name identifies the image relative to the destination prefix, as a repository path with a ":tag" or "@digest"
+	// suffix, e.g. "library/busybox:latest".
+	Name string
+}
+
+// Destination describes where images are synchronized to.
+type Destination struct {
+	Transport types.ImageTransport
+	// Prefix is prepended, followed by "/", to Image.Name, and the result is parsed by Transport to create the destination
+	// reference; e.g. "//registry.example.com/mirror" for docker.Transport, or "/var/mirror" for directory.Transport.
+	Prefix string
+}
+
+// Status is the result of synchronizing an image.
+type Status int
+
+const (
+	// Copied means that the image has been copied to the destination.
+	Copied Status = iota
+	// Skipped means that the image has not been copied, because it already exists at the destination.
+	Skipped
+	// Failed means that the image could not be synchronized; ImageResult.Err describes the failure.
+	Failed
+)
+
+// String returns a human-readable description of s.
+func (s Status) String() string {
+	switch s {
+	case Copied:
+		return "copied"
+	case Skipped:
+		return "skipped"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// ImageResult is the result of synchronizing a single image.
+type ImageResult struct {
+	Image       Image
+	Destination types.ImageReference // nil if the destination reference could not be created
+	Status      Status
+	// ManifestDigest is the digest of the manifest at the destination, if the image was copied or skipped.
+	ManifestDigest digest.Digest
+	Err            error
+}
+
+// Report is the result of Images.
+type Report struct {
+	Images []ImageResult
+}
+
+// Err returns an error summarizing the failures in r, or nil if all images were successfully synchronized.
+func (r *Report) Err() error {
+	failed := []string{}
+	for _, result := range r.Images {
+		if result.Status == Failed {
+			failed = append(failed, fmt.Sprintf("%s: %v", result.Image.Name, result.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("Error synchronizing %d of %d images: %s", len(failed), len(r.Images), strings.Join(failed, "; "))
+}
+
+// RepositoryImages returns the images in repo (in a registry) with tags matching tagFilter, or all tags if tagFilter is nil.
+func RepositoryImages(ctx context.Context, sys *types.SystemContext, repo reference.Named, tagFilter *regexp.Regexp) ([]Image, error) {
+	repoRef, err := docker.NewReference(reference.TagNameOnly(reference.TrimNamed(repo)))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := docker.GetRepositoryTags(ctx, sys, repoRef)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error listing tags of %s", repo.Name())
+	}
+	images := []Image{}
+	for _, tag := range tags {
+		if tagFilter != nil && !tagFilter.MatchString(tag) {
+			logrus.Debugf("Skipping tag %s of %s, not matching the filter", tag, repo.Name())
+			continue
+		}
+		tagged, err := reference.WithTag(reference.TrimNamed(repo), tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid tag %q in %s", tag, repo.Name())
+		}
+		ref, err := docker.NewReference(tagged)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, Image{Source: ref, Name: reference.Path(tagged) + ":" + tag})
+	}
+	return images, nil
+}
+
+// ReferenceImages returns images for refs, which must have tagged or digested Docker references.
+func ReferenceImages(refs []types.ImageReference) ([]Image, error) {
+	images := []Image{}
+	for _, ref := range refs {
+		named := ref.DockerReference()
+		var name string
+		if tagged, ok := named.(reference.NamedTagged); ok {
+			name = reference.Path(tagged) + ":" + tagged.Tag()
+		} else if digested, ok := named.(reference.Canonical); ok {
+			name = reference.Path(digested) + "@" + digested.Digest().String()
+		} else {
+			return nil, errors.Errorf("Can not determine a name for %s, it has no tagged or digested Docker reference", transports.ImageName(ref))
+		}
+		images = append(images, Image{Source: ref, Name: name})
+	}
+	return images, nil
+}
+
+// DirectoryImages returns the images in dir: format stored in subdirectories of root.  The path of each image directory
+// relative to root is used as its name, e.g. "ns/image:tag"; if it has no ":tag" suffix, ":latest" is used.
+func DirectoryImages(root string) ([]Image, error) {
+	images := []Image{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(p, "manifest.json")); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == "." {
+			return errors.Errorf("%s contains an image itself, not images in subdirectories", root)
+		}
+		if !strings.Contains(path.Base(name), ":") {
+			name += ":latest"
+		}
+		ref, err := directory.NewReference(p)
+		if err != nil {
+			return err
+		}
+		images = append(images, Image{Source: ref, Name: name})
+		return filepath.SkipDir // Image directories do not contain other images
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error looking for images in %s", root)
+	}
+	return images, nil
+}
+
+// Images copies images to dest, skipping those which already exist at the destination (with the same config and layers,
+// even if copy.Image would rewrite the manifest), using policyContext to validate source image admissibility.  All images are copied using options, if not nil, and share
+// a single BlobInfoCache (options.BlobInfoCache, or blobinfocache.DefaultCache(options.DestinationCtx)).
+// Failures to synchronize individual images do not stop synchronizing the others; they are recorded in the returned
+// report, and can be summarized using Report.Err.
+func Images(ctx context.Context, policyContext *signature.PolicyContext, images []Image, dest Destination, options *copy.Options) *Report {
+	copyOptions := copy.Options{}
+	if options != nil {
+		copyOptions = *options
+	}
+	if copyOptions.BlobInfoCache == nil {
+		copyOptions.BlobInfoCache = blobinfocache.DefaultCache(copyOptions.DestinationCtx)
+	}
+
+	report := &Report{}
+	for _, img := range images {
+		result := ImageResult{Image: img}
+		result.Destination, result.Status, result.ManifestDigest, result.Err = syncImage(ctx, policyContext, img, dest, &copyOptions)
+		if result.Err != nil {
+			result.Status = Failed
+			logrus.Debugf("Error synchronizing %s: %v", img.Name, result.Err)
+		}
+		report.Images = append(report.Images, result)
+	}
+	return report
+}
+
+// syncImage synchronizes a single img to dest, and returns the destination reference, the result status and the manifest digest.
+func syncImage(ctx context.Context, policyContext *signature.PolicyContext, img Image, dest Destination, options *copy.Options) (types.ImageReference, Status, digest.Digest, error) {
+	destRef, err := dest.Transport.ParseReference(dest.Prefix + "/" + img.Name)
+	if err != nil {
+		return nil, Failed, "", errors.Wrapf(err, "Error creating destination reference for %s", img.Name)
+	}
+
+	src, err := img.Source.NewImageSource(ctx, options.SourceCtx)
+	if err != nil {
+		return destRef, Failed, "", errors.Wrapf(err, "Error initializing source %s", transports.ImageName(img.Source))
+	}
+	defer src.Close()
+	srcManifest, srcMIMEType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return destRef, Failed, "", errors.Wrapf(err, "Error reading manifest for %s", transports.ImageName(img.Source))
+	}
+
+	if destDigest, ok := existingImageDigest(ctx, src, srcManifest, srcMIMEType, destRef, options); ok {
+		logrus.Debugf("Skipping %s, it already exists at %s as manifest %s", img.Name, transports.ImageName(destRef), destDigest)
+		return destRef, Skipped, destDigest, nil
+	}
+
+	m, err := copy.Image(ctx, policyContext, destRef, openedSourceReference{ImageReference: img.Source, src: src}, options)
+	if err != nil {
+		return destRef, Failed, "", errors.Wrapf(err, "Error copying %s to %s", transports.ImageName(img.Source), transports.ImageName(destRef))
+	}
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return destRef, Failed, "", err
+	}
+	return destRef, Copied, manifestDigest, nil
+}
+
+// existingImageDigest returns the digest of the manifest at destRef, and true, if it exists and destinationMatches
+// the source src, which has srcManifest and srcMIMEType.
+func existingImageDigest(ctx context.Context, src types.ImageSource, srcManifest []byte, srcMIMEType string, destRef types.ImageReference, options *copy.Options) (digest.Digest, bool) {
+	dest, err := destRef.NewImageSource(ctx, options.DestinationCtx)
+	if err != nil {
+		logrus.Debugf("Assuming %s does not exist: %v", transports.ImageName(destRef), err)
+		return "", false
+	}
+	defer dest.Close()
+	destManifest, destMIMEType, err := dest.GetManifest(ctx, nil)
+	if err != nil {
+		logrus.Debugf("Assuming %s does not exist: %v", transports.ImageName(destRef), err)
+		return "", false
+	}
+	destDigest, err := manifest.Digest(destManifest)
+	if err != nil {
+		return "", false
+	}
+	matches, err := destinationMatches(ctx, src, srcManifest, srcMIMEType, dest, destManifest, destMIMEType, options)
+	if err != nil {
+		logrus.Debugf("Error comparing %s with the source, copying it again: %v", transports.ImageName(destRef), err)
+		return "", false
+	}
+	return destDigest, matches
+}
+
+// destinationMatches returns true if the destination dest, with destManifest and destMIMEType, already contains the
+// images copy.Image would copy from src, with srcManifest and srcMIMEType, using options.
+// copy.Image may rewrite manifests (e.g. converting them to a format the destination supports), so images with different
+// manifests are considered equal if they have the same layers and equivalent configs (see sameImage).  Manifest lists are
+// compared instance by instance with copy.CopyAllImages; with copy.CopySpecificImages, only identical manifest lists are
+// detected.  If the destination contains a single image, it is compared with the image copy.Image would choose for the
+// current system, as it does for destinations which can not store manifest lists.
+func destinationMatches(ctx context.Context, src types.ImageSource, srcManifest []byte, srcMIMEType string,
+	dest types.ImageSource, destManifest []byte, destMIMEType string, options *copy.Options) (bool, error) {
+	if sameManifestDigest(srcManifest, destManifest) {
+		return true, nil
+	}
+	if !manifest.MIMETypeIsMultiImage(srcMIMEType) {
+		if manifest.MIMETypeIsMultiImage(destMIMEType) {
+			return false, nil
+		}
+		return sameImage(ctx, options, src, nil, dest, nil)
+	}
+
+	switch {
+	case options.ImageListSelection == copy.CopySystemImage || !manifest.MIMETypeIsMultiImage(destMIMEType):
+		// copy.Image copies only a single image if asked to, or if the destination can not store manifest lists.
+		instance, err := image.ChooseManifestInstanceFromManifestList(ctx, options.SourceCtx, image.UnparsedInstance(src, nil))
+		if err != nil {
+			return false, errors.Wrapf(err, "Error choosing an image from manifest list")
+		}
+		if destDigest, err := manifest.Digest(destManifest); err == nil && destDigest == instance {
+			return true, nil
+		}
+		if manifest.MIMETypeIsMultiImage(destMIMEType) {
+			return false, nil
+		}
+		return sameImage(ctx, options, src, &instance, dest, nil)
+
+	case options.ImageListSelection == copy.CopyAllImages:
+		srcList, err := manifest.ListFromBlob(srcManifest, srcMIMEType)
+		if err != nil {
+			return false, errors.Wrapf(err, "Error parsing source manifest list")
+		}
+		destList, err := manifest.ListFromBlob(destManifest, destMIMEType)
+		if err != nil {
+			return false, errors.Wrapf(err, "Error parsing destination manifest list")
+		}
+		srcInstances, destInstances := srcList.Instances(), destList.Instances()
+		if len(srcInstances) != len(destInstances) {
+			return false, nil
+		}
+		for i := range srcInstances {
+			if srcInstances[i].Digest == destInstances[i].Digest {
+				continue
+			}
+			same, err := sameImage(ctx, options, src, &srcInstances[i].Digest, dest, &destInstances[i].Digest)
+			if err != nil || !same {
+				return false, err
+			}
+		}
+		return true, nil
+
+	default:
+		return false, nil
+	}
+}
+
+// sameManifestDigest returns true if manifests a and b have the same digest.
+func sameManifestDigest(a, b []byte) bool {
+	da, err := manifest.Digest(a)
+	if err != nil {
+		return false
+	}
+	db, err := manifest.Digest(b)
+	if err != nil {
+		return false
+	}
+	return da == db
+}
+
+// sameImage returns true if the single image srcInstance in src (or the top-level image, if nil) has the same layers as
+// destInstance in dest, and a config which is the same, or equivalent after conversion between manifest formats.
+func sameImage(ctx context.Context, options *copy.Options, src types.ImageSource, srcInstance *digest.Digest, dest types.ImageSource, destInstance *digest.Digest) (bool, error) {
+	srcImg, err := image.FromUnparsedImage(ctx, options.SourceCtx, image.UnparsedInstance(src, srcInstance))
+	if err != nil {
+		return false, errors.Wrapf(err, "Error reading source image")
+	}
+	destImg, err := image.FromUnparsedImage(ctx, options.DestinationCtx, image.UnparsedInstance(dest, destInstance))
+	if err != nil {
+		return false, errors.Wrapf(err, "Error reading destination image")
+	}
+
+	srcLayers, destLayers := srcImg.LayerInfos(), destImg.LayerInfos()
+	if len(srcLayers) != len(destLayers) {
+		return false, nil
+	}
+	for i := range srcLayers {
+		if srcLayers[i].Digest != destLayers[i].Digest {
+			return false, nil
+		}
+	}
+
+	if srcConfig := srcImg.ConfigInfo().Digest; srcConfig != "" && srcConfig == destImg.ConfigInfo().Digest {
+		return true, nil
+	}
+	// Converting between manifest formats rewrites the config; compare its contents instead.
+	srcConfig, err := ociConfigBlob(ctx, srcImg)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error reading source image config")
+	}
+	destConfig, err := ociConfigBlob(ctx, destImg)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error reading destination image config")
+	}
+	return bytes.Equal(srcConfig, destConfig), nil
+}
+
+// ociConfigBlob returns img's config, converted to the OCI format, in a canonical JSON representation.
+func ociConfigBlob(ctx context.Context, img types.Image) ([]byte, error) {
+	config, err := img.OCIConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(config)
+}
+
+// openedSourceReference is a types.ImageReference which returns an already open ImageSource from NewImageSource,
+// so that copy.Image can reuse the source opened by syncImage.
+type openedSourceReference struct {
+	types.ImageReference
+	src types.ImageSource
+}
+
+// NewImageSource returns the already open source, which is not closed when the caller closes it.
+func (ref openedSourceReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return nonClosingImageSource{ref.src}, nil
+}
+
+// nonClosingImageSource is a types.ImageSource for which Close does nothing; the underlying source is closed by its owner.
+type nonClosingImageSource struct {
+	types.ImageSource
+}
+
+// Close does nothing.
+func (src nonClosingImageSource) Close() error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/internal/testing/testimage"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/oci/layout"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putTestImage writes an image with a config containing cmd, and a single layer, to a dir: image in dir.
+func putTestImage(t *testing.T, dir, cmd string) {
+	err := os.MkdirAll(dir, 0755)
+	require.NoError(t, err)
+	ref, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	config := []byte(`{"architecture":"amd64","os":"linux","config":{"Cmd":["` + cmd + `"]}}`)
+	testimage.Put(t, nil, ref, manifest.DockerV2Schema2MediaType, config, testimage.Gzip(t, []byte("layer")))
+}
+
+func TestDirectoryImagesAndImages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sync-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcRoot := filepath.Join(tmpDir, "src")
+	putTestImage(t, filepath.Join(srcRoot, "ns", "first:v1"), "first")
+	putTestImage(t, filepath.Join(srcRoot, "second"), "second")
+	destRoot := filepath.Join(tmpDir, "dest")
+	err = os.MkdirAll(filepath.Join(destRoot, "ns"), 0755) // The dir: transport requires parent directories to exist.
+	require.NoError(t, err)
+
+	images, err := DirectoryImages(srcRoot)
+	require.NoError(t, err)
+	names := []string{}
+	for _, img := range images {
+		names = append(names, img.Name)
+	}
+	assert.Equal(t, []string{"ns/first:v1", "second:latest"}, names)
+
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+	dest := Destination{Transport: directory.Transport, Prefix: destRoot}
+
+	missingRef, err := directory.NewReference(filepath.Join(tmpDir, "missing"))
+	require.NoError(t, err)
+	report := Images(context.Background(), policyContext, append(images, Image{Source: missingRef, Name: "missing:latest"}), dest, nil)
+	require.Len(t, report.Images, 3)
+	for i, expected := range []Status{Copied, Copied, Failed} {
+		assert.Equal(t, expected, report.Images[i].Status, report.Images[i].Image.Name)
+	}
+	assert.NoError(t, report.Images[0].Err)
+	assert.NotEqual(t, "", report.Images[0].ManifestDigest)
+	assert.Error(t, report.Images[2].Err)
+	assert.Error(t, report.Err())
+	_, err = os.Stat(filepath.Join(destRoot, "ns", "first:v1", "manifest.json"))
+	assert.NoError(t, err)
+
+	// Images which already exist are skipped
+	report = Images(context.Background(), policyContext, images, dest, nil)
+	require.Len(t, report.Images, 2)
+	for _, result := range report.Images {
+		assert.Equal(t, Skipped, result.Status, result.Image.Name)
+		assert.NotEqual(t, "", result.ManifestDigest)
+	}
+	assert.NoError(t, report.Err())
+}
+
+// countingReference is a types.ImageReference which counts calls to NewImageSource.
+type countingReference struct {
+	types.ImageReference
+	sources *int
+}
+
+func (ref countingReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	*ref.sources++
+	return ref.ImageReference.NewImageSource(ctx, sys)
+}
+
+func TestImagesRewrittenManifests(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sync-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcDir := filepath.Join(tmpDir, "src")
+	putTestImage(t, srcDir, "first")
+	dirRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	sources := 0
+	images := []Image{{Source: countingReference{ImageReference: dirRef, sources: &sources}, Name: "ns/image:tag"}}
+
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+	// The oci: transport converts the schema2 manifest to an OCI manifest.
+	dest := Destination{Transport: layout.Transport, Prefix: filepath.Join(tmpDir, "oci")}
+	err = os.MkdirAll(filepath.Join(dest.Prefix, "ns"), 0755) // The oci: transport requires parent directories to exist.
+	require.NoError(t, err)
+
+	report := Images(context.Background(), policyContext, images, dest, nil)
+	require.NoError(t, report.Err())
+	require.Len(t, report.Images, 1)
+	assert.Equal(t, Copied, report.Images[0].Status)
+	copiedDigest := report.Images[0].ManifestDigest
+	srcManifest, err := ioutil.ReadFile(filepath.Join(srcDir, "manifest.json"))
+	require.NoError(t, err)
+	assert.NotEqual(t, digest.FromBytes(srcManifest), copiedDigest)
+	// The source is opened only once, and reused for copying.
+	assert.Equal(t, 1, sources)
+
+	// The converted image is not copied again.
+	report = Images(context.Background(), policyContext, images, dest, nil)
+	require.NoError(t, report.Err())
+	require.Len(t, report.Images, 1)
+	assert.Equal(t, Skipped, report.Images[0].Status)
+	assert.Equal(t, copiedDigest, report.Images[0].ManifestDigest)
+
+	// A modified source image is copied.
+	putTestImage(t, srcDir, "second")
+	report = Images(context.Background(), policyContext, images, dest, nil)
+	require.NoError(t, report.Err())
+	require.Len(t, report.Images, 1)
+	assert.Equal(t, Copied, report.Images[0].Status)
+	assert.NotEqual(t, copiedDigest, report.Images[0].ManifestDigest)
+}
+
+func TestReferenceImages(t *testing.T) {
+	tagged, err := docker.ParseReference("//example.com/ns/image:tag")
+	require.NoError(t, err)
+	digested, err := docker.ParseReference("//busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	images, err := ReferenceImages([]types.ImageReference{tagged, digested})
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, "ns/image:tag", images[0].Name)
+	assert.Equal(t, "library/busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000", images[1].Name)
+
+	dirRef, err := directory.NewReference("/")
+	require.NoError(t, err)
+	_, err = ReferenceImages([]types.ImageReference{dirRef})
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/pkg/compression"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -90,6 +91,16 @@ type ImageReference interface {
 	DeleteImage(ctx context.Context, sys *SystemContext) error
 }
 
+// ImageReferenceWithInspectableDestination is an optional interface implemented by ImageReferences whose destinations
+// can be inspected without modifying them, e.g. by copy.PlanImage.
+type ImageReferenceWithInspectableDestination interface {
+	ImageReference
+	// DestinationIsInspectable returns true if calling NewImageDestination, and checking for existing blobs using
+	// TryReusingBlob with a BlobInfoCache which neither records nor suggests anything, does not modify the destination.
+	// (This is not the case e.g. for dir:, which removes any previous contents, or oci:, which creates the layout structure.)
+	DestinationIsInspectable() bool
+}
+
 // BlobInfo collects known information about a blob (layer/config).
 // In some situations, some fields may be unknown, in others they may be mandatory; documenting an “unknown” value here does not override that.
 type BlobInfo struct {
@@ -98,6 +109,14 @@ type BlobInfo struct {
 	URLs        []string
 	Annotations map[string]string
 	MediaType   string
+	// CompressionOperation is used in Image.UpdateLayerInfos to instruct
+	// whether the original layer was preserved or (de)compressed. The
+	// field defaults to preserving the original layer.
+	CompressionOperation LayerCompression
+	// CompressionAlgorithm is used in Image.UpdateLayerInfos to set the correct
+	// MIME type for compressed layers (e.g., gzip or zstd). This field MUST be
+	// set when CompressionOperation == Compress.
+	CompressionAlgorithm *compression.Algorithm
 }
 
 // BICTransportScope encapsulates transport-dependent representation of a “scope” where blobs are or are not present.
@@ -231,6 +250,9 @@ const (
 // PutSignatures, if called, MUST be called after PutManifest (signatures reference manifest contents)
 // Finally, Commit MUST be called if the caller wants the image, as formed by the components saved above, to persist.
 //
+// When storing a manifest list, the instances are written first, each using PutManifest/PutSignatures with a non-nil instanceDigest,
+// and the manifest list itself is written last, with a nil instanceDigest.
+//
 // Each ImageDestination should eventually be closed by calling Close().
 type ImageDestination interface {
 	// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
@@ -241,6 +263,7 @@ type ImageDestination interface {
 
 	// SupportedManifestMIMETypes tells which manifest mime types the destination supports
 	// If an empty slice or nil it's returned, then any mime type can be tried to upload
+	// Manifest list MIME types should be included only if the destination can store manifest lists along with their instances.
 	SupportedManifestMIMETypes() []string
 	// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
 	// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
@@ -277,11 +300,19 @@ type ImageDestination interface {
 	// May use and/or update cache.
 	TryReusingBlob(ctx context.Context, info BlobInfo, cache BlobInfoCache, canSubstitute bool) (bool, BlobInfo, error)
 	// PutManifest writes manifest to the destination.
+	// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for
+	// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+	// It is expected but not enforced that the instanceDigest, when specified, matches the digest of `manifest` as generated
+	// by `manifest.Digest()`.
 	// FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
 	// If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 	// but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
-	PutManifest(ctx context.Context, manifest []byte) error
-	PutSignatures(ctx context.Context, signatures [][]byte) error
+	PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error
+	// PutSignatures writes a set of signatures to the destination.
+	// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write or overwrite the signatures for
+	// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+	// MUST be called after PutManifest (signatures may reference manifest contents).
+	PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error
 	// Commit marks the process of storing the image as successful and asks for the image to be persisted.
 	// WARNING: This does not have any transactional semantics:
 	// - Uploaded data MAY be visible to others before Commit() is called
@@ -372,7 +403,7 @@ type ImageCloser interface {
 
 // ManifestUpdateOptions is a way to pass named optional arguments to Image.UpdatedManifest
 type ManifestUpdateOptions struct {
-	LayerInfos              []BlobInfo // Complete BlobInfos (size+digest+urls+annotations) which should replace the originals, in order (the root layer first, and then successive layered layers). BlobInfos' MediaType fields are ignored.
+	LayerInfos              []BlobInfo // Complete BlobInfos (size+digest+urls+annotations) which should replace the originals, in order (the root layer first, and then successive layered layers). BlobInfos' MediaType fields are ignored; the layer MIME types are updated based on CompressionOperation and CompressionAlgorithm.
 	EmbeddedDockerReference reference.Named
 	ManifestMIMEType        string
 	// The values below are NOT requests to modify the image; they provide optional context which may or may not be used.
@@ -404,6 +435,8 @@ type ImageInspectInfo struct {
 type DockerAuthConfig struct {
 	Username string
 	Password string
+	// IdentityToken, if not empty, is an OAuth2 refresh token used to obtain access tokens instead of Password.
+	IdentityToken string
 }
 
 // OptionalBool is a boolean with an additional undefined value, which is meant
@@ -457,8 +490,19 @@ type SystemContext struct {
 	ArchitectureChoice string
 	// If not "", overrides the use of platform.GOOS when choosing an image or verifying OS match.
 	OSChoice string
+	// If not "", overrides the detected CPU variant (e.g. "v7" for arm) when choosing an image.
+	VariantChoice string
+	// If not "", the OS version of the system (e.g. a Windows build like "10.0.17763.194"); when choosing an image,
+	// images built for the same version are preferred, and images which specify a different build are not used.
+	OSVersionChoice string
 	// If not "", overrides the system's default directory containing a blob info cache.
 	BlobInfoCacheDir string
+	// If not nil, the compression algorithm used when layers are compressed while writing to a destination; gzip if nil.
+	CompressionFormat *compression.Algorithm
+	// If not nil, the algorithm-specific compression level used when layers are compressed while writing to a destination.
+	// Not all levels are honored: xz ignores the level, and the zstd implementation only distinguishes levels below 3
+	// (the fastest setting) from levels 3 and above (its default setting).
+	CompressionLevel *int
 
 	// Additional tags when creating or copying a docker-archive.
 	DockerArchiveAdditionalTags []reference.NamedTagged
@@ -493,6 +537,17 @@ type SystemContext struct {
 	// Note that this field is used mainly to integrate containers/image into projectatomic/docker
 	// in order to not break any existing docker's integration tests.
 	DockerDisableV1Ping bool
+	// If > 0, blobs are uploaded to registries in chunks of (at most) this many bytes, and an upload interrupted by
+	// a failure is resumed from the position reported by the registry, instead of starting again.
+	// If 0, each blob is uploaded using a single request.
+	DockerRegistryPushChunkSize int64
+	// The number of times an idempotent request to a registry (e.g. reading a manifest or a blob, checking whether a blob exists,
+	// or obtaining a token) is retried if it fails with a transient error (a dropped connection, a timeout,
+	// 429 Too Many Requests, or a 5xx status other than 501/505). If 0, requests are not retried.
+	DockerRegistryRetryAttempts int
+	// The delay before the first retry; it doubles with every attempt. A Retry-After header sent by the registry takes
+	// precedence if it requests a longer delay. If 0, a default of 1 second is used.
+	DockerRegistryRetryDelay time.Duration
 	// Directory to use for OSTree temporary files
 	OSTreeTmpDirPath string
 
@@ -511,9 +566,38 @@ type SystemContext struct {
 	DirForceCompress bool
 }
 
+// ProgressEvent is the type of an event reported in ProgressProperties.
+type ProgressEvent uint
+
+const (
+	// ProgressEventNewArtifact is reported when copying of an artifact (a blob) starts.
+	ProgressEventNewArtifact ProgressEvent = iota
+	// ProgressEventRead is reported periodically while an artifact is being copied; Offset and OffsetUpdate are set.
+	ProgressEventRead
+	// ProgressEventDone is reported when an artifact was successfully copied; Offset is the number of bytes sent to the destination.
+	ProgressEventDone
+	// ProgressEventSkipped is reported when an artifact is not copied, because it already exists at the destination
+	// or because it is a foreign layer.
+	ProgressEventSkipped
+	// ProgressEventFailed is reported when copying an artifact, for which ProgressEventNewArtifact was reported, fails; Err is set.
+	ProgressEventFailed
+	// ProgressEventManifestWritten is reported when a manifest has been written; Artifact describes the manifest.
+	ProgressEventManifestWritten
+	// ProgressEventSignaturesWritten is reported when signatures have been written; Artifact describes the signed manifest.
+	ProgressEventSignaturesWritten
+)
+
 // ProgressProperties is used to pass information from the copy code to a monitor which
 // can use the real-time information to produce output or react to changes.
 type ProgressProperties struct {
-	Artifact BlobInfo
-	Offset   uint64
+	Event    ProgressEvent
+	Artifact BlobInfo // Artifact.Size is the total size of the artifact, if known, or -1.
+	// Offset is the number of bytes of Artifact sent to the destination so far (after any compression or decompression),
+	// and OffsetUpdate the number of bytes sent since the previous ProgressEventRead for the artifact.
+	Offset       uint64
+	OffsetUpdate uint64
+	// DestInfo describes the artifact as written to the destination, for ProgressEventDone and ProgressEventSkipped.
+	// DestInfo.CompressionOperation and CompressionAlgorithm describe how the artifact was modified, if at all.
+	DestInfo BlobInfo
+	Err      error // For ProgressEventFailed
 }